@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/client"
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/weldr"
+	"github.com/ondrejbudai/osbuild-image/internal/weldr-image"
+)
+
+// verb is an action of a subcommand grouping several API calls, e.g. the
+// "list" in "osbuild-image blueprint list"
+type verb struct {
+	usage       string
+	description string
+	run         func(args []string) error
+}
+
+// runVerb runs the verb named by the first argument
+func runVerb(command string, verbs map[string]verb, args []string) error {
+	usage := func() {
+		var names []string
+		for name := range verbs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		fmt.Fprintf(os.Stderr, "Usage: %s %s VERB [flags] [args]\n\nVerbs:\n", os.Args[0], command)
+		w := tabwriter.NewWriter(os.Stderr, 0, 8, 2, ' ', 0)
+		for _, name := range names {
+			fmt.Fprintf(w, "  %s\t%s\n", verbs[name].usage, verbs[name].description)
+		}
+		w.Flush()
+	}
+
+	if len(args) == 0 {
+		usage()
+		return fmt.Errorf("no %s verb given", command)
+	}
+
+	v, ok := verbs[args[0]]
+	if !ok {
+		usage()
+		return fmt.Errorf("unknown %s verb: %s", command, args[0])
+	}
+
+	return v.run(args[1:])
+}
+
+// apiCommand holds the flags shared by the verbs calling the API and printing
+// the result either as a table or as json
+type apiCommand struct {
+	fs       *flag.FlagSet
+	endpoint *endpointFlags
+	json     bool
+}
+
+// newAPICommand creates the flag set of a verb, e.g. "blueprint info" taking
+// the "NAME..." arguments
+func newAPICommand(name, arguments string) *apiCommand {
+	c := &apiCommand{
+		fs: flag.NewFlagSet(name, flag.ExitOnError),
+	}
+	c.endpoint = addEndpointFlags(c.fs)
+	c.fs.Usage = func() {
+		fmt.Fprintf(c.fs.Output(), "Usage: %s %s [flags] %s\n", os.Args[0], name, arguments)
+		c.fs.PrintDefaults()
+	}
+	return c
+}
+
+// withOutput adds the --json flag to the verbs printing a result
+func (c *apiCommand) withOutput() *apiCommand {
+	c.fs.BoolVar(&c.json, "json", false, "print the result as json instead of a table")
+	return c
+}
+
+// parse parses the arguments and checks the number of the positional ones,
+// max < 0 means there's no maximum
+func (c *apiCommand) parse(args []string, min, max int) ([]string, error) {
+	_ = c.fs.Parse(args)
+
+	n := c.fs.NArg()
+	if n < min || (max >= 0 && n > max) {
+		c.fs.Usage()
+		return nil, errors.New("wrong number of arguments")
+	}
+
+	return c.fs.Args(), nil
+}
+
+// client returns a client of the selected endpoint and a context cancelled
+// when osbuild-image is interrupted
+func (c *apiCommand) client() (context.Context, context.CancelFunc, *http.Client, error) {
+	httpClient, err := c.endpoint.client()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("cannot configure the osbuild-composer endpoint: %v", err)
+	}
+
+	ctx, cancel := interruptibleContext(context.Background())
+	return ctx, cancel, httpClient, nil
+}
+
+// print prints v as json if --json was given, otherwise it calls table with
+// a tabwriter writing to stdout
+func (c *apiCommand) print(v interface{}, table func(w io.Writer)) error {
+	if c.json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+// checkResponse converts a failed API call into an APIError, see
+// weldr_image.TranslateError
+func checkResponse(message string, response *client.APIResponse, err error) error {
+	if err := weldr_image.TranslateError(response, err); err != nil {
+		return &weldr_image.APIError{Message: message, Cause: err}
+	}
+
+	return nil
+}
+
+// checkResponseErrors converts the errors embedded in a successful response,
+// e.g. unknown blueprints in a blueprint info response, into an error
+func checkResponseErrors(errs []weldr.ResponseError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	var messages []string
+	for _, e := range errs {
+		messages = append(messages, fmt.Sprintf("%s: %s", e.ID, e.Msg))
+	}
+
+	return errors.New(strings.Join(messages, "\n"))
+}
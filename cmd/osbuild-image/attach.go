@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ondrejbudai/osbuild-image/internal/weldr-image"
+)
+
+// attachFlags are the flags of the subcommands attaching to an existing
+// compose
+type attachFlags struct {
+	composeID     string
+	blueprintName string
+	imagePath     string
+	manifestPath  string
+	logPath       string
+	checksum      string
+	keepArtifacts bool
+	progress      string
+	timeout       time.Duration
+	endpoint      *endpointFlags
+}
+
+func addAttachFlags(fs *flag.FlagSet, flags *attachFlags) {
+	fs.StringVar(&flags.composeID, "compose", "", "uuid of the compose")
+	fs.StringVar(&flags.blueprintName, "blueprint", "", "name of the compose's blueprint, it's deleted together with the last of its composes (optional, e.g. the one printed by --detach)")
+	fs.StringVar(&flags.imagePath, "output", "", "path where the image will be saved")
+	fs.StringVar(&flags.manifestPath, "output-manifest", "", "path where the manifest will be saved (optional, it's not saved if no path is given)")
+	fs.StringVar(&flags.logPath, "output-log", "", "path where the log will be saved (optional, it's not saved if no path is given)")
+	fs.StringVar(&flags.checksum, "checksum", "", "expected checksum of the image in the sha256:HEX form (optional)")
+	fs.StringVar(&flags.progress, "progress", "human", "how to report the progress: human (to stderr), json (newline-delimited events to stderr) or none")
+	fs.DurationVar(&flags.timeout, "timeout", 0, "maximal duration of waiting for the compose, e.g. 1h30m (optional, no timeout by default)")
+	flags.endpoint = addEndpointFlags(fs)
+}
+
+// attach waits for an existing compose and fetches the requested artifacts
+func attach(flags *attachFlags) error {
+	composeID, err := uuid.Parse(flags.composeID)
+	if err != nil {
+		return fmt.Errorf("invalid compose uuid %q: %v", flags.composeID, err)
+	}
+
+	observer, err := newProgressObserver(flags.progress, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	c, err := flags.endpoint.client()
+	if err != nil {
+		return fmt.Errorf("cannot configure the osbuild-composer endpoint: %v", err)
+	}
+
+	req := &weldr_image.Request{
+		Images: []weldr_image.Image{
+			{
+				ComposeID:    composeID,
+				Path:         flags.imagePath,
+				Checksum:     flags.checksum,
+				ManifestPath: flags.manifestPath,
+				LogPath:      flags.logPath,
+			},
+		},
+		KeepArtifacts: flags.keepArtifacts,
+		Client:        c,
+		Observer:      observer,
+	}
+
+	ctx, cancel := buildContext(flags.timeout)
+	defer cancel()
+
+	results, err := req.ProcessContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	if flags.blueprintName != "" && !flags.keepArtifacts {
+		_, err := weldr_image.DeleteBlueprintIfUnused(ctx, c, flags.blueprintName)
+		if err != nil {
+			log.Printf("cannot delete the blueprint: %v\n", err)
+		}
+	}
+
+	compose := results[0].Compose
+	fmt.Printf("compose %s %s\n", composeID, compose.QueueStatus.ToString())
+	for _, upload := range compose.Uploads {
+		fmt.Printf("upload %s %s %s %s\n", upload.UUID, upload.ProviderName, upload.ImageName, upload.Status.ToString())
+	}
+	return nil
+}
+
+// detachedCompose describes a compose started with --detach
+type detachedCompose struct {
+	ID            string `json:"id"`
+	ImageType     string `json:"image_type"`
+	BlueprintName string `json:"blueprint_name"`
+}
+
+// printDetached prints the composes started with --detach to stdout, either
+// as text or json
+func printDetached(results []weldr_image.ImageResult, format string) error {
+	var composes []detachedCompose
+	for _, result := range results {
+		composes = append(composes, detachedCompose{
+			ID:            result.ComposeID.String(),
+			ImageType:     result.ImageType,
+			BlueprintName: result.BlueprintName,
+		})
+	}
+
+	if format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(composes)
+	}
+
+	for _, compose := range composes {
+		fmt.Printf("compose %s %s blueprint %s\n", compose.ID, compose.ImageType, compose.BlueprintName)
+	}
+	return nil
+}
+
+// fetchCommand fetches the artifacts of an existing compose, waiting for it
+// to finish if needed. The compose is deleted afterwards unless
+// --keep-artifacts is given.
+func fetchCommand(args []string) error {
+	var flags attachFlags
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	addAttachFlags(fs, &flags)
+	fs.BoolVar(&flags.keepArtifacts, "keep-artifacts", false, "whether osbuild-image should keep the compose after fetching its artifacts")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s fetch --compose UUID --output PATH [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if flags.composeID == "" {
+		fs.Usage()
+		return errors.New("compose uuid cannot be empty")
+	}
+	if flags.imagePath == "" {
+		fs.Usage()
+		return errors.New("image path cannot be empty")
+	}
+
+	return attach(&flags)
+}
+
+// waitCommand waits for an existing compose to finish. The compose is always
+// kept, but its artifacts can be fetched as well if the output paths are
+// given.
+func waitCommand(args []string) error {
+	var flags attachFlags
+	fs := flag.NewFlagSet("wait", flag.ExitOnError)
+	addAttachFlags(fs, &flags)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s wait --compose UUID [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if flags.composeID == "" {
+		fs.Usage()
+		return errors.New("compose uuid cannot be empty")
+	}
+
+	flags.keepArtifacts = true
+	return attach(&flags)
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/google/uuid"
+
+	"github.com/ondrejbudai/osbuild-image/internal/weldr-image"
+)
+
+// batchCleanupTimeout limits how long deleting the blueprints pushed by a
+// batch can take, the deletion runs even if the batch was interrupted
+const batchCleanupTimeout = 30 * time.Second
+
+// batchFile is a declarative description of many builds
+//
+// Example:
+//
+//	concurrency = 2
+//	results = "results.json"
+//
+//	[[jobs]]
+//	blueprint = "base.toml"
+//	type = "qcow2"
+//	output = "base.qcow2"
+//	manifest = "base.qcow2.manifest.json"
+//	log = "base.qcow2.log"
+type batchFile struct {
+	Concurrency int        `toml:"concurrency"`
+	Results     string     `toml:"results"`
+	Jobs        []batchJob `toml:"jobs"`
+}
+
+type batchJob struct {
+	Blueprint string `toml:"blueprint"`
+	Type      string `toml:"type"`
+	Output    string `toml:"output"`
+	Checksum  string `toml:"checksum"`
+	Manifest  string `toml:"manifest"`
+	Log       string `toml:"log"`
+}
+
+// batchJobResult is one entry of the results file
+type batchJobResult struct {
+	Blueprint     string  `json:"blueprint,omitempty"`
+	BlueprintName string  `json:"blueprint_name"`
+	Type          string  `json:"type"`
+	Output        string  `json:"output"`
+	ComposeID     string  `json:"compose_id,omitempty"`
+	Status        string  `json:"status"`
+	ComposeStatus string  `json:"compose_status,omitempty"`
+	QueueDuration float64 `json:"queue_duration,omitempty"`
+	BuildDuration float64 `json:"build_duration,omitempty"`
+	Error         string  `json:"error,omitempty"`
+}
+
+type batchResults struct {
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
+	Jobs      []batchJobResult `json:"jobs"`
+}
+
+// loadBatchFile reads the batch file and resolves all the paths in it
+// relative to the directory of the file
+func loadBatchFile(path string) (*batchFile, error) {
+	var batch batchFile
+	_, err := toml.DecodeFile(path, &batch)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode the batch file: %v", err)
+	}
+
+	if len(batch.Jobs) == 0 {
+		return nil, errors.New("the batch file contains no jobs")
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	for i := range batch.Jobs {
+		job := &batch.Jobs[i]
+		if job.Type == "" {
+			return nil, fmt.Errorf("job %d: image type cannot be empty", i+1)
+		}
+		if job.Output == "" {
+			return nil, fmt.Errorf("job %d: output path cannot be empty", i+1)
+		}
+
+		job.Blueprint = resolve(job.Blueprint)
+		job.Output = resolve(job.Output)
+		job.Manifest = resolve(job.Manifest)
+		job.Log = resolve(job.Log)
+	}
+	batch.Results = resolve(batch.Results)
+
+	return &batch, nil
+}
+
+// batchBlueprints pushes every distinct blueprint used by the jobs only once
+type batchBlueprints struct {
+	// contents maps the blueprint names to their contents
+	contents map[string][]byte
+	// sources maps the blueprint names to the files they were loaded from
+	sources map[string]string
+	// names maps the blueprint files to the names of the blueprints
+	names map[string]string
+}
+
+// load loads all the blueprints of the jobs, identical blueprints are
+// deduplicated by their name. It's an error if two different files contain
+// two different blueprints with the same name.
+func (b *batchBlueprints) load(jobs []batchJob, loader *weldr_image.BlueprintLoader) error {
+	b.contents = make(map[string][]byte)
+	b.sources = make(map[string]string)
+	b.names = make(map[string]string)
+
+	for _, job := range jobs {
+		if _, loaded := b.names[job.Blueprint]; loaded {
+			continue
+		}
+
+		// all the jobs without a blueprint share one empty blueprint
+		if job.Blueprint == "" {
+			b.names[""] = ""
+			continue
+		}
+
+		loaded, err := loader.LoadFile(job.Blueprint)
+		if err != nil {
+			return fmt.Errorf("cannot load %s: %v", job.Blueprint, err)
+		}
+		name := loaded.Name
+
+		blueprint, err := loaded.EncodeJSON()
+		if err != nil {
+			return fmt.Errorf("cannot encode %s: %v", job.Blueprint, err)
+		}
+
+		if existing, ok := b.contents[name]; ok && !bytes.Equal(existing, blueprint) {
+			return fmt.Errorf("%s and %s define two different blueprints named %s", b.sources[name], job.Blueprint, name)
+		}
+
+		b.contents[name] = blueprint
+		b.sources[name] = job.Blueprint
+		b.names[job.Blueprint] = name
+	}
+
+	return nil
+}
+
+// push pushes all the loaded blueprints to osbuild-composer and returns the
+// names of the pushed ones. It stops at the first failure.
+func (b *batchBlueprints) push(ctx context.Context, c *http.Client) ([]string, error) {
+	var pushed []string
+
+	for name, content := range b.contents {
+		_, err := weldr_image.PushBlueprint(ctx, c, content)
+		if err != nil {
+			return pushed, err
+		}
+		pushed = append(pushed, name)
+	}
+
+	if _, ok := b.names[""]; ok {
+		name, err := weldr_image.PushBlueprint(ctx, c, nil)
+		if err != nil {
+			return pushed, err
+		}
+		pushed = append(pushed, name)
+		b.names[""] = name
+	}
+
+	return pushed, nil
+}
+
+func newBatchJobResult(job batchJob, blueprintName string, result weldr_image.ImageResult) batchJobResult {
+	jobResult := batchJobResult{
+		Blueprint:     job.Blueprint,
+		BlueprintName: blueprintName,
+		Type:          job.Type,
+		Output:        job.Output,
+		Status:        "succeeded",
+	}
+
+	if result.Err != nil {
+		jobResult.Status = "failed"
+		jobResult.Error = result.Err.Error()
+	}
+
+	if result.ComposeID != uuid.Nil {
+		jobResult.ComposeID = result.ComposeID.String()
+	}
+
+	compose := result.Compose
+	if compose.JobCreated != 0 {
+		jobResult.ComposeStatus = compose.QueueStatus.ToString()
+	}
+	if compose.JobCreated != 0 && compose.JobStarted != 0 {
+		jobResult.QueueDuration = compose.JobStarted - compose.JobCreated
+	}
+	if compose.JobStarted != 0 && compose.JobFinished != 0 {
+		jobResult.BuildDuration = compose.JobFinished - compose.JobStarted
+	}
+
+	return jobResult
+}
+
+// image returns the image built by the job
+func (job batchJob) image() weldr_image.Image {
+	return weldr_image.Image{
+		Type:         job.Type,
+		Path:         job.Output,
+		Checksum:     job.Checksum,
+		ManifestPath: job.Manifest,
+		LogPath:      job.Log,
+	}
+}
+
+// runBatchJob builds the image of one job and returns its result
+func runBatchJob(ctx context.Context, template weldr_image.Request, job batchJob, blueprintName string) batchJobResult {
+	req := template
+	req.BlueprintName = blueprintName
+	req.Images = []weldr_image.Image{job.image()}
+
+	results, err := req.ProcessContext(ctx)
+	if len(results) != 1 {
+		return newBatchJobResult(job, blueprintName, weldr_image.ImageResult{ImageType: job.Type, Err: err})
+	}
+
+	return newBatchJobResult(job, blueprintName, results[0])
+}
+
+func batchCommand(args []string) error {
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	concurrency := fs.Int("concurrency", 0, "maximal number of builds running at the same time (optional, overrides the batch file, 1 by default)")
+	resultsPath := fs.String("results", "", "path where the json results will be saved (optional, overrides the batch file)")
+	keepArtifacts := fs.Bool("keep-artifacts", false, "whether osbuild-image should keep all the artifacts (blueprints and composes)")
+	progress := fs.String("progress", "human", "how to report the progress of the builds: human (to stderr), json (newline-delimited events to stderr) or none")
+	timeout := fs.Duration("timeout", 0, "maximal duration of the whole batch, e.g. 10h (optional, no timeout by default)")
+	endpointFlags := addEndpointFlags(fs)
+	varFlags := addBlueprintVarFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s batch [flags] BATCH-FILE\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("exactly one batch file must be given")
+	}
+
+	batch, err := loadBatchFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *concurrency != 0 {
+		batch.Concurrency = *concurrency
+	}
+	if batch.Concurrency <= 0 {
+		batch.Concurrency = 1
+	}
+	if *resultsPath != "" {
+		batch.Results = *resultsPath
+	}
+
+	observer, err := newProgressObserver(*progress, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	c, err := endpointFlags.client()
+	if err != nil {
+		return fmt.Errorf("cannot configure the osbuild-composer endpoint: %v", err)
+	}
+
+	loader, err := varFlags.loader()
+	if err != nil {
+		return err
+	}
+
+	var blueprints batchBlueprints
+	err = blueprints.load(batch.Jobs, loader)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := buildContext(*timeout)
+	defer cancel()
+
+	// all the jobs share one request template, thus also one client
+	template := weldr_image.Request{
+		KeepArtifacts: *keepArtifacts,
+		Client:        c,
+		Observer:      observer,
+	}
+
+	validation := template
+	for _, job := range batch.Jobs {
+		validation.Images = append(validation.Images, job.image())
+	}
+	err = validation.ValidateContext(ctx)
+	if err != nil {
+		return fmt.Errorf("validation of the batch failed: %v", err)
+	}
+
+	pushed, err := blueprints.push(ctx, template.Client)
+	if !*keepArtifacts {
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), batchCleanupTimeout)
+			defer cancel()
+
+			for _, name := range pushed {
+				err := weldr_image.DeleteBlueprint(ctx, template.Client, name)
+				if err != nil {
+					log.Printf("cannot delete the blueprint: %v\n", err)
+				}
+			}
+		}()
+	}
+	if err != nil {
+		return err
+	}
+
+	results := make([]batchJobResult, len(batch.Jobs))
+	semaphore := make(chan struct{}, batch.Concurrency)
+	var wg sync.WaitGroup
+	for i, job := range batch.Jobs {
+		wg.Add(1)
+		go func(i int, job batchJob) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			results[i] = runBatchJob(ctx, template, job, blueprints.names[job.Blueprint])
+		}(i, job)
+	}
+	wg.Wait()
+
+	summary := batchResults{Jobs: results}
+	for _, result := range results {
+		if result.Status == "succeeded" {
+			summary.Succeeded++
+		} else {
+			summary.Failed++
+		}
+	}
+
+	if batch.Results != "" {
+		err := writeBatchResults(batch.Results, &summary)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "batch finished: %d succeeded, %d failed\n", summary.Succeeded, summary.Failed)
+	if summary.Failed > 0 {
+		return fmt.Errorf("%d of %d jobs failed", summary.Failed, len(results))
+	}
+
+	return nil
+}
+
+func writeBatchResults(path string, results *batchResults) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(path, append(data, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("cannot write the results file: %v", err)
+	}
+
+	return nil
+}
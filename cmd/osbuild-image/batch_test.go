@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/common"
+	"github.com/ondrejbudai/osbuild-image/internal/weldrtest"
+)
+
+const testBatchFile = `
+results = "results.json"
+
+[[jobs]]
+blueprint = "bp.toml"
+type = "qcow2"
+output = "image.qcow2"
+
+[[jobs]]
+blueprint = "bp.toml"
+type = "ami"
+output = "image.ami"
+`
+
+const testBatchBlueprint = `
+name = "batch"
+
+[[packages]]
+name = "tmux"
+`
+
+// runTestBatch runs the two jobs of testBatchFile against the server in a
+// temporary directory and returns the directory, the results and the error of
+// the batch, the directory is removed by the returned function
+func runTestBatch(t *testing.T, server *weldrtest.Server) (string, *batchResults, func(), error) {
+	dir, err := ioutil.TempDir("", "osbuild-image-test")
+	if err != nil {
+		t.Fatalf("cannot create a temporary directory: %v", err)
+	}
+	remove := func() { os.RemoveAll(dir) }
+
+	for name, content := range map[string]string{"batch.toml": testBatchFile, "bp.toml": testBatchBlueprint} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			remove()
+			t.Fatalf("cannot write %s: %v", name, err)
+		}
+	}
+
+	batchErr := batchCommand([]string{"--address", server.Address(), "--progress", "none", filepath.Join(dir, "batch.toml")})
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "results.json"))
+	if err != nil {
+		remove()
+		t.Fatalf("cannot read the results (batch error: %v): %v", batchErr, err)
+	}
+	var results batchResults
+	if err := json.Unmarshal(data, &results); err != nil {
+		remove()
+		t.Fatalf("cannot decode the results: %v", err)
+	}
+
+	return dir, &results, remove, batchErr
+}
+
+func TestBatchSucceeded(t *testing.T) {
+	server := weldrtest.NewServer()
+	defer server.Close()
+
+	dir, results, remove, err := runTestBatch(t, server)
+	defer remove()
+
+	if err != nil {
+		t.Fatalf("the batch failed: %v", err)
+	}
+	if results.Succeeded != 2 || results.Failed != 0 || len(results.Jobs) != 2 {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+
+	for i, output := range []string{"image.qcow2", "image.ami"} {
+		job := results.Jobs[i]
+		if job.Status != "succeeded" || job.BlueprintName != "batch" || job.ComposeID == "" {
+			t.Errorf("unexpected result of the job %d: %+v", i+1, job)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, output))
+		if err != nil || string(data) != string(server.Image) {
+			t.Errorf("unexpected image %s: %q %v", output, data, err)
+		}
+	}
+
+	if blueprints := server.Blueprints(); len(blueprints) > 0 {
+		t.Errorf("blueprints left behind: %v", blueprints)
+	}
+}
+
+func TestBatchPartiallyFailed(t *testing.T) {
+	server := weldrtest.NewServer()
+	defer server.Close()
+
+	server.SetStates("ami", common.IBWaiting, common.IBRunning, common.IBFailed)
+
+	dir, results, remove, err := runTestBatch(t, server)
+	defer remove()
+
+	if err == nil || err.Error() != "1 of 2 jobs failed" {
+		t.Errorf("expected the batch to fail with one failed job, got %v", err)
+	}
+	if results.Succeeded != 1 || results.Failed != 1 || len(results.Jobs) != 2 {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+
+	if job := results.Jobs[0]; job.Type != "qcow2" || job.Status != "succeeded" || job.Error != "" {
+		t.Errorf("unexpected result of the qcow2 job: %+v", job)
+	}
+	if job := results.Jobs[1]; job.Type != "ami" || job.Status != "failed" || job.ComposeStatus != "FAILED" || job.Error == "" {
+		t.Errorf("unexpected result of the ami job: %+v", job)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "image.ami")); !os.IsNotExist(err) {
+		t.Errorf("the image of the failed job was written")
+	}
+	if blueprints := server.Blueprints(); len(blueprints) > 0 {
+		t.Errorf("blueprints left behind: %v", blueprints)
+	}
+}
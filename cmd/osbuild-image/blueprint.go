@@ -0,0 +1,713 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/client"
+	"github.com/ondrejbudai/osbuild-image/internal/weldr-image"
+)
+
+// blueprintVerbs are the verbs of the blueprint subcommand managing the
+// blueprints stored in osbuild-composer
+var blueprintVerbs = map[string]verb{
+	"list":      {"list", "list the names of the blueprints", blueprintListCommand},
+	"info":      {"info NAME...", "show the blueprints", blueprintInfoCommand},
+	"freeze":    {"freeze NAME...", "show the blueprints with the depsolved package versions", blueprintFreezeCommand},
+	"diff":      {"diff NAME FROM TO", "show the differences between two commits (a hash, NEWEST or WORKSPACE)", blueprintDiffCommand},
+	"changes":   {"changes NAME...", "show the commit history of the blueprints", blueprintChangesCommand},
+	"push":      {"push FILE", "commit a json or toml blueprint", blueprintPushCommand},
+	"delete":    {"delete NAME", "delete a blueprint", blueprintDeleteCommand},
+	"undo":      {"undo NAME COMMIT", "revert a blueprint to a commit", blueprintUndoCommand},
+	"tag":       {"tag NAME", "tag the latest commit of a blueprint", blueprintTagCommand},
+	"workspace": {"workspace FILE", "store a json or toml blueprint in the workspace without committing it", blueprintWorkspaceCommand},
+	"discard":   {"discard NAME", "discard the uncommitted workspace changes of a blueprint", blueprintDiscardCommand},
+	"lint":      {"lint FILE...", "check json or toml blueprints without sending them to osbuild-composer", blueprintLintCommand},
+	"convert":   {"convert --to toml|json FILE", "convert a blueprint to toml or json in its normalized form", blueprintConvertCommand},
+}
+
+func blueprintCommand(args []string) error {
+	return runVerb("blueprint", blueprintVerbs, args)
+}
+
+// blueprintSummary is the part of a blueprint shown in the tables
+type blueprintSummary struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Version     string             `json:"version"`
+	Packages    []blueprintPackage `json:"packages"`
+	Modules     []blueprintPackage `json:"modules"`
+	Groups      []blueprintPackage `json:"groups"`
+}
+
+type blueprintPackage struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+func decodeBlueprintSummary(raw json.RawMessage) blueprintSummary {
+	var summary blueprintSummary
+	// a blueprint the table cannot describe is still printed with its name
+	// missing rather than failing the whole command
+	_ = json.Unmarshal(raw, &summary)
+	return summary
+}
+
+// printPackageRows prints one row per package, module and group of the
+// blueprint, prefix is printed at the beginning of each row
+func printPackageRows(w io.Writer, prefix string, bp blueprintSummary) {
+	for _, kind := range []struct {
+		name     string
+		packages []blueprintPackage
+	}{
+		{"package", bp.Packages},
+		{"module", bp.Modules},
+		{"group", bp.Groups},
+	} {
+		for _, p := range kind.packages {
+			version := p.Version
+			if version == "" {
+				version = "-"
+			}
+			fmt.Fprintf(w, "%s%s\t%s\t%s\n", prefix, kind.name, p.Name, version)
+		}
+	}
+}
+
+func blueprintListCommand(args []string) error {
+	cmd := newAPICommand("blueprint list", "").withOutput()
+	if _, err := cmd.parse(args, 0, 0); err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	names, response, err := client.ListBlueprintsV0(ctx, c)
+	if err := checkResponse("cannot list the blueprints", response, err); err != nil {
+		return err
+	}
+
+	return cmd.print(names, func(w io.Writer) {
+		for _, name := range names {
+			fmt.Fprintln(w, name)
+		}
+	})
+}
+
+func blueprintInfoCommand(args []string) error {
+	cmd := newAPICommand("blueprint info", "NAME...").withOutput()
+	asTOML := cmd.fs.Bool("toml", false, "print the blueprints as toml instead of a table")
+	names, err := cmd.parse(args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if *asTOML {
+		for i, name := range names {
+			blueprint, response, err := client.GetBlueprintInfoTOMLV0(ctx, c, name)
+			if err := checkResponse("cannot retrieve the blueprint "+name, response, err); err != nil {
+				return err
+			}
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Print(blueprint)
+		}
+		return nil
+	}
+
+	info, response, err := client.GetBlueprintsInfoV0(ctx, c, strings.Join(names, ","))
+	if err := checkResponse("cannot retrieve the blueprints", response, err); err != nil {
+		return err
+	}
+
+	changed := make(map[string]bool)
+	for _, change := range info.Changes {
+		changed[change.Name] = change.Changed
+	}
+
+	err = cmd.print(info, func(w io.Writer) {
+		for i, raw := range info.Blueprints {
+			bp := decodeBlueprintSummary(raw)
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "name:\t%s\n", bp.Name)
+			fmt.Fprintf(w, "description:\t%s\n", bp.Description)
+			fmt.Fprintf(w, "version:\t%s\n", bp.Version)
+			fmt.Fprintf(w, "changed:\t%t\n", changed[bp.Name])
+			printPackageRows(w, "", bp)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return checkResponseErrors(info.Errors)
+}
+
+func blueprintFreezeCommand(args []string) error {
+	cmd := newAPICommand("blueprint freeze", "NAME...").withOutput()
+	names, err := cmd.parse(args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	freeze, response, err := client.GetBlueprintsFreezeV0(ctx, c, strings.Join(names, ","))
+	if err := checkResponse("cannot freeze the blueprints", response, err); err != nil {
+		return err
+	}
+
+	err = cmd.print(freeze, func(w io.Writer) {
+		fmt.Fprintln(w, "BLUEPRINT\tKIND\tNAME\tVERSION")
+		for _, frozen := range freeze.Blueprints {
+			bp := decodeBlueprintSummary(frozen.Blueprint)
+			printPackageRows(w, bp.Name+"\t", bp)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return checkResponseErrors(freeze.Errors)
+}
+
+// describeDiffItem describes one side of a blueprint difference, e.g.
+// {"Package": {"name": "tmux", "version": "*"}} is described as
+// "Package tmux *"
+func describeDiffItem(raw json.RawMessage) string {
+	var item map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &item); err != nil || len(item) != 1 {
+		return string(raw)
+	}
+
+	for kind, value := range item {
+		var s string
+		if json.Unmarshal(value, &s) == nil {
+			return kind + " " + s
+		}
+
+		var p blueprintPackage
+		if json.Unmarshal(value, &p) == nil && p.Name != "" {
+			return strings.TrimSpace(kind + " " + p.Name + " " + p.Version)
+		}
+
+		return kind + " " + string(value)
+	}
+
+	return ""
+}
+
+func isNull(raw json.RawMessage) bool {
+	return len(raw) == 0 || string(raw) == "null"
+}
+
+func blueprintDiffCommand(args []string) error {
+	cmd := newAPICommand("blueprint diff", "NAME FROM TO").withOutput()
+	positional, err := cmd.parse(args, 3, 3)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	diff, response, err := client.GetBlueprintDiffV0(ctx, c, positional[0], positional[1], positional[2])
+	if err := checkResponse("cannot compare the blueprint's commits", response, err); err != nil {
+		return err
+	}
+
+	return cmd.print(diff, func(w io.Writer) {
+		for _, d := range diff {
+			switch {
+			case isNull(d.Old):
+				fmt.Fprintf(w, "+\t%s\n", describeDiffItem(d.New))
+			case isNull(d.New):
+				fmt.Fprintf(w, "-\t%s\n", describeDiffItem(d.Old))
+			default:
+				fmt.Fprintf(w, "~\t%s -> %s\n", describeDiffItem(d.Old), describeDiffItem(d.New))
+			}
+		}
+	})
+}
+
+func blueprintChangesCommand(args []string) error {
+	cmd := newAPICommand("blueprint changes", "NAME...").withOutput()
+	limit := cmd.fs.Uint("limit", 0, "maximal number of changes shown per blueprint (optional, the server's default is used if not given)")
+	names, err := cmd.parse(args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	changes, response, err := client.GetBlueprintsChangesV0(ctx, c, strings.Join(names, ","), *limit)
+	if err := checkResponse("cannot retrieve the changes of the blueprints", response, err); err != nil {
+		return err
+	}
+
+	err = cmd.print(changes, func(w io.Writer) {
+		fmt.Fprintln(w, "BLUEPRINT\tCOMMIT\tTIMESTAMP\tREVISION\tMESSAGE")
+		for _, bp := range changes.Blueprints {
+			for _, change := range bp.Changes {
+				revision := "-"
+				if change.Revision != nil {
+					revision = fmt.Sprint(*change.Revision)
+				}
+				message := strings.ReplaceAll(change.Message, "\n", " ")
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", bp.Name, change.Commit, change.Timestamp, revision, message)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return checkResponseErrors(changes.Errors)
+}
+
+// readBlueprintFile reads a blueprint file, unlike a build, the blueprint
+// commands have no use for an empty blueprint
+func readBlueprintFile(path string) ([]byte, error) {
+	blueprint, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the blueprint file: %v", err)
+	}
+	if len(strings.TrimSpace(string(blueprint))) == 0 {
+		return nil, errors.New("the blueprint file is empty")
+	}
+	return blueprint, nil
+}
+
+// blueprintVarFlags are the flags giving the variables of the blueprint
+// templates, see weldr_image.BlueprintLoader
+type blueprintVarFlags struct {
+	vars     stringList
+	varFiles stringList
+}
+
+func addBlueprintVarFlags(fs *flag.FlagSet) *blueprintVarFlags {
+	var f blueprintVarFlags
+	fs.Var(&f.vars, "var", "variable of the blueprint as KEY=VALUE, referenced as ${KEY} (optional, can be repeated, it overrides --var-file and the environment)")
+	fs.Var(&f.varFiles, "var-file", "file with the variables of the blueprint as KEY=VALUE lines (optional, can be repeated, the later files override the earlier ones)")
+	return &f
+}
+
+// loader returns the loader of the blueprints, the variables which are not
+// given by the flags are looked up in the environment
+func (f *blueprintVarFlags) loader() (*weldr_image.BlueprintLoader, error) {
+	vars := make(map[string]string)
+
+	for _, path := range f.varFiles.values {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the variables file: %v", err)
+		}
+		fileVars, err := weldr_image.ParseVars(data)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse the variables file %s: %v", path, err)
+		}
+		for name, value := range fileVars {
+			vars[name] = value
+		}
+	}
+
+	for _, assignment := range f.vars.values {
+		name, value, err := weldr_image.ParseVar(assignment)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --var %s: %v", assignment, err)
+		}
+		vars[name] = value
+	}
+
+	return &weldr_image.BlueprintLoader{
+		Vars:      vars,
+		LookupEnv: os.LookupEnv,
+	}, nil
+}
+
+// load loads a blueprint file with its variables and includes resolved and
+// returns the resulting blueprint as json
+func (f *blueprintVarFlags) load(path string) ([]byte, error) {
+	loader, err := f.loader()
+	if err != nil {
+		return nil, err
+	}
+
+	blueprint, err := loader.LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return blueprint.EncodeJSON()
+}
+
+// blueprintOverrideFlags are the flags changing the blueprint of a build
+// without editing its file, they're applied as a blueprint overlay
+type blueprintOverrideFlags struct {
+	packages     stringList
+	groups       stringList
+	users        stringList
+	services     stringList
+	hostname     string
+	kernelAppend string
+}
+
+func addBlueprintOverrideFlags(fs *flag.FlagSet) *blueprintOverrideFlags {
+	var f blueprintOverrideFlags
+	fs.Var(&f.packages, "add-package", "package added to the blueprint as NAME or NAME=VERSION, e.g. tmux=3.*, it replaces the version of a package already in the blueprint (optional, can be repeated)")
+	fs.Var(&f.groups, "add-group", "package group added to the blueprint (optional, can be repeated)")
+	fs.Var(&f.users, "add-user", "user added to the blueprint as NAME or NAME:SSH-KEY-FILE, only the key of a user already in the blueprint is set (optional, can be repeated)")
+	fs.Var(&f.services, "enable-service", "systemd unit enabled by the blueprint (optional, can be repeated)")
+	fs.StringVar(&f.hostname, "set-hostname", "", "hostname set by the blueprint (optional)")
+	fs.StringVar(&f.kernelAppend, "set-kernel-append", "", "kernel command line arguments set by the blueprint, they replace the ones of the blueprint (optional)")
+	return &f
+}
+
+// parsePackage parses NAME or NAME=VERSION, e.g. tmux=3.*. A dash cannot
+// separate the version, package names like xorg-x11-fonts-100dpi contain
+// dashes followed by digits.
+func parsePackage(spec string) (weldr_image.Package, error) {
+	parts := strings.SplitN(spec, "=", 2)
+	if parts[0] == "" {
+		return weldr_image.Package{}, fmt.Errorf("invalid --add-package %s: the package name cannot be empty", spec)
+	}
+
+	p := weldr_image.Package{Name: parts[0]}
+	if len(parts) == 2 {
+		if parts[1] == "" {
+			return weldr_image.Package{}, fmt.Errorf("invalid --add-package %s: the version cannot be empty", spec)
+		}
+		p.Version = parts[1]
+	}
+	return p, nil
+}
+
+// overlay returns the blueprint overlay of the flags, or nil if none of them
+// was given
+func (f *blueprintOverrideFlags) overlay() (*weldr_image.Blueprint, error) {
+	var overlay weldr_image.Blueprint
+	var customizations weldr_image.Customizations
+
+	for _, spec := range f.packages.values {
+		p, err := parsePackage(spec)
+		if err != nil {
+			return nil, err
+		}
+		overlay.Packages = append(overlay.Packages, p)
+	}
+	for _, name := range f.groups.values {
+		overlay.Groups = append(overlay.Groups, weldr_image.Group{Name: name})
+	}
+
+	for _, spec := range f.users.values {
+		parts := strings.SplitN(spec, ":", 2)
+		if parts[0] == "" {
+			return nil, fmt.Errorf("invalid --add-user %s: the user name cannot be empty", spec)
+		}
+
+		user := weldr_image.UserCustomization{Name: parts[0]}
+		if len(parts) == 2 {
+			key, err := ioutil.ReadFile(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("cannot read the ssh key of the user %s: %v", parts[0], err)
+			}
+			trimmed := strings.TrimSpace(string(key))
+			user.Key = &trimmed
+		}
+		customizations.User = append(customizations.User, user)
+	}
+
+	if len(f.services.values) > 0 {
+		customizations.Services = &weldr_image.ServicesCustomization{Enabled: f.services.values}
+	}
+	if f.hostname != "" {
+		customizations.Hostname = &f.hostname
+	}
+	if f.kernelAppend != "" {
+		customizations.Kernel = &weldr_image.KernelCustomization{Append: f.kernelAppend}
+	}
+
+	if customizations.User != nil || customizations.Services != nil || customizations.Hostname != nil || customizations.Kernel != nil {
+		overlay.Customizations = &customizations
+	}
+	if overlay.Packages == nil && overlay.Groups == nil && overlay.Customizations == nil {
+		return nil, nil
+	}
+	return &overlay, nil
+}
+
+func blueprintPushCommand(args []string) error {
+	cmd := newAPICommand("blueprint push", "FILE")
+	varFlags := addBlueprintVarFlags(cmd.fs)
+	positional, err := cmd.parse(args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	blueprint, err := varFlags.load(positional[0])
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	_, err = weldr_image.PushBlueprint(ctx, c, blueprint)
+	return err
+}
+
+func blueprintDeleteCommand(args []string) error {
+	cmd := newAPICommand("blueprint delete", "NAME")
+	positional, err := cmd.parse(args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return weldr_image.DeleteBlueprint(ctx, c, positional[0])
+}
+
+func blueprintUndoCommand(args []string) error {
+	cmd := newAPICommand("blueprint undo", "NAME COMMIT")
+	positional, err := cmd.parse(args, 2, 2)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	response, err := client.UndoBlueprintChangeV0(ctx, c, positional[0], positional[1])
+	return checkResponse("cannot revert the blueprint", response, err)
+}
+
+func blueprintTagCommand(args []string) error {
+	cmd := newAPICommand("blueprint tag", "NAME")
+	positional, err := cmd.parse(args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	response, err := client.TagBlueprintV0(ctx, c, positional[0])
+	return checkResponse("cannot tag the blueprint", response, err)
+}
+
+func blueprintWorkspaceCommand(args []string) error {
+	cmd := newAPICommand("blueprint workspace", "FILE")
+	varFlags := addBlueprintVarFlags(cmd.fs)
+	positional, err := cmd.parse(args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	blueprint, err := varFlags.load(positional[0])
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	_, err = weldr_image.PushBlueprintWorkspace(ctx, c, blueprint)
+	return err
+}
+
+func blueprintDiscardCommand(args []string) error {
+	cmd := newAPICommand("blueprint discard", "NAME")
+	positional, err := cmd.parse(args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	response, err := client.DeleteWorkspaceV0(ctx, c, positional[0])
+	return checkResponse("cannot discard the workspace changes", response, err)
+}
+
+// lintResult is the outcome of linting one blueprint file, as printed by
+// --json
+type lintResult struct {
+	File     string        `json:"file"`
+	Problems []lintProblem `json:"problems"`
+}
+
+type lintProblem struct {
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Key     string `json:"key,omitempty"`
+	Message string `json:"message"`
+}
+
+// blueprintLintCommand decodes the blueprints the same way they're decoded
+// before being pushed and reports all their problems, it doesn't need
+// osbuild-composer
+func blueprintLintCommand(args []string) error {
+	fs := flag.NewFlagSet("blueprint lint", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the problems as json instead of FILE:LINE:COLUMN: KEY: MESSAGE lines")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s blueprint lint [flags] FILE...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("wrong number of arguments")
+	}
+
+	results := []lintResult{}
+	invalid := 0
+	for _, path := range fs.Args() {
+		blueprint, err := readBlueprintFile(path)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+
+		var problems []weldr_image.BlueprintProblem
+		_, err = weldr_image.DecodeBlueprint(blueprint)
+		if blueprintError, ok := err.(*weldr_image.BlueprintError); ok {
+			problems = blueprintError.Problems
+			invalid++
+		} else if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+
+		result := lintResult{File: path, Problems: []lintProblem{}}
+		for _, p := range problems {
+			if !*asJSON {
+				separator := " "
+				if p.Line > 0 {
+					separator = ""
+				}
+				fmt.Printf("%s:%s%s\n", path, separator, p)
+			}
+			result.Problems = append(result.Problems, lintProblem{p.Line, p.Column, p.Key, p.Message})
+		}
+		results = append(results, result)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(results); err != nil {
+			return err
+		}
+	}
+
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d blueprints are invalid", invalid, len(results))
+	}
+	return nil
+}
+
+// blueprintConvertCommand converts a blueprint between json and toml, or
+// normalizes it if it's already in the target format. Comments of toml
+// blueprints are not kept.
+func blueprintConvertCommand(args []string) error {
+	fs := flag.NewFlagSet("blueprint convert", flag.ExitOnError)
+	to := fs.String("to", "", "format of the converted blueprint: toml or json")
+	output := fs.String("output", "", "path where the converted blueprint is saved (optional, it's printed otherwise, it can be the converted file)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s blueprint convert --to toml|json [flags] FILE\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("wrong number of arguments")
+	}
+
+	rawBlueprint, err := readBlueprintFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	blueprint, err := weldr_image.DecodeBlueprint(rawBlueprint)
+	if err != nil {
+		return err
+	}
+	blueprint.Normalize()
+
+	var converted []byte
+	switch *to {
+	case "toml":
+		converted, err = blueprint.EncodeTOML()
+	case "json":
+		converted, err = blueprint.EncodeJSON()
+	case "":
+		fs.Usage()
+		return errors.New("no target format given")
+	default:
+		return fmt.Errorf("unknown blueprint format: %s, valid formats: toml, json", *to)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot encode the blueprint as %s: %v", *to, err)
+	}
+
+	if *output == "" {
+		_, err = os.Stdout.Write(converted)
+		return err
+	}
+
+	err = ioutil.WriteFile(*output, converted, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot write the converted blueprint: %v", err)
+	}
+	return nil
+}
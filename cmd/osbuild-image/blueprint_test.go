@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ondrejbudai/osbuild-image/internal/weldr-image"
+)
+
+func TestParsePackage(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected weldr_image.Package
+	}{
+		{"tmux", weldr_image.Package{Name: "tmux"}},
+		{"tmux=3.*", weldr_image.Package{Name: "tmux", Version: "3.*"}},
+		{"vim-enhanced", weldr_image.Package{Name: "vim-enhanced"}},
+		{"xorg-x11-fonts-100dpi", weldr_image.Package{Name: "xorg-x11-fonts-100dpi"}},
+		{"python3-3.12", weldr_image.Package{Name: "python3-3.12"}},
+		{"bash=2:5.2.*", weldr_image.Package{Name: "bash", Version: "2:5.2.*"}},
+	}
+
+	for _, test := range tests {
+		p, err := parsePackage(test.spec)
+		if err != nil {
+			t.Errorf("cannot parse %s: %v", test.spec, err)
+		} else if p != test.expected {
+			t.Errorf("unexpected package %+v of %s, expected %+v", p, test.spec, test.expected)
+		}
+	}
+
+	for _, spec := range []string{"", "=3.*", "tmux="} {
+		if p, err := parsePackage(spec); err == nil {
+			t.Errorf("the invalid %q was parsed as %+v", spec, p)
+		}
+	}
+}
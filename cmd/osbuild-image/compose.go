@@ -0,0 +1,265 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/client"
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/common"
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/weldr"
+)
+
+// composeVerbs are the verbs of the compose subcommand inspecting and
+// managing the composes known to osbuild-composer
+var composeVerbs = map[string]verb{
+	"list":   {"list [STATE...]", "list the composes, optionally only the waiting, running, finished or failed ones", composeListCommand},
+	"queue":  {"queue", "list the composes waiting for a worker or being built", composeQueueCommand},
+	"cancel": {"cancel UUID...", "cancel waiting or running composes", composeCancelCommand},
+	"delete": {"delete UUID...", "delete finished or failed composes together with their artifacts", composeDeleteCommand},
+}
+
+func composeCommand(args []string) error {
+	return runVerb("compose", composeVerbs, args)
+}
+
+// parseStates parses the compose states given on the command line, e.g.
+// "running", all the states are returned if none is given
+func parseStates(args []string) ([]common.ImageBuildState, error) {
+	all := []common.ImageBuildState{common.IBWaiting, common.IBRunning, common.IBFinished, common.IBFailed}
+	if len(args) == 0 {
+		return all, nil
+	}
+
+	var states []common.ImageBuildState
+	for _, arg := range args {
+		found := false
+		for _, state := range all {
+			if strings.EqualFold(arg, state.ToString()) {
+				states = append(states, state)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown compose state: %s, valid states: waiting, running, finished, failed", arg)
+		}
+	}
+	return states, nil
+}
+
+// parseUUIDs checks that all the arguments are compose uuids
+func parseUUIDs(args []string) error {
+	for _, arg := range args {
+		if _, err := uuid.Parse(arg); err != nil {
+			return fmt.Errorf("invalid compose uuid %q: %v", arg, err)
+		}
+	}
+	return nil
+}
+
+// jobTime converts a job timestamp of a compose (seconds since the epoch) to
+// time, the zero time is returned if the timestamp is not set
+func jobTime(timestamp float64) time.Time {
+	if timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(timestamp*float64(time.Second)))
+}
+
+// formatJobTime formats a job timestamp of a compose in the local time zone
+func formatJobTime(timestamp float64) string {
+	t := jobTime(timestamp)
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// describeJobDuration describes how long the compose has been waiting or
+// running, or how long its build took, e.g. "running for 3m12s"
+func describeJobDuration(compose weldr.ComposeEntryV0, now time.Time) string {
+	created := jobTime(compose.JobCreated)
+	started := jobTime(compose.JobStarted)
+	finished := jobTime(compose.JobFinished)
+
+	switch {
+	case !finished.IsZero() && !started.IsZero():
+		return "took " + finished.Sub(started).Round(time.Second).String()
+	case !started.IsZero():
+		return "running for " + now.Sub(started).Round(time.Second).String()
+	case !created.IsZero():
+		return "waiting for " + now.Sub(created).Round(time.Second).String()
+	}
+	return "-"
+}
+
+// describeUploads describes the uploads of the compose and their status,
+// e.g. "aws:FINISHED,azure:RUNNING"
+func describeUploads(uploads []weldr.UploadResponse) string {
+	if len(uploads) == 0 {
+		return "-"
+	}
+
+	var described []string
+	for _, upload := range uploads {
+		described = append(described, upload.ProviderName+":"+upload.Status.ToString())
+	}
+	return strings.Join(described, ",")
+}
+
+// printComposes prints the composes as a table
+func printComposes(w io.Writer, composes []weldr.ComposeEntryV0) {
+	now := time.Now()
+
+	fmt.Fprintln(w, "ID\tSTATUS\tBLUEPRINT\tVERSION\tTYPE\tCREATED\tSTARTED\tFINISHED\tTIME\tUPLOADS")
+	for _, c := range composes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", c.ID, c.QueueStatus.ToString(), c.Blueprint, c.Version, c.ComposeType,
+			formatJobTime(c.JobCreated), formatJobTime(c.JobStarted), formatJobTime(c.JobFinished), describeJobDuration(c, now), describeUploads(c.Uploads))
+	}
+}
+
+func composeListCommand(args []string) error {
+	cmd := newAPICommand("compose list", "[STATE...]").withOutput()
+	positional, err := cmd.parse(args, 0, -1)
+	if err != nil {
+		return err
+	}
+	states, err := parseStates(positional)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	wanted := make(map[common.ImageBuildState]bool)
+	for _, state := range states {
+		wanted[state] = true
+	}
+
+	composes := []weldr.ComposeEntryV0{}
+
+	if wanted[common.IBWaiting] || wanted[common.IBRunning] {
+		queue, response, err := client.GetComposesQueueV0(ctx, c)
+		if err := checkResponse("cannot retrieve the compose queue", response, err); err != nil {
+			return err
+		}
+		if wanted[common.IBWaiting] {
+			composes = append(composes, queue.New...)
+		}
+		if wanted[common.IBRunning] {
+			composes = append(composes, queue.Run...)
+		}
+	}
+
+	if wanted[common.IBFinished] {
+		finished, response, err := client.GetFinishedComposesV0(ctx, c)
+		if err := checkResponse("cannot retrieve the finished composes", response, err); err != nil {
+			return err
+		}
+		composes = append(composes, finished...)
+	}
+
+	if wanted[common.IBFailed] {
+		failed, response, err := client.GetFailedComposesV0(ctx, c)
+		if err := checkResponse("cannot retrieve the failed composes", response, err); err != nil {
+			return err
+		}
+		composes = append(composes, failed...)
+	}
+
+	sort.SliceStable(composes, func(i, j int) bool {
+		return composes[i].JobCreated < composes[j].JobCreated
+	})
+
+	return cmd.print(composes, func(w io.Writer) {
+		printComposes(w, composes)
+	})
+}
+
+func composeQueueCommand(args []string) error {
+	cmd := newAPICommand("compose queue", "").withOutput()
+	if _, err := cmd.parse(args, 0, 0); err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	queue, response, err := client.GetComposesQueueV0(ctx, c)
+	if err := checkResponse("cannot retrieve the compose queue", response, err); err != nil {
+		return err
+	}
+
+	return cmd.print(queue, func(w io.Writer) {
+		printComposes(w, append(append([]weldr.ComposeEntryV0{}, queue.Run...), queue.New...))
+	})
+}
+
+func composeCancelCommand(args []string) error {
+	cmd := newAPICommand("compose cancel", "UUID...")
+	ids, err := cmd.parse(args, 1, -1)
+	if err != nil {
+		return err
+	}
+	if err := parseUUIDs(ids); err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// the API cancels only one compose at a time, so all of them are tried
+	// and the failures are reported together
+	var failed []string
+	for _, id := range ids {
+		_, response, err := client.CancelComposeV0(ctx, c, id)
+		if err := checkResponse("cannot cancel the compose "+id, response, err); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "\n"))
+	}
+	return nil
+}
+
+func composeDeleteCommand(args []string) error {
+	cmd := newAPICommand("compose delete", "UUID...")
+	ids, err := cmd.parse(args, 1, -1)
+	if err != nil {
+		return err
+	}
+	if err := parseUUIDs(ids); err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	deleted, response, err := client.DeleteComposeV0(ctx, c, strings.Join(ids, ","))
+	if err := checkResponse("cannot delete the composes", response, err); err != nil {
+		return err
+	}
+
+	return checkResponseErrors(deleted.Errors)
+}
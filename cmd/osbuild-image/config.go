@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/ondrejbudai/osbuild-image/internal/weldr-image"
+)
+
+// The environment variables that can be used to configure the endpoint, they
+// take precedence over the config file but not over the command line flags
+// (see endpointFlags.endpoint)
+const (
+	envAddress = "OSBUILD_IMAGE_ADDRESS"
+	envCA      = "OSBUILD_IMAGE_TLS_CA"
+	envCert    = "OSBUILD_IMAGE_TLS_CERT"
+	envKey     = "OSBUILD_IMAGE_TLS_KEY"
+	envConfig  = "OSBUILD_IMAGE_CONFIG"
+)
+
+// config is the content of the osbuild-image's config file
+//
+// Example:
+//
+//	address = "https://composer.example.com:8443"
+//	tls-ca = "/etc/pki/composer/ca.pem"
+//	tls-cert = "/etc/pki/composer/client.pem"
+//	tls-key = "/etc/pki/composer/client.key"
+type config struct {
+	Address string `toml:"address"`
+	CA      string `toml:"tls-ca"`
+	Cert    string `toml:"tls-cert"`
+	Key     string `toml:"tls-key"`
+}
+
+// hasTLS returns true if any of the tls options is set
+func (c config) hasTLS() bool {
+	return c.CA != "" || c.Cert != "" || c.Key != ""
+}
+
+// configPaths returns the paths where the config file is looked for, in the
+// order of preference
+func configPaths() []string {
+	if path := os.Getenv(envConfig); path != "" {
+		return []string{path}
+	}
+
+	var paths []string
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		paths = append(paths, filepath.Join(dir, "osbuild-image", "config.toml"))
+	} else if home := os.Getenv("HOME"); home != "" {
+		paths = append(paths, filepath.Join(home, ".config", "osbuild-image", "config.toml"))
+	}
+
+	return append(paths, "/etc/osbuild-image/config.toml")
+}
+
+// loadConfig loads the first config file found, a missing config file is not
+// an error
+func loadConfig() (*config, error) {
+	var c config
+
+	for _, path := range configPaths() {
+		_, err := toml.DecodeFile(path, &c)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot load the config file %s: %v", path, err)
+		}
+		break
+	}
+
+	return &c, nil
+}
+
+// endpointFlags are the command line flags selecting the osbuild-composer
+// endpoint, they're shared by all the subcommands
+type endpointFlags struct {
+	address string
+	ca      string
+	cert    string
+	key     string
+
+	retries         int
+	retryMaxBackoff time.Duration
+}
+
+func addEndpointFlags(fs *flag.FlagSet) *endpointFlags {
+	var f endpointFlags
+	fs.StringVar(&f.address, "address", "", "address of osbuild-composer: unix:///path/to/socket, http://host:port or https://host:port (optional, $"+envAddress+" or the config file are used if not given, "+weldr_image.DefaultAddress+" by default)")
+	fs.StringVar(&f.ca, "tls-ca", "", "PEM file with the CA certificates used to verify an https osbuild-composer (optional)")
+	fs.StringVar(&f.cert, "tls-cert", "", "PEM client certificate used to authenticate to an https osbuild-composer (optional)")
+	fs.StringVar(&f.key, "tls-key", "", "key of the client certificate (optional)")
+	fs.IntVar(&f.retries, "retries", weldr_image.DefaultRetryPolicy.MaxRetries, "how many times a request failing with a transient error is retried, 0 disables retrying")
+	fs.DurationVar(&f.retryMaxBackoff, "retry-max-backoff", weldr_image.DefaultRetryPolicy.MaxBackoff, "maximal delay between two retries of a request")
+	return &f
+}
+
+// endpoint returns the endpoint selected by the flags, the environment or the
+// config file. The options are never mixed: the whole endpoint (the address
+// and the tls options) comes from the first of them setting an address. The
+// tls options of the flags or the environment without an address are an
+// error, they would be silently replaced by the ones of the next source.
+func (f *endpointFlags) endpoint() (*weldr_image.Endpoint, error) {
+	selected := config{Address: f.address, CA: f.ca, Cert: f.cert, Key: f.key}
+	if selected.Address == "" && selected.hasTLS() {
+		return nil, errors.New("--tls-ca, --tls-cert and --tls-key cannot be used without --address")
+	}
+
+	if selected.Address == "" {
+		environment := config{
+			Address: os.Getenv(envAddress),
+			CA:      os.Getenv(envCA),
+			Cert:    os.Getenv(envCert),
+			Key:     os.Getenv(envKey),
+		}
+		if environment.Address == "" && environment.hasTLS() {
+			return nil, errors.New("$" + envCA + ", $" + envCert + " and $" + envKey + " cannot be used without $" + envAddress)
+		}
+
+		if environment.Address != "" {
+			selected = environment
+		} else {
+			c, err := loadConfig()
+			if err != nil {
+				return nil, err
+			}
+			if c.Address != "" {
+				selected = *c
+			}
+		}
+	}
+
+	return &weldr_image.Endpoint{
+		Address:  selected.Address,
+		CAFile:   selected.CA,
+		CertFile: selected.Cert,
+		KeyFile:  selected.Key,
+		Retry: weldr_image.RetryPolicy{
+			MaxRetries:     f.retries,
+			InitialBackoff: weldr_image.DefaultRetryPolicy.InitialBackoff,
+			MaxBackoff:     f.retryMaxBackoff,
+		},
+	}, nil
+}
+
+// client returns a client talking to the selected endpoint
+func (f *endpointFlags) client() (*http.Client, error) {
+	e, err := f.endpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	return e.NewClient()
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConfigFile = `
+address = "https://config.example.com:8443"
+tls-ca = "/config/ca.pem"
+tls-cert = "/config/client.pem"
+tls-key = "/config/client.key"
+`
+
+// setTestEnv sets the environment variables (an empty value unsets the
+// variable), the returned function restores the previous values
+func setTestEnv(t *testing.T, env map[string]string) func() {
+	previous := make(map[string]*string)
+	restore := func() {
+		for name, value := range previous {
+			if value == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *value)
+			}
+		}
+	}
+
+	for name, value := range env {
+		if old, ok := os.LookupEnv(name); ok {
+			previous[name] = &old
+		} else {
+			previous[name] = nil
+		}
+
+		var err error
+		if value == "" {
+			err = os.Unsetenv(name)
+		} else {
+			err = os.Setenv(name, value)
+		}
+		if err != nil {
+			restore()
+			t.Fatalf("cannot set $%s: %v", name, err)
+		}
+	}
+
+	return restore
+}
+
+// writeTestConfig writes the config file to a temporary directory, the
+// directory is removed by the returned function
+func writeTestConfig(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "osbuild-image-test")
+	if err != nil {
+		t.Fatalf("cannot create a temporary directory: %v", err)
+	}
+
+	path := filepath.Join(dir, "config.toml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("cannot write the config file: %v", err)
+	}
+
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestConfigPaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		expected []string
+	}{
+		{
+			"explicit",
+			map[string]string{envConfig: "/my/config.toml", "XDG_CONFIG_HOME": "/xdg", "HOME": "/home/user"},
+			[]string{"/my/config.toml"},
+		},
+		{
+			"xdg",
+			map[string]string{envConfig: "", "XDG_CONFIG_HOME": "/xdg", "HOME": "/home/user"},
+			[]string{"/xdg/osbuild-image/config.toml", "/etc/osbuild-image/config.toml"},
+		},
+		{
+			"home",
+			map[string]string{envConfig: "", "XDG_CONFIG_HOME": "", "HOME": "/home/user"},
+			[]string{"/home/user/.config/osbuild-image/config.toml", "/etc/osbuild-image/config.toml"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer setTestEnv(t, test.env)()
+
+			if paths := configPaths(); !reflect.DeepEqual(paths, test.expected) {
+				t.Errorf("unexpected paths %v, expected %v", paths, test.expected)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		path, remove := writeTestConfig(t, testConfigFile)
+		defer remove()
+		defer setTestEnv(t, map[string]string{envConfig: path})()
+
+		c, err := loadConfig()
+		if err != nil {
+			t.Fatalf("cannot load the config: %v", err)
+		}
+
+		expected := &config{
+			Address: "https://config.example.com:8443",
+			CA:      "/config/ca.pem",
+			Cert:    "/config/client.pem",
+			Key:     "/config/client.key",
+		}
+		if !reflect.DeepEqual(c, expected) {
+			t.Errorf("unexpected config %+v, expected %+v", c, expected)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		path, remove := writeTestConfig(t, "")
+		defer remove()
+		defer setTestEnv(t, map[string]string{envConfig: path + ".missing"})()
+
+		c, err := loadConfig()
+		if err != nil || *c != (config{}) {
+			t.Errorf("expected an empty config, got %+v %v", c, err)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		path, remove := writeTestConfig(t, "address = [\n")
+		defer remove()
+		defer setTestEnv(t, map[string]string{envConfig: path})()
+
+		_, err := loadConfig()
+		if err == nil || !strings.Contains(err.Error(), path) {
+			t.Errorf("expected an error about %s, got %v", path, err)
+		}
+	})
+}
+
+func TestEndpointPrecedence(t *testing.T) {
+	path, remove := writeTestConfig(t, testConfigFile)
+	defer remove()
+
+	environment := map[string]string{
+		envAddress: "https://env.example.com:8443",
+		envCA:      "/env/ca.pem",
+		envCert:    "/env/client.pem",
+		envKey:     "/env/client.key",
+	}
+	noEnvironment := map[string]string{envAddress: "", envCA: "", envCert: "", envKey: ""}
+
+	tests := []struct {
+		name   string
+		args   []string
+		env    map[string]string
+		config string
+		// expected is the address, ca, cert and key of the endpoint, nil
+		// if it's an error
+		expected []string
+	}{
+		{
+			"flags",
+			[]string{"--address", "https://flags.example.com", "--tls-ca", "/flags/ca.pem"},
+			environment,
+			path,
+			[]string{"https://flags.example.com", "/flags/ca.pem", "", ""},
+		},
+		{
+			"environment",
+			nil,
+			environment,
+			path,
+			[]string{"https://env.example.com:8443", "/env/ca.pem", "/env/client.pem", "/env/client.key"},
+		},
+		{
+			"config",
+			nil,
+			noEnvironment,
+			path,
+			[]string{"https://config.example.com:8443", "/config/ca.pem", "/config/client.pem", "/config/client.key"},
+		},
+		{
+			"default",
+			nil,
+			noEnvironment,
+			path + ".missing",
+			[]string{"", "", "", ""},
+		},
+		{
+			"flags without an address",
+			[]string{"--tls-ca", "/flags/ca.pem"},
+			environment,
+			path,
+			nil,
+		},
+		{
+			"environment without an address",
+			nil,
+			map[string]string{envAddress: "", envCA: "", envCert: "/env/client.pem", envKey: "/env/client.key"},
+			path,
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer setTestEnv(t, test.env)()
+			defer setTestEnv(t, map[string]string{envConfig: test.config})()
+
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			flags := addEndpointFlags(fs)
+			if err := fs.Parse(test.args); err != nil {
+				t.Fatalf("cannot parse the flags: %v", err)
+			}
+
+			e, err := flags.endpoint()
+			if test.expected == nil {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", e)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("cannot get the endpoint: %v", err)
+			}
+
+			selected := []string{e.Address, e.CAFile, e.CertFile, e.KeyFile}
+			if !reflect.DeepEqual(selected, test.expected) {
+				t.Errorf("unexpected endpoint %v, expected %v", selected, test.expected)
+			}
+		})
+	}
+}
@@ -1,41 +1,318 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
 
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/weldr"
 	"github.com/ondrejbudai/osbuild-image/internal/weldr-image"
 )
 
+// stringList is a flag that can be given multiple times, each value is
+// appended to the list. If separator is not empty, each value is also split
+// by it.
+type stringList struct {
+	values    []string
+	separator string
+}
+
+func (l *stringList) String() string {
+	return strings.Join(l.values, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	if l.separator == "" {
+		l.values = append(l.values, value)
+		return nil
+	}
+
+	for _, v := range strings.Split(value, l.separator) {
+		if v != "" {
+			l.values = append(l.values, v)
+		}
+	}
+	return nil
+}
+
+// byteSize is a flag holding a size in bytes, it accepts a number of bytes
+// optionally followed by a decimal (kB, MB, GB, TB) or binary (KiB, MiB, GiB,
+// TiB) unit, e.g. 4GiB
+type byteSize uint64
+
+var byteSizeUnits = map[string]uint64{
+	"":    1,
+	"B":   1,
+	"kB":  1000,
+	"KB":  1000,
+	"MB":  1000 * 1000,
+	"GB":  1000 * 1000 * 1000,
+	"TB":  1000 * 1000 * 1000 * 1000,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+	"TiB": 1 << 40,
+}
+
+func (s *byteSize) String() string {
+	return strconv.FormatUint(uint64(*s), 10)
+}
+
+func (s *byteSize) Set(value string) error {
+	digits := strings.TrimRightFunc(value, unicode.IsLetter)
+	unit := value[len(digits):]
+
+	multiplier, ok := byteSizeUnits[unit]
+	if !ok {
+		return fmt.Errorf("unknown size unit: %s, valid units: B, kB, MB, GB, TB, KiB, MiB, GiB, TiB", unit)
+	}
+
+	n, err := strconv.ParseUint(strings.TrimSpace(digits), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid size: %s", value)
+	}
+	if n > math.MaxUint64/multiplier {
+		return fmt.Errorf("size is too big: %s", value)
+	}
+
+	*s = byteSize(n * multiplier)
+	return nil
+}
+
 type flags struct {
-	imageType     string
-	imagePath     string
-	manifestPath  string
-	logPath       string
+	imageTypes    stringList
+	imagePaths    stringList
+	manifestPaths stringList
+	logPaths      stringList
+	checksums     stringList
+	uploadConfigs stringList
+	size          byteSize
+	ostreeRef     string
+	ostreeParent  string
+	ostreeURL     string
+	distro        string
+	arch          string
+	api           string
 	blueprintPath string
+	blueprintVars *blueprintVarFlags
+	overrides     *blueprintOverrideFlags
+	sourcePaths   stringList
 	keepArtifacts bool
+	detach        bool
+	detachFormat  string
+	dryRun        bool
+	timeout       time.Duration
+	progress      string
 }
 
 func validateFlags(flags *flags) error {
-	// do not validate imageType, weldr_image can return all the possible values
-	if flags.imagePath == "" {
+	// do not validate imageTypes, weldr_image can return all the possible values
+	imageCount := len(flags.imageTypes.values)
+	if imageCount == 0 {
+		imageCount = 1
+	}
+
+	if n := len(flags.uploadConfigs.values); n != 0 && n != imageCount {
+		return fmt.Errorf("%d image types given but %d upload configs, there must be one upload config per image type or none", imageCount, n)
+	}
+
+	if flags.timeout < 0 {
+		return errors.New("timeout cannot be negative")
+	}
+
+	switch flags.api {
+	case "weldr":
+	case "cloud":
+		if len(flags.uploadConfigs.values) != 0 {
+			return errors.New("--upload-config cannot be used with the cloud API")
+		}
+		if flags.dryRun {
+			return errors.New("--dry-run cannot be used with the cloud API, it cannot resolve packages")
+		}
+	default:
+		return fmt.Errorf("unknown API: %s, valid APIs: weldr, cloud", flags.api)
+	}
+
+	if flags.dryRun {
+		if flags.detach {
+			return errors.New("--dry-run and --detach cannot be combined")
+		}
+		// the outputs are ignored, so the flags of a real build can be
+		// reused as they are
+		return nil
+	}
+
+	if flags.detach {
+		if len(flags.imagePaths.values) != 0 || len(flags.manifestPaths.values) != 0 || len(flags.logPaths.values) != 0 || len(flags.checksums.values) != 0 {
+			return errors.New("no output can be given with --detach, use the fetch subcommand to collect the artifacts")
+		}
+		if flags.detachFormat != "text" && flags.detachFormat != "json" {
+			return fmt.Errorf("unknown detach format: %s, valid formats: text, json", flags.detachFormat)
+		}
+		return nil
+	}
+
+	// an uploaded image doesn't need to be downloaded
+	uploading := len(flags.uploadConfigs.values) != 0
+
+	if len(flags.imagePaths.values) == 0 && !uploading {
 		return errors.New("image path cannot be empty")
 	}
+	for _, path := range flags.imagePaths.values {
+		if path == "" {
+			return errors.New("image path cannot be empty")
+		}
+	}
+	if n := len(flags.imagePaths.values); n != imageCount && (n != 0 || !uploading) {
+		return fmt.Errorf("%d image types given but %d image paths, there must be one path per image type", imageCount, n)
+	}
+	if n := len(flags.manifestPaths.values); n != 0 && n != imageCount {
+		return fmt.Errorf("%d image types given but %d manifest paths, there must be one path per image type or none", imageCount, n)
+	}
+	if n := len(flags.logPaths.values); n != 0 && n != imageCount {
+		return fmt.Errorf("%d image types given but %d log paths, there must be one path per image type or none", imageCount, n)
+	}
+	if n := len(flags.checksums.values); n != 0 && n != imageCount {
+		return fmt.Errorf("%d image types given but %d checksums, there must be one checksum per image type or none", imageCount, n)
+	}
 	return nil
 }
 
+// interruptibleContext returns a context that is cancelled when osbuild-image
+// receives SIGINT or SIGTERM. Only the first signal is caught so the user can
+// still kill osbuild-image by sending another one if the cleanup gets stuck.
+func interruptibleContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Printf("received %v, cancelling the build\n", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+
+	return ctx, cancel
+}
+
+// buildContext returns a context for a build, it's cancelled when
+// osbuild-image is interrupted or when the timeout passes (0 means no
+// timeout)
+func buildContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx := context.Background()
+	cancelTimeout := func() {}
+	if timeout > 0 {
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+	}
+
+	ctx, cancel := interruptibleContext(ctx)
+	return ctx, func() {
+		cancel()
+		cancelTimeout()
+	}
+}
+
+// commands are the subcommands of osbuild-image, if no subcommand is given,
+// an image is built
+var commands = map[string]func(args []string) error{
+	"batch":     batchCommand,
+	"blueprint": blueprintCommand,
+	"compose":   composeCommand,
+	"fetch":     fetchCommand,
+	"packages":  packagesCommand,
+	"sources":   sourcesCommand,
+	"types":     typesCommand,
+	"wait":      waitCommand,
+}
+
+// valueAt returns the i-th value of the list, or an empty string if the list
+// is empty
+func valueAt(list stringList, i int) string {
+	if len(list.values) == 0 {
+		return ""
+	}
+	return list.values[i]
+}
+
+// readUploadConfig reads a json or toml upload config file
+func readUploadConfig(path string) (*weldr.UploadRequest, error) {
+	config, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the upload config file: %v", err)
+	}
+	return weldr_image.LoadUploadConfig(config)
+}
+
+// printSummary prints the outcome of each image, paths are empty if the
+// images were only uploaded
+func printSummary(results []weldr_image.ImageResult, paths []string) {
+	fmt.Fprintln(os.Stderr, "summary:")
+	for i, result := range results {
+		switch {
+		case result.Err != nil:
+			fmt.Fprintf(os.Stderr, "  %s: failed\n", result.ImageType)
+		case len(paths) == 0:
+			fmt.Fprintf(os.Stderr, "  %s: succeeded\n", result.ImageType)
+		default:
+			fmt.Fprintf(os.Stderr, "  %s: succeeded, saved to %s\n", result.ImageType, paths[i])
+		}
+	}
+}
+
 func main() {
-	var flags flags
-	flag.StringVar(&flags.blueprintPath, "blueprint", "", "json or toml blueprint to be used (optional, if not specified, an empty blueprint will be used)")
-	flag.StringVar(&flags.imageType, "type", "", "image type to be built")
-	flag.StringVar(&flags.imagePath, "output", "", "path where the image will be saved")
-	flag.StringVar(&flags.manifestPath, "output-manifest", "", "path where the manifest will be saved (optional, it's not saved if no path is given)")
-	flag.StringVar(&flags.logPath, "output-log", "", "path where the log will be saved (optional, it's not saved if no path is given)")
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			err := command(os.Args[2:])
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	flags := flags{
+		imageTypes: stringList{separator: ","},
+	}
+	flag.StringVar(&flags.blueprintPath, "blueprint", "", "json or toml blueprint to be used, its variables and includes are resolved first (optional, if not specified, an empty blueprint will be used)")
+	flags.blueprintVars = addBlueprintVarFlags(flag.CommandLine)
+	flags.overrides = addBlueprintOverrideFlags(flag.CommandLine)
+	flag.Var(&flags.sourcePaths, "source", "json or toml source (repository) added to osbuild-composer for the build and removed afterwards unless --keep-artifacts or --detach is given (optional, can be repeated)")
+	flag.Var(&flags.imageTypes, "type", "image type to be built (can be repeated or comma-separated to build multiple images from the same blueprint)")
+	flag.Var(&flags.imagePaths, "output", "path where the image will be saved (repeat it for each image type, in the same order)")
+	flag.Var(&flags.manifestPaths, "output-manifest", "path where the manifest will be saved (optional, it's not saved if no path is given, otherwise repeat it for each image type)")
+	flag.Var(&flags.logPaths, "output-log", "path where the log will be saved (optional, it's not saved if no path is given, otherwise repeat it for each image type)")
+	flag.Var(&flags.checksums, "checksum", "expected checksum of the image in the sha256:HEX form (optional, otherwise repeat it for each image type)")
+	flag.Var(&flags.uploadConfigs, "upload-config", "json or toml config of the upload of the image to a cloud provider (optional, the image path is optional then, otherwise repeat it for each image type)")
+	flag.StringVar(&flags.api, "api", "weldr", "API of osbuild-composer the images are built with: weldr or cloud (the Cloud API v2, it needs --distro, --arch and at least one --source)")
+	flag.StringVar(&flags.distro, "distro", "", "distribution of the images, e.g. fedora-33 (optional, the default of the osbuild-composer's host is used otherwise)")
+	flag.StringVar(&flags.arch, "arch", "", "architecture of the images, e.g. aarch64 (optional, the default of the osbuild-composer's host is used otherwise)")
+	flag.Var(&flags.size, "size", "size of the images, e.g. 4GiB (optional, the default size of the image type is used otherwise)")
+	flag.StringVar(&flags.ostreeRef, "ostree-ref", "", "ostree ref of the commit of edge and iot images (optional)")
+	flag.StringVar(&flags.ostreeParent, "ostree-parent", "", "ref or checksum of the parent of the ostree commit (optional, edge and iot commits only)")
+	flag.StringVar(&flags.ostreeURL, "ostree-url", "", "url of the ostree repository the parent commit is pulled from (required by edge and iot installers and disk images)")
 	flag.BoolVar(&flags.keepArtifacts, "keep-artifacts", false, "whether osbuild-image should keep all the artifacts (blueprint and compose), false by default, that means osbuild-image cleans up after itself")
+	flag.BoolVar(&flags.detach, "detach", false, "only start the composes and print their uuids, the artifacts are collected later with the fetch subcommand")
+	flag.StringVar(&flags.detachFormat, "detach-format", "text", "how to print the started composes when detaching: text or json")
+	flag.BoolVar(&flags.dryRun, "dry-run", false, "only resolve the packages of the blueprint and print them, no compose is started")
+	flag.StringVar(&flags.progress, "progress", "human", "how to report the progress of the build: human (to stderr), json (newline-delimited events to stderr) or none")
+	flag.DurationVar(&flags.timeout, "timeout", 0, "maximal duration of the whole build, e.g. 1h30m (optional, no timeout by default)")
+	endpointFlags := addEndpointFlags(flag.CommandLine)
 	flag.Parse()
 
 	if err := validateFlags(&flags); err != nil {
@@ -44,35 +321,132 @@ func main() {
 		os.Exit(1)
 	}
 
-	imageFile, err := os.Create(flags.imagePath)
+	observer, err := newProgressObserver(flags.progress, os.Stderr)
 	if err != nil {
-		log.Fatal("cannot open the output file: ", err)
+		fmt.Fprintf(os.Stderr, "validation of arguments failed: %v\n", err)
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	c, err := endpointFlags.client()
+	if err != nil {
+		log.Fatal("cannot configure the osbuild-composer endpoint: ", err)
+	}
+
+	imageTypes := flags.imageTypes.values
+	if len(imageTypes) == 0 {
+		imageTypes = []string{""}
+	}
+
+	var ostree *weldr.OSTreeRequestV0
+	if flags.ostreeRef != "" || flags.ostreeParent != "" || flags.ostreeURL != "" {
+		ostree = &weldr.OSTreeRequestV0{
+			Ref:    flags.ostreeRef,
+			Parent: flags.ostreeParent,
+			URL:    flags.ostreeURL,
+		}
+	}
+
+	var images []weldr_image.Image
+	for i, imageType := range imageTypes {
+		var upload *weldr.UploadRequest
+		if path := valueAt(flags.uploadConfigs, i); path != "" {
+			upload, err = readUploadConfig(path)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		images = append(images, weldr_image.Image{
+			Type:         imageType,
+			Path:         valueAt(flags.imagePaths, i),
+			Checksum:     valueAt(flags.checksums, i),
+			ManifestPath: valueAt(flags.manifestPaths, i),
+			LogPath:      valueAt(flags.logPaths, i),
+			Upload:       upload,
+			Size:         uint64(flags.size),
+			OSTree:       ostree,
+		})
 	}
 
 	var blueprint []byte
 	if flags.blueprintPath != "" {
-		blueprint, err = ioutil.ReadFile(flags.blueprintPath)
+		blueprint, err = flags.blueprintVars.load(flags.blueprintPath)
 		if err != nil {
-			log.Fatal("cannot read the blueprint file: ", err)
+			log.Fatal(err)
 		}
 	}
 
+	overlay, err := flags.overrides.overlay()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var sources [][]byte
+	for _, path := range flags.sourcePaths.values {
+		source, err := readSourceFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sources = append(sources, source)
+	}
+
 	req := &weldr_image.Request{
-		Blueprint:     blueprint,
-		ImageType:     flags.imageType,
-		ImageWriter:   imageFile,
-		ManifestPath:  flags.manifestPath,
-		LogPath:       flags.logPath,
-		KeepArtifacts: flags.keepArtifacts,
+		Blueprint:        blueprint,
+		BlueprintOverlay: overlay,
+		Sources:          sources,
+		Images:           images,
+		Distro:           flags.distro,
+		Arch:             flags.arch,
+		KeepArtifacts:    flags.keepArtifacts,
+		Detach:           flags.detach,
+		Client:           c,
+		Observer:         observer,
+	}
+	if flags.api == "cloud" {
+		req.Backend = weldr_image.NewCloudBackend(c)
 	}
 
-	err = req.Validate()
+	ctx, cancel := buildContext(flags.timeout)
+	defer cancel()
+
+	if flags.dryRun {
+		packages, err := req.DepsolveContext(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = printPackages(packages)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	err = req.ValidateContext(ctx)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "validation of the image request failed: %v\n", err)
 		os.Exit(1)
 	}
 
-	err = req.Process()
+	if flags.detach {
+		results, err := req.ProcessContext(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = printDetached(results, flags.detachFormat)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	results, err := req.ProcessContext(ctx)
+
+	if len(images) > 1 && results != nil {
+		printSummary(results, flags.imagePaths.values)
+	}
 
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateFlagsTimeout(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags flags
+	}{
+		{"build", flags{api: "weldr", imagePaths: stringList{values: []string{"image.qcow2"}}}},
+		{"dry run", flags{api: "weldr", dryRun: true}},
+		{"detach", flags{api: "weldr", detach: true, detachFormat: "text"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := validateFlags(&test.flags); err != nil {
+				t.Fatalf("the flags are invalid: %v", err)
+			}
+
+			test.flags.timeout = -time.Second
+			if err := validateFlags(&test.flags); err == nil || err.Error() != "timeout cannot be negative" {
+				t.Errorf("expected a negative timeout to be rejected, got %v", err)
+			}
+		})
+	}
+}
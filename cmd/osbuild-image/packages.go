@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/client"
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/weldr"
+)
+
+// packagesVerbs are the verbs of the packages subcommand querying the
+// packages and modules available in the osbuild-composer's repositories
+var packagesVerbs = map[string]verb{
+	"search":   {"search [GLOB...]", "list the packages (or modules) matching the globs", packagesSearchCommand},
+	"info":     {"info NAME|GLOB...", "show the details and the builds of the packages (or modules)", packagesInfoCommand},
+	"depsolve": {"depsolve NAME...", "show all the packages needed to install the packages", packagesDepsolveCommand},
+}
+
+func packagesCommand(args []string) error {
+	return runVerb("packages", packagesVerbs, args)
+}
+
+// checkGlobs returns an error if one of the globs is malformed
+func checkGlobs(globs []string) error {
+	for _, glob := range globs {
+		if _, err := path.Match(glob, ""); err != nil {
+			return fmt.Errorf("invalid glob %q: %v", glob, err)
+		}
+	}
+	return nil
+}
+
+func isGlob(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// matchesAny returns true if the name matches one of the globs, every name
+// matches an empty list of globs
+func matchesAny(name string, globs []string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// listNames returns the names of the packages, or modules, matching the globs
+func listNames(ctx context.Context, c *http.Client, modules bool, globs []string) ([]string, error) {
+	var names []string
+
+	// the modules can be filtered by the server, the projects cannot
+	if modules {
+		list, response, err := client.ListAllModulesV0(ctx, c, strings.Join(globs, ","))
+		if err := checkResponse("cannot list the modules", response, err); err != nil {
+			return nil, err
+		}
+		for _, module := range list {
+			names = append(names, module.Name)
+		}
+		return names, nil
+	}
+
+	list, response, err := client.ListAllProjectsV0(ctx, c)
+	if err := checkResponse("cannot list the packages", response, err); err != nil {
+		return nil, err
+	}
+	for _, project := range list {
+		if matchesAny(project.Name, globs) {
+			names = append(names, project.Name)
+		}
+	}
+	return names, nil
+}
+
+// formatPackage formats a package as epoch:version-release.arch, the epoch is
+// omitted if it's 0
+func formatPackage(epoch uint, version, release, arch string) string {
+	s := version + "-" + release + "." + arch
+	if epoch != 0 {
+		s = fmt.Sprintf("%d:%s", epoch, s)
+	}
+	return s
+}
+
+func packagesSearchCommand(args []string) error {
+	cmd := newAPICommand("packages search", "[GLOB...]").withOutput()
+	modules := cmd.fs.Bool("modules", false, "search the modules instead of the packages")
+	globs, err := cmd.parse(args, 0, -1)
+	if err != nil {
+		return err
+	}
+	if err := checkGlobs(globs); err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if *modules {
+		matching, response, err := client.ListAllModulesV0(ctx, c, strings.Join(globs, ","))
+		if err := checkResponse("cannot list the modules", response, err); err != nil {
+			return err
+		}
+
+		return cmd.print(matching, func(w io.Writer) {
+			fmt.Fprintln(w, "NAME\tTYPE")
+			for _, module := range matching {
+				fmt.Fprintf(w, "%s\t%s\n", module.Name, module.GroupType)
+			}
+		})
+	}
+
+	list, response, err := client.ListAllProjectsV0(ctx, c)
+	if err := checkResponse("cannot list the packages", response, err); err != nil {
+		return err
+	}
+
+	matching := []weldr.ProjectV0{}
+	for _, project := range list {
+		if matchesAny(project.Name, globs) {
+			matching = append(matching, project)
+		}
+	}
+
+	return cmd.print(matching, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tSUMMARY")
+		for _, project := range matching {
+			fmt.Fprintf(w, "%s\t%s\n", project.Name, project.Summary)
+		}
+	})
+}
+
+func packagesInfoCommand(args []string) error {
+	cmd := newAPICommand("packages info", "NAME|GLOB...").withOutput()
+	modules := cmd.fs.Bool("modules", false, "show modules instead of packages")
+	patterns, err := cmd.parse(args, 1, -1)
+	if err != nil {
+		return err
+	}
+	if err := checkGlobs(patterns); err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// the info endpoints don't support globs, expand them using the list
+	var names, globs []string
+	for _, pattern := range patterns {
+		if isGlob(pattern) {
+			globs = append(globs, pattern)
+		} else {
+			names = append(names, pattern)
+		}
+	}
+	if len(globs) > 0 {
+		matching, err := listNames(ctx, c, *modules, globs)
+		if err != nil {
+			return err
+		}
+		if len(matching) == 0 && len(names) == 0 {
+			return fmt.Errorf("nothing matches %s", strings.Join(globs, ", "))
+		}
+		names = append(names, matching...)
+	}
+
+	var projects []weldr.ProjectV0
+	var response *client.APIResponse
+	if *modules {
+		projects, response, err = client.GetModulesInfoV0(ctx, c, strings.Join(names, ","))
+		err = checkResponse("cannot retrieve the modules", response, err)
+	} else {
+		projects, response, err = client.GetProjectsInfoV0(ctx, c, strings.Join(names, ","))
+		err = checkResponse("cannot retrieve the packages", response, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	return cmd.print(projects, func(w io.Writer) {
+		for i, project := range projects {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "name:\t%s\n", project.Name)
+			fmt.Fprintf(w, "summary:\t%s\n", project.Summary)
+			fmt.Fprintf(w, "homepage:\t%s\n", project.Homepage)
+			for _, build := range project.Builds {
+				fmt.Fprintf(w, "build:\t%s\t%s\t%s\n", formatPackage(build.Epoch, build.Source.Version, build.Release, build.Arch), build.Source.License, build.BuildTime)
+			}
+			for _, dep := range project.Dependencies {
+				fmt.Fprintf(w, "dependency:\t%s-%s\n", dep.Name, formatPackage(dep.Epoch, dep.Version, dep.Release, dep.Arch))
+			}
+		}
+	})
+}
+
+func packagesDepsolveCommand(args []string) error {
+	cmd := newAPICommand("packages depsolve", "NAME...").withOutput()
+	names, err := cmd.parse(args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	deps, response, err := client.DepsolveProjectsV0(ctx, c, strings.Join(names, ","))
+	if err := checkResponse("cannot depsolve the packages", response, err); err != nil {
+		return err
+	}
+
+	return cmd.print(deps, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tEPOCH\tVERSION\tRELEASE\tARCH")
+		for _, dep := range deps {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", dep.Name, dep.Epoch, dep.Version, dep.Release, dep.Arch)
+		}
+	})
+}
+
+// printPackages prints the packages resolved by a dry run as a table
+func printPackages(packages []weldr.PackageSpecV0) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tEPOCH\tVERSION\tRELEASE\tARCH")
+	for _, p := range packages {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", p.Name, p.Epoch, p.Version, p.Release, p.Arch)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/ondrejbudai/osbuild-image/internal/weldr-image"
+)
+
+// newProgressObserver returns an observer rendering the events in the given
+// format, nil is returned for the "none" format
+func newProgressObserver(format string, w io.Writer) (weldr_image.Observer, error) {
+	switch format {
+	case "none":
+		return nil, nil
+	case "human":
+		return &humanProgress{w: w}, nil
+	case "json":
+		return &jsonProgress{encoder: json.NewEncoder(w)}, nil
+	}
+
+	return nil, fmt.Errorf("unknown progress format: %s, valid formats: human, json, none", format)
+}
+
+// humanProgress prints one line per event in a human readable form
+type humanProgress struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+func (p *humanProgress) OnEvent(e weldr_image.Event) {
+	var message string
+
+	switch e.Type {
+	case weldr_image.EventBlueprintPushed:
+		message = fmt.Sprintf("blueprint %s pushed", e.BlueprintName)
+	case weldr_image.EventBlueprintDepsolved:
+		message = fmt.Sprintf("blueprint %s resolved to %d packages", e.BlueprintName, e.Packages)
+	case weldr_image.EventSourceAdded:
+		message = fmt.Sprintf("source %s added", e.SourceName)
+	case weldr_image.EventComposeQueued:
+		message = fmt.Sprintf("compose %s queued", e.ComposeID)
+	case weldr_image.EventComposeStateChanged:
+		if e.PreviousState == nil {
+			message = fmt.Sprintf("compose %s is %s", e.ComposeID, e.State.ToString())
+		} else {
+			message = fmt.Sprintf("compose %s: %s -> %s", e.ComposeID, e.PreviousState.ToString(), e.State.ToString())
+		}
+	case weldr_image.EventUploadStateChanged:
+		if e.PreviousState == nil {
+			message = fmt.Sprintf("upload to %s is %s", e.UploadProvider, e.State.ToString())
+		} else {
+			message = fmt.Sprintf("upload to %s: %s -> %s", e.UploadProvider, e.PreviousState.ToString(), e.State.ToString())
+		}
+	case weldr_image.EventDownloadStarted:
+		if e.Bytes > 0 {
+			message = fmt.Sprintf("resuming the download of the image at %s", formatBytes(e.Bytes))
+		} else {
+			message = "downloading the image"
+		}
+	case weldr_image.EventDownloadProgress:
+		message = fmt.Sprintf("downloaded %s", formatBytes(e.Bytes))
+	case weldr_image.EventDownloadFinished:
+		message = fmt.Sprintf("image downloaded (%s)", formatBytes(e.Bytes))
+		if e.Checksum != "" {
+			message = fmt.Sprintf("image downloaded to %s (%s, %s)", e.Path, formatBytes(e.Bytes), e.Checksum)
+		}
+	case weldr_image.EventManifestWritten:
+		message = fmt.Sprintf("manifest written to %s", e.Path)
+	case weldr_image.EventLogWritten:
+		message = fmt.Sprintf("log written to %s", e.Path)
+	case weldr_image.EventCleanupDone:
+		message = "artifacts cleaned up"
+	default:
+		message = e.Type.String()
+	}
+
+	if e.ImageType != "" {
+		message = e.ImageType + ": " + message
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(p.w, "%s %s\n", e.Time.Format("15:04:05"), message)
+}
+
+// jsonProgress prints the events as newline-delimited JSON
+type jsonProgress struct {
+	encoder *json.Encoder
+	mu      sync.Mutex
+}
+
+func (p *jsonProgress) OnEvent(e weldr_image.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	err := p.encoder.Encode(e)
+	if err != nil {
+		log.Printf("cannot write the progress event: %v\n", err)
+	}
+}
+
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/client"
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/weldr"
+	"github.com/ondrejbudai/osbuild-image/internal/weldr-image"
+)
+
+// sourcesVerbs are the verbs of the sources subcommand managing the
+// repositories osbuild-composer installs the packages from
+var sourcesVerbs = map[string]verb{
+	"list":   {"list", "list the names of the sources", sourcesListCommand},
+	"info":   {"info NAME...", "show the sources", sourcesInfoCommand},
+	"add":    {"add FILE", "add a json or toml source, or replace the one with the same name", sourcesAddCommand},
+	"delete": {"delete NAME", "delete a source", sourcesDeleteCommand},
+}
+
+func sourcesCommand(args []string) error {
+	return runVerb("sources", sourcesVerbs, args)
+}
+
+// readSourceFile reads a json or toml source file
+func readSourceFile(path string) ([]byte, error) {
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the source file: %v", err)
+	}
+	if len(strings.TrimSpace(string(source))) == 0 {
+		return nil, errors.New("the source file is empty")
+	}
+	return source, nil
+}
+
+func sourcesListCommand(args []string) error {
+	cmd := newAPICommand("sources list", "").withOutput()
+	if _, err := cmd.parse(args, 0, 0); err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	names, response, err := client.ListSourcesV0(ctx, c)
+	if err := checkResponse("cannot list the sources", response, err); err != nil {
+		return err
+	}
+
+	return cmd.print(names, func(w io.Writer) {
+		for _, name := range names {
+			fmt.Fprintln(w, name)
+		}
+	})
+}
+
+func sourcesInfoCommand(args []string) error {
+	cmd := newAPICommand("sources info", "NAME...").withOutput()
+	names, err := cmd.parse(args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	info, response, err := client.GetSourceInfoV0(ctx, c, strings.Join(names, ","))
+	if err := checkResponse("cannot retrieve the sources", response, err); err != nil {
+		return err
+	}
+
+	var sources []weldr.SourceConfigV0
+	for _, source := range info.Sources {
+		sources = append(sources, source)
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].Name < sources[j].Name
+	})
+
+	err = cmd.print(info, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tTYPE\tURL\tCHECK GPG\tCHECK SSL\tSYSTEM")
+		for _, s := range sources {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%t\t%t\n", s.Name, s.Type, s.URL, s.CheckGPG, s.CheckSSL, s.System)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return checkResponseErrors(info.Errors)
+}
+
+func sourcesAddCommand(args []string) error {
+	cmd := newAPICommand("sources add", "FILE")
+	positional, err := cmd.parse(args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	source, err := readSourceFile(positional[0])
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	_, err = weldr_image.PushSource(ctx, c, source)
+	return err
+}
+
+func sourcesDeleteCommand(args []string) error {
+	cmd := newAPICommand("sources delete", "NAME")
+	positional, err := cmd.parse(args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	return weldr_image.DeleteSource(ctx, c, positional[0])
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/client"
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/weldr"
+)
+
+// typesCommand lists the image types buildable for a distro and arch
+func typesCommand(args []string) error {
+	cmd := newAPICommand("types", "").withOutput()
+	distro := cmd.fs.String("distro", "", "distribution, e.g. fedora-33 (optional, the default of the osbuild-composer's host is used otherwise)")
+	arch := cmd.fs.String("arch", "", "architecture, e.g. aarch64 (optional, the default of the osbuild-composer's host is used otherwise)")
+	all := cmd.fs.Bool("all", false, "list the disabled image types as well")
+	if _, err := cmd.parse(args, 0, 0); err != nil {
+		return err
+	}
+
+	ctx, cancel, c, err := cmd.client()
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	types, response, err := client.GetComposesTypesV0(ctx, c, *distro, *arch)
+	if err := checkResponse("cannot retrieve the compose types", response, err); err != nil {
+		return err
+	}
+
+	listed := []weldr.ComposeTypeV0{}
+	for _, t := range types {
+		if t.Enabled || *all {
+			listed = append(listed, t)
+		}
+	}
+
+	return cmd.print(listed, func(w io.Writer) {
+		if !*all {
+			for _, t := range listed {
+				fmt.Fprintln(w, t.Name)
+			}
+			return
+		}
+
+		fmt.Fprintln(w, "NAME\tENABLED")
+		for _, t := range listed {
+			fmt.Fprintf(w, "%s\t%t\n", t.Name, t.Enabled)
+		}
+	})
+}
@@ -0,0 +1,394 @@
+// Package cloudapitest provides an in-process stand-in for the
+// osbuild-composer's Cloud API (v2) for testing.
+//
+// The server listens on a local TCP port and implements the endpoints used by
+// the cloudapi package. Like in weldrtest, every compose goes through a
+// scripted list of image statuses, one status per status request.
+//
+//	server := cloudapitest.NewServer()
+//	defer server.Close()
+//
+//	server.SetStates("guest-image", cloudapi.ImageStatusPending, cloudapi.ImageStatusFailure)
+//
+//	req := weldr_image.Request{Backend: weldr_image.NewCloudBackend(server.Client()), ...}
+package cloudapitest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/cloudapi"
+)
+
+// Compose is a compose known to the stand-in server
+type Compose struct {
+	ID      uuid.UUID
+	Request cloudapi.ComposeRequest
+	Status  cloudapi.ImageStatusValue
+
+	// states are the remaining statuses of the compose, the first one is
+	// the current one
+	states []cloudapi.ImageStatusValue
+}
+
+// Server is a stand-in Cloud API server
+type Server struct {
+	server *httptest.Server
+
+	mu         sync.Mutex
+	imageTypes map[string]bool
+	states     map[string][]cloudapi.ImageStatusValue
+	composes   map[uuid.UUID]*Compose
+	requests   []string
+
+	// Image, Log and Manifest are the artifacts returned for every
+	// compose. They can be changed before the artifacts are requested.
+	Image    []byte
+	Log      json.RawMessage
+	Manifest json.RawMessage
+}
+
+// DefaultStates are the statuses every compose goes through unless SetStates
+// was called for its image type
+var DefaultStates = []cloudapi.ImageStatusValue{cloudapi.ImageStatusPending, cloudapi.ImageStatusBuilding, cloudapi.ImageStatusSuccess}
+
+// NewServer starts a new stand-in Cloud API server, the caller should call
+// Close when finished
+func NewServer() *Server {
+	s := &Server{
+		imageTypes: map[string]bool{
+			"aws":         true,
+			"edge-commit": true,
+			"guest-image": true,
+		},
+		states:   make(map[string][]cloudapi.ImageStatusValue),
+		composes: make(map[uuid.UUID]*Compose),
+		Image:    []byte("fake image\n"),
+		Log:      json.RawMessage(`{"type":"osbuild","result":{"success":true}}`),
+		Manifest: json.RawMessage(`{"version":"2","pipelines":[],"sources":{}}`),
+	}
+
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+
+	return s
+}
+
+// Close shuts down the server
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Address returns the address of the server in the form accepted by
+// weldr_image.Endpoint
+func (s *Server) Address() string {
+	return s.server.URL
+}
+
+// Client returns a client connected to the server
+func (s *Server) Client() *http.Client {
+	address := s.server.Listener.Addr().String()
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, address)
+			},
+		},
+	}
+}
+
+// SetImageTypes replaces the image types the server builds, "aws",
+// "edge-commit" and "guest-image" by default
+func (s *Server) SetImageTypes(types ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.imageTypes = make(map[string]bool)
+	for _, t := range types {
+		s.imageTypes[t] = true
+	}
+}
+
+// SetStates sets the statuses the composes of the given image type go
+// through. The status advances with every status request of the compose and
+// stays at the last status.
+func (s *Server) SetStates(imageType string, states ...cloudapi.ImageStatusValue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.states[imageType] = states
+}
+
+// Composes returns all the composes known to the server
+func (s *Server) Composes() []Compose {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var composes []Compose
+	for _, compose := range s.composes {
+		composes = append(composes, *compose)
+	}
+	return composes
+}
+
+// Requests returns all the requests received so far in the form "METHOD path"
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	path := strings.TrimPrefix(r.URL.Path, cloudapi.BasePath)
+	if path == r.URL.Path {
+		writeError(w, http.StatusNotFound, 404, "Requested resource doesn't exist")
+		return
+	}
+
+	if path == "/compose" {
+		if r.Method != "POST" {
+			writeError(w, http.StatusMethodNotAllowed, 405, "Requested method isn't supported for resource")
+			return
+		}
+		s.postCompose(w, r)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(path, "/composes/"), "/")
+	if !strings.HasPrefix(path, "/composes/") || len(parts) > 2 {
+		writeError(w, http.StatusNotFound, 404, "Requested resource doesn't exist")
+		return
+	}
+
+	compose := s.compose(w, parts[0])
+	if compose == nil {
+		return
+	}
+
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	type handler struct {
+		method string
+		handle func(w http.ResponseWriter, r *http.Request, compose *Compose)
+	}
+
+	handlers := map[string]handler{
+		"":          {"GET", s.getComposeStatus},
+		"logs":      {"GET", s.getComposeLogs},
+		"manifests": {"GET", s.getComposeManifests},
+		"download":  {"GET", s.downloadCompose},
+	}
+	if action == "" && r.Method == "DELETE" {
+		s.deleteCompose(w, r, compose)
+		return
+	}
+
+	h, ok := handlers[action]
+	if !ok {
+		writeError(w, http.StatusNotFound, 404, "Requested resource doesn't exist")
+		return
+	}
+	if h.method != r.Method {
+		writeError(w, http.StatusMethodNotAllowed, 405, "Requested method isn't supported for resource")
+		return
+	}
+
+	h.handle(w, r, compose)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a Cloud API error, code is the number of the
+// IMAGE-BUILDER-COMPOSER-N error code
+func writeError(w http.ResponseWriter, status, code int, reason string) {
+	writeJSON(w, status, cloudapi.Error{
+		Href:   fmt.Sprintf("%s/errors/%d", cloudapi.BasePath, code),
+		ID:     fmt.Sprint(code),
+		Kind:   "Error",
+		Code:   fmt.Sprintf("IMAGE-BUILDER-COMPOSER-%d", code),
+		Reason: reason,
+	})
+}
+
+// compose returns the compose with the given id, otherwise it writes an
+// error response and returns nil
+func (s *Server) compose(w http.ResponseWriter, id string) *Compose {
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 14, "Invalid format for compose id")
+		return nil
+	}
+
+	compose, ok := s.composes[parsed]
+	if !ok {
+		writeError(w, http.StatusNotFound, 15, "Compose with given id not found")
+		return nil
+	}
+
+	return compose
+}
+
+func (s *Server) postCompose(w http.ResponseWriter, r *http.Request) {
+	var request cloudapi.ComposeRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 17, "Invalid request body: "+err.Error())
+		return
+	}
+
+	if request.Distribution == "" {
+		writeError(w, http.StatusBadRequest, 4, "Unsupported distribution")
+		return
+	}
+	if request.ImageRequest == nil {
+		writeError(w, http.StatusBadRequest, 28, "Image request is missing")
+		return
+	}
+	if request.ImageRequest.Architecture == "" {
+		writeError(w, http.StatusBadRequest, 5, "Unsupported architecture")
+		return
+	}
+	if !s.imageTypes[request.ImageRequest.ImageType] {
+		writeError(w, http.StatusBadRequest, 6, "Unsupported image type")
+		return
+	}
+	if len(request.ImageRequest.Repositories) == 0 {
+		writeError(w, http.StatusBadRequest, 7, "Must include at least one repository")
+		return
+	}
+
+	states, ok := s.states[request.ImageRequest.ImageType]
+	if !ok {
+		states = DefaultStates
+	}
+
+	compose := &Compose{
+		ID:      uuid.New(),
+		Request: request,
+		Status:  states[0],
+		states:  append([]cloudapi.ImageStatusValue(nil), states...),
+	}
+	s.composes[compose.ID] = compose
+
+	writeJSON(w, http.StatusCreated, cloudapi.ComposeID{
+		Href: cloudapi.BasePath + "/compose",
+		ID:   compose.ID.String(),
+		Kind: "ComposeId",
+	})
+}
+
+// composeStatus returns the overall status of a compose with the given image
+// status
+func composeStatus(status cloudapi.ImageStatusValue) cloudapi.ComposeStatusValue {
+	switch status {
+	case cloudapi.ImageStatusSuccess:
+		return cloudapi.ComposeStatusSuccess
+	case cloudapi.ImageStatusFailure:
+		return cloudapi.ComposeStatusFailure
+	}
+	return cloudapi.ComposeStatusPending
+}
+
+func (s *Server) getComposeStatus(w http.ResponseWriter, _ *http.Request, compose *Compose) {
+	status := cloudapi.ComposeStatus{
+		Href:   cloudapi.BasePath + "/composes/" + compose.ID.String(),
+		ID:     compose.ID.String(),
+		Kind:   "ComposeStatus",
+		Status: composeStatus(compose.Status),
+		ImageStatus: cloudapi.ImageStatus{
+			Status: compose.Status,
+		},
+	}
+	if compose.Status == cloudapi.ImageStatusFailure {
+		status.ImageStatus.Error = &cloudapi.ComposeStatusError{ID: 10, Reason: "osbuild build failed"}
+	}
+
+	if len(compose.states) > 1 {
+		compose.states = compose.states[1:]
+		compose.Status = compose.states[0]
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// finished returns true if the compose finished or failed, otherwise it
+// writes an error response
+func finished(w http.ResponseWriter, compose *Compose) bool {
+	if compose.Status != cloudapi.ImageStatusSuccess && compose.Status != cloudapi.ImageStatusFailure {
+		writeError(w, http.StatusBadRequest, 1016, "Compose is still running")
+		return false
+	}
+	return true
+}
+
+func (s *Server) getComposeLogs(w http.ResponseWriter, _ *http.Request, compose *Compose) {
+	if !finished(w, compose) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, cloudapi.ComposeLogs{
+		Href:        cloudapi.BasePath + "/composes/" + compose.ID.String() + "/logs",
+		ID:          compose.ID.String(),
+		Kind:        "ComposeLogs",
+		ImageBuilds: []json.RawMessage{s.Log},
+	})
+}
+
+func (s *Server) getComposeManifests(w http.ResponseWriter, _ *http.Request, compose *Compose) {
+	writeJSON(w, http.StatusOK, cloudapi.ComposeManifests{
+		Href:      cloudapi.BasePath + "/composes/" + compose.ID.String() + "/manifests",
+		ID:        compose.ID.String(),
+		Kind:      "ComposeManifests",
+		Manifests: []json.RawMessage{s.Manifest},
+	})
+}
+
+func (s *Server) downloadCompose(w http.ResponseWriter, r *http.Request, compose *Compose) {
+	if compose.Status != cloudapi.ImageStatusSuccess {
+		writeError(w, http.StatusBadRequest, 1016, "Compose has not finished successfully")
+		return
+	}
+
+	sum := sha256.Sum256(s.Image)
+	w.Header().Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum[:]))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, compose.ID.String(), time.Time{}, bytes.NewReader(s.Image))
+}
+
+func (s *Server) deleteCompose(w http.ResponseWriter, _ *http.Request, compose *Compose) {
+	if !finished(w, compose) {
+		return
+	}
+
+	delete(s.composes, compose.ID)
+	writeJSON(w, http.StatusOK, map[string]string{
+		"href": cloudapi.BasePath + "/composes/delete/" + compose.ID.String(),
+		"id":   compose.ID.String(),
+		"kind": "ComposeDeleteStatus",
+	})
+}
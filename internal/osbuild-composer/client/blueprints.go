@@ -3,13 +3,30 @@
 package client
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/weldr"
 )
 
+// escapeNames escapes comma-separated names for an URL path, every name is
+// escaped on its own, so the commas still separate them
+func escapeNames(names string) string {
+	escaped := strings.Split(names, ",")
+	for i, name := range escaped {
+		escaped[i] = url.PathEscape(name)
+	}
+	return strings.Join(escaped, ",")
+}
+
 // PostTOMLBlueprintV0 sends a TOML blueprint string to the API
 // and returns an APIResponse
-func PostTOMLBlueprintV0(socket *http.Client, blueprint string) (*APIResponse, error) {
-	body, resp, err := PostTOML(socket, "/api/v0/blueprints/new", blueprint)
+func PostTOMLBlueprintV0(ctx context.Context, socket *http.Client, blueprint string) (*APIResponse, error) {
+	body, resp, err := PostTOML(ctx, socket, "/api/v0/blueprints/new", blueprint)
 	if resp != nil || err != nil {
 		return resp, err
 	}
@@ -18,8 +35,8 @@ func PostTOMLBlueprintV0(socket *http.Client, blueprint string) (*APIResponse, e
 
 // PostJSONBlueprintV0 sends a JSON blueprint string to the API
 // and returns an APIResponse
-func PostJSONBlueprintV0(socket *http.Client, blueprint string) (*APIResponse, error) {
-	body, resp, err := PostJSON(socket, "/api/v0/blueprints/new", blueprint)
+func PostJSONBlueprintV0(ctx context.Context, socket *http.Client, blueprint string) (*APIResponse, error) {
+	body, resp, err := PostJSON(ctx, socket, "/api/v0/blueprints/new", blueprint)
 	if resp != nil || err != nil {
 		return resp, err
 	}
@@ -27,8 +44,163 @@ func PostJSONBlueprintV0(socket *http.Client, blueprint string) (*APIResponse, e
 }
 
 // DeleteBlueprintV0 deletes the named blueprint and returns an APIResponse
-func DeleteBlueprintV0(socket *http.Client, bpName string) (*APIResponse, error) {
-	body, resp, err := DeleteRaw(socket, "/api/v0/blueprints/delete/"+bpName)
+func DeleteBlueprintV0(ctx context.Context, socket *http.Client, bpName string) (*APIResponse, error) {
+	body, resp, err := DeleteRaw(ctx, socket, "/api/v0/blueprints/delete/"+url.PathEscape(bpName))
+	if resp != nil || err != nil {
+		return resp, err
+	}
+	return NewAPIResponse(body)
+}
+
+// ListBlueprintsV0 returns the names of all the blueprints
+func ListBlueprintsV0(ctx context.Context, socket *http.Client) ([]string, *APIResponse, error) {
+	body, resp, err := GetJSONAll(ctx, socket, "/api/v0/blueprints/list")
+	if resp != nil || err != nil {
+		return []string{}, resp, err
+	}
+	var list weldr.BlueprintsListResponseV0
+	err = json.Unmarshal(body, &list)
+	if err != nil {
+		return []string{}, nil, err
+	}
+	return list.Blueprints, nil, nil
+}
+
+// GetBlueprintsInfoV0 returns the details of one or more comma-separated
+// blueprints
+func GetBlueprintsInfoV0(ctx context.Context, socket *http.Client, bpNames string) (weldr.BlueprintsInfoResponseV0, *APIResponse, error) {
+	body, resp, err := GetRaw(ctx, socket, "GET", "/api/v0/blueprints/info/"+escapeNames(bpNames))
+	if resp != nil || err != nil {
+		return weldr.BlueprintsInfoResponseV0{}, resp, err
+	}
+	var info weldr.BlueprintsInfoResponseV0
+	err = json.Unmarshal(body, &info)
+	if err != nil {
+		return weldr.BlueprintsInfoResponseV0{}, nil, err
+	}
+	return info, nil, nil
+}
+
+// GetBlueprintInfoTOMLV0 returns the named blueprint as a TOML string
+func GetBlueprintInfoTOMLV0(ctx context.Context, socket *http.Client, bpName string) (string, *APIResponse, error) {
+	body, resp, err := GetRaw(ctx, socket, "GET", "/api/v0/blueprints/info/"+url.PathEscape(bpName)+"?format=toml")
+	if resp != nil || err != nil {
+		return "", resp, err
+	}
+	return string(body), nil, nil
+}
+
+// GetBlueprintsFreezeV0 returns one or more comma-separated blueprints with
+// the versions of their packages and modules set to the depsolved ones
+func GetBlueprintsFreezeV0(ctx context.Context, socket *http.Client, bpNames string) (weldr.BlueprintsFreezeResponseV0, *APIResponse, error) {
+	body, resp, err := GetRaw(ctx, socket, "GET", "/api/v0/blueprints/freeze/"+escapeNames(bpNames))
+	if resp != nil || err != nil {
+		return weldr.BlueprintsFreezeResponseV0{}, resp, err
+	}
+	var freeze weldr.BlueprintsFreezeResponseV0
+	err = json.Unmarshal(body, &freeze)
+	if err != nil {
+		return weldr.BlueprintsFreezeResponseV0{}, nil, err
+	}
+	return freeze, nil, nil
+}
+
+// DepsolveBlueprintsV0 returns one or more comma-separated blueprints
+// together with all the packages needed to build them
+func DepsolveBlueprintsV0(ctx context.Context, socket *http.Client, bpNames string) (weldr.BlueprintsDepsolveResponseV0, *APIResponse, error) {
+	body, resp, err := GetRaw(ctx, socket, "GET", "/api/v0/blueprints/depsolve/"+escapeNames(bpNames))
+	if resp != nil || err != nil {
+		return weldr.BlueprintsDepsolveResponseV0{}, resp, err
+	}
+	var depsolve weldr.BlueprintsDepsolveResponseV0
+	err = json.Unmarshal(body, &depsolve)
+	if err != nil {
+		return weldr.BlueprintsDepsolveResponseV0{}, nil, err
+	}
+	return depsolve, nil, nil
+}
+
+// GetBlueprintDiffV0 returns the differences between two commits of the named
+// blueprint. A commit is either a commit hash, NEWEST or WORKSPACE.
+func GetBlueprintDiffV0(ctx context.Context, socket *http.Client, bpName, fromCommit, toCommit string) ([]weldr.BlueprintDiffV0, *APIResponse, error) {
+	route := "/api/v0/blueprints/diff/" + url.PathEscape(bpName) + "/" + url.PathEscape(fromCommit) + "/" + url.PathEscape(toCommit)
+	body, resp, err := GetRaw(ctx, socket, "GET", route)
+	if resp != nil || err != nil {
+		return []weldr.BlueprintDiffV0{}, resp, err
+	}
+	var diff weldr.BlueprintsDiffResponseV0
+	err = json.Unmarshal(body, &diff)
+	if err != nil {
+		return []weldr.BlueprintDiffV0{}, nil, err
+	}
+	return diff.Diff, nil, nil
+}
+
+// GetBlueprintsChangesV0 returns the commit history of one or more
+// comma-separated blueprints, the limit is the maximal number of changes per
+// blueprint, 0 means the server's default
+func GetBlueprintsChangesV0(ctx context.Context, socket *http.Client, bpNames string, limit uint) (weldr.BlueprintsChangesResponseV0, *APIResponse, error) {
+	route := "/api/v0/blueprints/changes/" + escapeNames(bpNames)
+	if limit > 0 {
+		route = route + "?" + url.Values{"limit": []string{fmt.Sprint(limit)}}.Encode()
+	}
+
+	body, resp, err := GetRaw(ctx, socket, "GET", route)
+	if resp != nil || err != nil {
+		return weldr.BlueprintsChangesResponseV0{}, resp, err
+	}
+	var changes weldr.BlueprintsChangesResponseV0
+	err = json.Unmarshal(body, &changes)
+	if err != nil {
+		return weldr.BlueprintsChangesResponseV0{}, nil, err
+	}
+	return changes, nil, nil
+}
+
+// UndoBlueprintChangeV0 reverts the named blueprint to the given commit by
+// committing its old content as a new change and returns an APIResponse
+func UndoBlueprintChangeV0(ctx context.Context, socket *http.Client, bpName, commit string) (*APIResponse, error) {
+	body, resp, err := PostJSON(ctx, socket, "/api/v0/blueprints/undo/"+url.PathEscape(bpName)+"/"+url.PathEscape(commit), "")
+	if resp != nil || err != nil {
+		return resp, err
+	}
+	return NewAPIResponse(body)
+}
+
+// TagBlueprintV0 tags the latest commit of the named blueprint and returns an
+// APIResponse
+func TagBlueprintV0(ctx context.Context, socket *http.Client, bpName string) (*APIResponse, error) {
+	body, resp, err := PostJSON(ctx, socket, "/api/v0/blueprints/tag/"+url.PathEscape(bpName), "")
+	if resp != nil || err != nil {
+		return resp, err
+	}
+	return NewAPIResponse(body)
+}
+
+// PostTOMLWorkspaceV0 stores a TOML blueprint string in the workspace without
+// committing it and returns an APIResponse
+func PostTOMLWorkspaceV0(ctx context.Context, socket *http.Client, blueprint string) (*APIResponse, error) {
+	body, resp, err := PostTOML(ctx, socket, "/api/v0/blueprints/workspace", blueprint)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+	return NewAPIResponse(body)
+}
+
+// PostJSONWorkspaceV0 stores a JSON blueprint string in the workspace without
+// committing it and returns an APIResponse
+func PostJSONWorkspaceV0(ctx context.Context, socket *http.Client, blueprint string) (*APIResponse, error) {
+	body, resp, err := PostJSON(ctx, socket, "/api/v0/blueprints/workspace", blueprint)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+	return NewAPIResponse(body)
+}
+
+// DeleteWorkspaceV0 discards the uncommitted changes of the named blueprint
+// and returns an APIResponse
+func DeleteWorkspaceV0(ctx context.Context, socket *http.Client, bpName string) (*APIResponse, error) {
+	body, resp, err := DeleteRaw(ctx, socket, "/api/v0/blueprints/workspace/"+url.PathEscape(bpName))
 	if resp != nil || err != nil {
 		return resp, err
 	}
@@ -4,6 +4,7 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,6 +14,7 @@ import (
 )
 
 // Request handles sending the request, handling errors, returning the response
+// ctx is the context the request is bound to, cancelling it aborts the request
 // socket is the path to a Unix Domain socket
 // path is the full URL path, including query strings
 // body is the data to send with POST
@@ -20,11 +22,12 @@ import (
 //
 // If it is successful a http.Response will be returned. If there is an error, the response will be
 // nil and error will be returned.
-func Request(socket *http.Client, method, path, body string, headers map[string]string) (*http.Response, error) {
+func Request(ctx context.Context, socket *http.Client, method, path, body string, headers map[string]string) (*http.Response, error) {
 	req, err := http.NewRequest(method, "http://localhost"+path, bytes.NewReader([]byte(body)))
 	if err != nil {
 		return nil, err
 	}
+	req = req.WithContext(ctx)
 
 	for h, v := range headers {
 		req.Header.Set(h, v)
@@ -100,8 +103,8 @@ func apiError(resp *http.Response) (*APIResponse, error) {
 
 // GetRawBody returns the resp.Body io.ReadCloser to the caller
 // NOTE: The caller is responsible for closing the Body when finished
-func GetRawBody(socket *http.Client, method, path string) (io.ReadCloser, *APIResponse, error) {
-	resp, err := Request(socket, method, path, "", map[string]string{})
+func GetRawBody(ctx context.Context, socket *http.Client, method, path string) (io.ReadCloser, *APIResponse, error) {
+	resp, err := Request(ctx, socket, method, path, "", map[string]string{})
 	if err != nil {
 		return nil, nil, err
 	}
@@ -117,8 +120,8 @@ func GetRawBody(socket *http.Client, method, path string) (io.ReadCloser, *APIRe
 
 // GetRaw returns raw data from a GET request
 // Errors from the API are returned as an APIResponse, client errors are returned as error
-func GetRaw(socket *http.Client, method, path string) ([]byte, *APIResponse, error) {
-	body, resp, err := GetRawBody(socket, method, path)
+func GetRaw(ctx context.Context, socket *http.Client, method, path string) ([]byte, *APIResponse, error) {
+	body, resp, err := GetRawBody(ctx, socket, method, path)
 	if err != nil || resp != nil {
 		return nil, resp, err
 	}
@@ -137,8 +140,8 @@ func GetRaw(socket *http.Client, method, path string) ([]byte, *APIResponse, err
 // and then with limit=TOTAL to fetch all of the results.
 // The path passed to GetJSONAll should not include the limit or offset query parameters
 // Errors from the API are returned as an APIResponse, client errors are returned as error
-func GetJSONAll(socket *http.Client, path string) ([]byte, *APIResponse, error) {
-	body, api, err := GetRaw(socket, "GET", path+"?limit=0")
+func GetJSONAll(ctx context.Context, socket *http.Client, path string) ([]byte, *APIResponse, error) {
+	body, api, err := GetRaw(ctx, socket, "GET", path+"?limit=0")
 	if api != nil || err != nil {
 		return nil, api, err
 	}
@@ -159,15 +162,15 @@ func GetJSONAll(socket *http.Client, path string) ([]byte, *APIResponse, error)
 	switch total := v.(type) {
 	case float64:
 		allResults := fmt.Sprintf("%s?limit=%v", path, total)
-		return GetRaw(socket, "GET", allResults)
+		return GetRaw(ctx, socket, "GET", allResults)
 	}
 	return nil, nil, errors.New("Response 'total' is not a float64")
 }
 
 // PostRaw sends a POST with raw data and returns the raw response body
 // Errors from the API are returned as an APIResponse, client errors are returned as error
-func PostRaw(socket *http.Client, path, body string, headers map[string]string) ([]byte, *APIResponse, error) {
-	resp, err := Request(socket, "POST", path, body, headers)
+func PostRaw(ctx context.Context, socket *http.Client, path, body string, headers map[string]string) ([]byte, *APIResponse, error) {
+	resp, err := Request(ctx, socket, "POST", path, body, headers)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -189,22 +192,22 @@ func PostRaw(socket *http.Client, path, body string, headers map[string]string)
 
 // PostTOML sends a POST with TOML data and the Content-Type header set to "text/x-toml"
 // Errors from the API are returned as an APIResponse, client errors are returned as error
-func PostTOML(socket *http.Client, path, body string) ([]byte, *APIResponse, error) {
+func PostTOML(ctx context.Context, socket *http.Client, path, body string) ([]byte, *APIResponse, error) {
 	headers := map[string]string{"Content-Type": "text/x-toml"}
-	return PostRaw(socket, path, body, headers)
+	return PostRaw(ctx, socket, path, body, headers)
 }
 
 // PostJSON sends a POST with JSON data and the Content-Type header set to "application/json"
 // Errors from the API are returned as an APIResponse, client errors are returned as error
-func PostJSON(socket *http.Client, path, body string) ([]byte, *APIResponse, error) {
+func PostJSON(ctx context.Context, socket *http.Client, path, body string) ([]byte, *APIResponse, error) {
 	headers := map[string]string{"Content-Type": "application/json"}
-	return PostRaw(socket, path, body, headers)
+	return PostRaw(ctx, socket, path, body, headers)
 }
 
 // DeleteRaw sends a DELETE request
 // Errors from the API are returned as an APIResponse, client errors are returned as error
-func DeleteRaw(socket *http.Client, path string) ([]byte, *APIResponse, error) {
-	resp, err := Request(socket, "DELETE", path, "", nil)
+func DeleteRaw(ctx context.Context, socket *http.Client, path string) ([]byte, *APIResponse, error) {
+	resp, err := Request(ctx, socket, "DELETE", path, "", nil)
 	if err != nil {
 		return nil, nil, err
 	}
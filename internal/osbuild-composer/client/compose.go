@@ -3,26 +3,45 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/weldr"
 )
 
 // PostComposeV0 sends a JSON compose string to the API
-// and returns an APIResponse
-func PostComposeV0(socket *http.Client, compose string) (*APIResponse, error) {
-	body, resp, err := PostJSON(socket, "/api/v0/compose", compose)
+// and returns the id of the new compose
+func PostComposeV0(ctx context.Context, socket *http.Client, compose string) (weldr.ComposeResponseV0, *APIResponse, error) {
+	body, resp, err := PostJSON(ctx, socket, "/api/v0/compose", compose)
 	if resp != nil || err != nil {
-		return resp, err
+		return weldr.ComposeResponseV0{}, resp, err
+	}
+	var composeResponse weldr.ComposeResponseV0
+	err = json.Unmarshal(body, &composeResponse)
+	if err != nil {
+		return weldr.ComposeResponseV0{}, nil, err
+	}
+	return composeResponse, nil, nil
+}
+
+// PostComposeRequestV0 marshals the compose request, sends it to the API and
+// returns the id of the new compose
+func PostComposeRequestV0(ctx context.Context, socket *http.Client, request weldr.ComposeRequestV0) (weldr.ComposeResponseV0, *APIResponse, error) {
+	compose, err := json.Marshal(request)
+	if err != nil {
+		return weldr.ComposeResponseV0{}, nil, err
 	}
-	return NewAPIResponse(body)
+	return PostComposeV0(ctx, socket, string(compose))
 }
 
 // GetComposeStatusV0 returns a list of composes matching the optional filter parameters
-func GetComposeStatusV0(socket *http.Client, uuids, blueprint, status, composeType string) ([]weldr.ComposeEntryV0, *APIResponse, error) {
+func GetComposeStatusV0(ctx context.Context, socket *http.Client, uuids, blueprint, status, composeType string) ([]weldr.ComposeEntryV0, *APIResponse, error) {
 	// Build the query string
 	route := "/api/v0/compose/status/" + uuids
 
@@ -41,7 +60,7 @@ func GetComposeStatusV0(socket *http.Client, uuids, blueprint, status, composeTy
 		route = route + "?" + params.Encode()
 	}
 
-	body, resp, err := GetRaw(socket, "GET", route)
+	body, resp, err := GetRaw(ctx, socket, "GET", route)
 	if resp != nil || err != nil {
 		return []weldr.ComposeEntryV0{}, resp, err
 	}
@@ -53,9 +72,67 @@ func GetComposeStatusV0(socket *http.Client, uuids, blueprint, status, composeTy
 	return composes.UUIDs, nil, nil
 }
 
-// GetComposeTypesV0 returns a list of the failed composes
-func GetComposesTypesV0(socket *http.Client) ([]weldr.ComposeTypeV0, *APIResponse, error) {
-	body, resp, err := GetRaw(socket, "GET", "/api/v0/compose/types")
+// GetComposesQueueV0 returns the composes waiting for a worker and the
+// composes being built
+func GetComposesQueueV0(ctx context.Context, socket *http.Client) (weldr.ComposeQueueResponseV0, *APIResponse, error) {
+	body, resp, err := GetRaw(ctx, socket, "GET", "/api/v0/compose/queue")
+	if resp != nil || err != nil {
+		return weldr.ComposeQueueResponseV0{}, resp, err
+	}
+	var queue weldr.ComposeQueueResponseV0
+	err = json.Unmarshal(body, &queue)
+	if err != nil {
+		return weldr.ComposeQueueResponseV0{}, nil, err
+	}
+	return queue, nil, nil
+}
+
+// GetFinishedComposesV0 returns a list of the finished composes
+func GetFinishedComposesV0(ctx context.Context, socket *http.Client) ([]weldr.ComposeEntryV0, *APIResponse, error) {
+	body, resp, err := GetRaw(ctx, socket, "GET", "/api/v0/compose/finished")
+	if resp != nil || err != nil {
+		return []weldr.ComposeEntryV0{}, resp, err
+	}
+	var finished weldr.ComposeFinishedResponseV0
+	err = json.Unmarshal(body, &finished)
+	if err != nil {
+		return []weldr.ComposeEntryV0{}, nil, err
+	}
+	return finished.Finished, nil, nil
+}
+
+// GetFailedComposesV0 returns a list of the failed composes
+func GetFailedComposesV0(ctx context.Context, socket *http.Client) ([]weldr.ComposeEntryV0, *APIResponse, error) {
+	body, resp, err := GetRaw(ctx, socket, "GET", "/api/v0/compose/failed")
+	if resp != nil || err != nil {
+		return []weldr.ComposeEntryV0{}, resp, err
+	}
+	var failed weldr.ComposeFailedResponseV0
+	err = json.Unmarshal(body, &failed)
+	if err != nil {
+		return []weldr.ComposeEntryV0{}, nil, err
+	}
+	return failed.Failed, nil, nil
+}
+
+// GetComposesTypesV0 returns the image types of the distro and arch, empty
+// values select the defaults of the host
+func GetComposesTypesV0(ctx context.Context, socket *http.Client, distro, arch string) ([]weldr.ComposeTypeV0, *APIResponse, error) {
+	route := "/api/v0/compose/types"
+
+	params := url.Values{}
+	if len(distro) > 0 {
+		params.Add("distro", distro)
+	}
+	if len(arch) > 0 {
+		params.Add("arch", arch)
+	}
+
+	if len(params) > 0 {
+		route = route + "?" + params.Encode()
+	}
+
+	body, resp, err := GetRaw(ctx, socket, "GET", route)
 	if resp != nil || err != nil {
 		return []weldr.ComposeTypeV0{}, resp, err
 	}
@@ -68,8 +145,8 @@ func GetComposesTypesV0(socket *http.Client) ([]weldr.ComposeTypeV0, *APIRespons
 }
 
 // DeleteComposeV0 deletes one or more composes based on their uuid
-func DeleteComposeV0(socket *http.Client, uuids string) (weldr.DeleteComposeResponseV0, *APIResponse, error) {
-	body, resp, err := DeleteRaw(socket, "/api/v0/compose/delete/"+uuids)
+func DeleteComposeV0(ctx context.Context, socket *http.Client, uuids string) (weldr.DeleteComposeResponseV0, *APIResponse, error) {
+	body, resp, err := DeleteRaw(ctx, socket, "/api/v0/compose/delete/"+uuids)
 	if resp != nil || err != nil {
 		return weldr.DeleteComposeResponseV0{}, resp, err
 	}
@@ -81,9 +158,23 @@ func DeleteComposeV0(socket *http.Client, uuids string) (weldr.DeleteComposeResp
 	return deleteResponse, nil, nil
 }
 
+// CancelComposeV0 cancels a waiting or running compose based on its uuid
+func CancelComposeV0(ctx context.Context, socket *http.Client, uuid string) (weldr.CancelComposeStatusV0, *APIResponse, error) {
+	body, resp, err := DeleteRaw(ctx, socket, "/api/v0/compose/cancel/"+uuid)
+	if resp != nil || err != nil {
+		return weldr.CancelComposeStatusV0{}, resp, err
+	}
+	var cancelResponse weldr.CancelComposeStatusV0
+	err = json.Unmarshal(body, &cancelResponse)
+	if err != nil {
+		return weldr.CancelComposeStatusV0{}, nil, err
+	}
+	return cancelResponse, nil, nil
+}
+
 // WriteComposeImageV0 requests the image for a compose and writes it to an io.Writer
-func WriteComposeImageV0(socket *http.Client, w io.Writer, uuid string) (*APIResponse, error) {
-	body, resp, err := GetRawBody(socket, "GET", "/api/v0/compose/image/"+uuid)
+func WriteComposeImageV0(ctx context.Context, socket *http.Client, w io.Writer, uuid string) (*APIResponse, error) {
+	body, resp, err := GetRawBody(ctx, socket, "GET", "/api/v0/compose/image/"+uuid)
 	if resp != nil || err != nil {
 		return resp, err
 	}
@@ -93,9 +184,86 @@ func WriteComposeImageV0(socket *http.Client, w io.Writer, uuid string) (*APIRes
 	return nil, err
 }
 
+// ImageRange describes a part of an image returned by GetRange
+type ImageRange struct {
+	// Offset is the position in the image where the returned body starts
+	Offset int64
+	// Size is the size of the whole image, -1 if the server didn't send it
+	Size int64
+	// Digest is the value of the Digest header (RFC 3230) describing the
+	// whole image, empty if the server didn't send it
+	Digest string
+}
+
+// GetRange requests a file starting at the given offset. The returned range
+// tells where the body actually starts, the server may ignore the offset and
+// return the whole file. If the offset is at the end of the file, the body is
+// empty. A response with any other status than 200, 206 or 416 is returned
+// to the caller with a nil body, so it can decode the error.
+// NOTE: The caller is responsible for closing the body or the response when
+// finished
+func GetRange(ctx context.Context, socket *http.Client, path string, offset int64) (io.ReadCloser, ImageRange, *http.Response, error) {
+	headers := map[string]string{}
+	if offset > 0 {
+		headers["Range"] = fmt.Sprintf("bytes=%d-", offset)
+	}
+
+	resp, err := Request(ctx, socket, "GET", path, "", headers)
+	if err != nil {
+		return nil, ImageRange{}, nil, err
+	}
+
+	imageRange := ImageRange{
+		Size:   -1,
+		Digest: resp.Header.Get("Digest"),
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		imageRange.Size = resp.ContentLength
+		return resp.Body, imageRange, nil, nil
+	case http.StatusPartialContent:
+		var end int64
+		_, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &imageRange.Offset, &end, &imageRange.Size)
+		if err != nil || imageRange.Offset != offset {
+			resp.Body.Close()
+			return nil, ImageRange{}, nil, fmt.Errorf("unexpected Content-Range %q", resp.Header.Get("Content-Range"))
+		}
+		return resp.Body, imageRange, nil, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		_, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes */%d", &imageRange.Size)
+		if err != nil || imageRange.Size != offset {
+			return nil, ImageRange{}, nil, fmt.Errorf("offset %d is beyond the end of the image", offset)
+		}
+		imageRange.Offset = offset
+		return ioutil.NopCloser(strings.NewReader("")), imageRange, nil, nil
+	}
+
+	return nil, ImageRange{}, resp, nil
+}
+
+// GetComposeImageRangeV0 requests the image for a compose starting at the
+// given offset, see GetRange
+// NOTE: The caller is responsible for closing the body when finished
+func GetComposeImageRangeV0(ctx context.Context, socket *http.Client, uuid string, offset int64) (io.ReadCloser, ImageRange, *APIResponse, error) {
+	body, imageRange, resp, err := GetRange(ctx, socket, "/api/v0/compose/image/"+uuid, offset)
+	if resp == nil || err != nil {
+		return body, imageRange, nil, err
+	}
+
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusNotFound {
+		apiResponse, err := apiError(resp)
+		return nil, ImageRange{}, apiResponse, err
+	}
+
+	resp.Body.Close()
+	return nil, ImageRange{}, nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+}
+
 // WriteComposeLogV0 requests the log for a compose and writes it to an io.Writer
-func WriteComposeLogV0(socket *http.Client, w io.Writer, uuid string) (*APIResponse, error) {
-	body, resp, err := GetRawBody(socket, "GET", "/api/v0/compose/log/"+uuid)
+func WriteComposeLogV0(ctx context.Context, socket *http.Client, w io.Writer, uuid string) (*APIResponse, error) {
+	body, resp, err := GetRawBody(ctx, socket, "GET", "/api/v0/compose/log/"+uuid)
 	if resp != nil || err != nil {
 		return resp, err
 	}
@@ -106,8 +274,8 @@ func WriteComposeLogV0(socket *http.Client, w io.Writer, uuid string) (*APIRespo
 }
 
 // WriteComposeMetadataV0 requests the metadata for a compose and writes it to an io.Writer
-func WriteComposeMetadataV0(socket *http.Client, w io.Writer, uuid string) (*APIResponse, error) {
-	body, resp, err := GetRawBody(socket, "GET", "/api/v0/compose/metadata/"+uuid)
+func WriteComposeMetadataV0(ctx context.Context, socket *http.Client, w io.Writer, uuid string) (*APIResponse, error) {
+	body, resp, err := GetRawBody(ctx, socket, "GET", "/api/v0/compose/metadata/"+uuid)
 	if resp != nil || err != nil {
 		return resp, err
 	}
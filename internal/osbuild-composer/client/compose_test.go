@@ -0,0 +1,82 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/client"
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/weldr"
+	"github.com/ondrejbudai/osbuild-image/internal/weldrtest"
+)
+
+// FuzzComposeRequest checks that any blueprint name survives being pushed,
+// composed, looked up and deleted, i.e. that it's encoded correctly both in
+// the json bodies and in the URLs
+func FuzzComposeRequest(f *testing.F) {
+	for _, name := range []string{
+		"simple",
+		`with "quotes"`,
+		`back\slash`,
+		"ünïcödé ☃ 名前",
+		"a?b#c%d/e",
+		"spaces and\ttabs",
+		"%2F",
+	} {
+		f.Add(name)
+	}
+
+	server := weldrtest.NewServer()
+	defer server.Close()
+	c := server.Client()
+	ctx := context.Background()
+
+	f.Fuzz(func(t *testing.T, name string) {
+		// invalid utf-8 cannot be encoded as json, dot segments are
+		// resolved by the servers and commas separate names in the URLs
+		if name == "" || !utf8.ValidString(name) || name == "." || name == ".." || strings.Contains(name, ",") {
+			t.Skip()
+		}
+
+		blueprint, err := json.Marshal(map[string]string{"name": name})
+		if err != nil {
+			t.Fatalf("cannot marshal the blueprint: %v", err)
+		}
+
+		response, err := client.PostJSONBlueprintV0(ctx, c, string(blueprint))
+		if err != nil || !response.Status {
+			t.Fatalf("cannot push the blueprint %q: %v %v", name, err, response)
+		}
+
+		info, response, err := client.GetBlueprintsInfoV0(ctx, c, name)
+		if err != nil || response != nil {
+			t.Fatalf("cannot get the blueprint %q: %v %v", name, err, response)
+		}
+		if len(info.Changes) != 1 || info.Changes[0].Name != name {
+			t.Fatalf("the blueprint %q was not found: %+v", name, info)
+		}
+
+		compose, response, err := client.PostComposeRequestV0(ctx, c, weldr.ComposeRequestV0{
+			BlueprintName: name,
+			ComposeType:   "qcow2",
+		})
+		if err != nil || response != nil {
+			t.Fatalf("cannot compose the blueprint %q: %v %v", name, err, response)
+		}
+
+		composes, response, err := client.GetComposeStatusV0(ctx, c, compose.BuildID.String(), name, "", "")
+		if err != nil || response != nil {
+			t.Fatalf("cannot get the compose of %q: %v %v", name, err, response)
+		}
+		if len(composes) != 1 || composes[0].Blueprint != name {
+			t.Fatalf("unexpected composes of %q: %+v", name, composes)
+		}
+
+		response, err = client.DeleteBlueprintV0(ctx, c, name)
+		if err != nil || !response.Status {
+			t.Fatalf("cannot delete the blueprint %q: %v %v", name, err, response)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+// Package client - modules contains functions for the modules API
+// Copyright (C) 2020 by Red Hat, Inc.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/weldr"
+)
+
+// ListAllModulesV0 returns a list of all the modules available in the
+// repositories. If globs is not empty, only the modules matching one of the
+// comma-separated globs are returned.
+func ListAllModulesV0(ctx context.Context, socket *http.Client, globs string) ([]weldr.ModuleNameV0, *APIResponse, error) {
+	route := "/api/v0/modules/list"
+	if globs != "" {
+		route = route + "/" + globs
+	}
+
+	body, resp, err := GetJSONAll(ctx, socket, route)
+	if resp != nil || err != nil {
+		return []weldr.ModuleNameV0{}, resp, err
+	}
+	var list weldr.ModulesListResponseV0
+	err = json.Unmarshal(body, &list)
+	if err != nil {
+		return []weldr.ModuleNameV0{}, nil, err
+	}
+	return list.Modules, nil, nil
+}
+
+// GetModulesInfoV0 returns the details, including the builds and the
+// dependencies, of one or more comma-separated modules
+func GetModulesInfoV0(ctx context.Context, socket *http.Client, names string) ([]weldr.ProjectV0, *APIResponse, error) {
+	body, resp, err := GetRaw(ctx, socket, "GET", "/api/v0/modules/info/"+names)
+	if resp != nil || err != nil {
+		return []weldr.ProjectV0{}, resp, err
+	}
+	var info weldr.ModulesInfoResponseV0
+	err = json.Unmarshal(body, &info)
+	if err != nil {
+		return []weldr.ProjectV0{}, nil, err
+	}
+	return info.Modules, nil, nil
+}
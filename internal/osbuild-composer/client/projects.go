@@ -0,0 +1,56 @@
+// Package client - projects contains functions for the projects API
+// Copyright (C) 2020 by Red Hat, Inc.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/weldr"
+)
+
+// ListAllProjectsV0 returns a list of all the projects available in the
+// repositories
+func ListAllProjectsV0(ctx context.Context, socket *http.Client) ([]weldr.ProjectV0, *APIResponse, error) {
+	body, resp, err := GetJSONAll(ctx, socket, "/api/v0/projects/list")
+	if resp != nil || err != nil {
+		return []weldr.ProjectV0{}, resp, err
+	}
+	var list weldr.ProjectsListResponseV0
+	err = json.Unmarshal(body, &list)
+	if err != nil {
+		return []weldr.ProjectV0{}, nil, err
+	}
+	return list.Projects, nil, nil
+}
+
+// GetProjectsInfoV0 returns the details, including the builds, of one or more
+// comma-separated projects
+func GetProjectsInfoV0(ctx context.Context, socket *http.Client, names string) ([]weldr.ProjectV0, *APIResponse, error) {
+	body, resp, err := GetRaw(ctx, socket, "GET", "/api/v0/projects/info/"+names)
+	if resp != nil || err != nil {
+		return []weldr.ProjectV0{}, resp, err
+	}
+	var info weldr.ProjectsInfoResponseV0
+	err = json.Unmarshal(body, &info)
+	if err != nil {
+		return []weldr.ProjectV0{}, nil, err
+	}
+	return info.Projects, nil, nil
+}
+
+// DepsolveProjectsV0 returns the packages needed to install one or more
+// comma-separated projects
+func DepsolveProjectsV0(ctx context.Context, socket *http.Client, names string) ([]weldr.PackageSpecV0, *APIResponse, error) {
+	body, resp, err := GetRaw(ctx, socket, "GET", "/api/v0/projects/depsolve/"+names)
+	if resp != nil || err != nil {
+		return []weldr.PackageSpecV0{}, resp, err
+	}
+	var deps weldr.ProjectsDepsolveResponseV0
+	err = json.Unmarshal(body, &deps)
+	if err != nil {
+		return []weldr.PackageSpecV0{}, nil, err
+	}
+	return deps.Projects, nil, nil
+}
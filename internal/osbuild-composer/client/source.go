@@ -0,0 +1,69 @@
+// Package client - source contains functions for the source API
+// Copyright (C) 2020 by Red Hat, Inc.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/weldr"
+)
+
+// ListSourcesV0 returns the names of all the sources
+func ListSourcesV0(ctx context.Context, socket *http.Client) ([]string, *APIResponse, error) {
+	body, resp, err := GetRaw(ctx, socket, "GET", "/api/v0/projects/source/list")
+	if resp != nil || err != nil {
+		return []string{}, resp, err
+	}
+	var list weldr.SourceListV0
+	err = json.Unmarshal(body, &list)
+	if err != nil {
+		return []string{}, nil, err
+	}
+	return list.Sources, nil, nil
+}
+
+// GetSourceInfoV0 returns the details of one or more comma-separated sources
+func GetSourceInfoV0(ctx context.Context, socket *http.Client, sourceNames string) (weldr.SourceInfoResponseV0, *APIResponse, error) {
+	body, resp, err := GetRaw(ctx, socket, "GET", "/api/v0/projects/source/info/"+escapeNames(sourceNames))
+	if resp != nil || err != nil {
+		return weldr.SourceInfoResponseV0{}, resp, err
+	}
+	var info weldr.SourceInfoResponseV0
+	err = json.Unmarshal(body, &info)
+	if err != nil {
+		return weldr.SourceInfoResponseV0{}, nil, err
+	}
+	return info, nil, nil
+}
+
+// PostTOMLSourceV0 sends a TOML source string to the API
+// and returns an APIResponse
+func PostTOMLSourceV0(ctx context.Context, socket *http.Client, source string) (*APIResponse, error) {
+	body, resp, err := PostTOML(ctx, socket, "/api/v0/projects/source/new", source)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+	return NewAPIResponse(body)
+}
+
+// PostJSONSourceV0 sends a JSON source string to the API
+// and returns an APIResponse
+func PostJSONSourceV0(ctx context.Context, socket *http.Client, source string) (*APIResponse, error) {
+	body, resp, err := PostJSON(ctx, socket, "/api/v0/projects/source/new", source)
+	if resp != nil || err != nil {
+		return resp, err
+	}
+	return NewAPIResponse(body)
+}
+
+// DeleteSourceV0 deletes the named source and returns an APIResponse
+func DeleteSourceV0(ctx context.Context, socket *http.Client, sourceName string) (*APIResponse, error) {
+	body, resp, err := DeleteRaw(ctx, socket, "/api/v0/projects/source/delete/"+url.PathEscape(sourceName))
+	if resp != nil || err != nil {
+		return resp, err
+	}
+	return NewAPIResponse(body)
+}
@@ -0,0 +1,108 @@
+package cloudapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/client"
+)
+
+// call sends the request and decodes the response into v (if it's not nil).
+// A response with another status than expected is decoded as an *Error.
+func call(ctx context.Context, socket *http.Client, method, path, body string, expected int, v interface{}) error {
+	headers := map[string]string{}
+	if body != "" {
+		headers["Content-Type"] = "application/json"
+	}
+
+	resp, err := client.Request(ctx, socket, method, BasePath+path, body, headers)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != expected {
+		return decodeError(resp)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("cannot decode the response: %v", err)
+	}
+	return nil
+}
+
+// decodeError converts a failed response into an *Error, the body of
+// responses not coming from the Cloud API (e.g. from a proxy) is ignored
+func decodeError(resp *http.Response) error {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var apiError Error
+	if json.Unmarshal(body, &apiError) != nil || apiError.Reason == "" {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return &apiError
+}
+
+// PostCompose starts a new compose and returns its id
+func PostCompose(ctx context.Context, socket *http.Client, request ComposeRequest) (ComposeID, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return ComposeID{}, err
+	}
+
+	var id ComposeID
+	err = call(ctx, socket, "POST", "/compose", string(body), http.StatusCreated, &id)
+	return id, err
+}
+
+// GetComposeStatus returns the status of a compose
+func GetComposeStatus(ctx context.Context, socket *http.Client, id string) (ComposeStatus, error) {
+	var status ComposeStatus
+	err := call(ctx, socket, "GET", "/composes/"+id, "", http.StatusOK, &status)
+	return status, err
+}
+
+// GetComposeLogs returns the logs of a finished or failed compose
+func GetComposeLogs(ctx context.Context, socket *http.Client, id string) (ComposeLogs, error) {
+	var logs ComposeLogs
+	err := call(ctx, socket, "GET", "/composes/"+id+"/logs", "", http.StatusOK, &logs)
+	return logs, err
+}
+
+// GetComposeManifests returns the manifests of a compose
+func GetComposeManifests(ctx context.Context, socket *http.Client, id string) (ComposeManifests, error) {
+	var manifests ComposeManifests
+	err := call(ctx, socket, "GET", "/composes/"+id+"/manifests", "", http.StatusOK, &manifests)
+	return manifests, err
+}
+
+// DeleteCompose deletes a finished or failed compose together with its
+// artifacts
+func DeleteCompose(ctx context.Context, socket *http.Client, id string) error {
+	return call(ctx, socket, "DELETE", "/composes/"+id, "", http.StatusOK, nil)
+}
+
+// GetComposeDownloadRange requests the image of a compose without upload
+// options starting at the given offset, see client.GetRange
+// NOTE: The caller is responsible for closing the body when finished
+func GetComposeDownloadRange(ctx context.Context, socket *http.Client, id string, offset int64) (io.ReadCloser, client.ImageRange, error) {
+	body, imageRange, resp, err := client.GetRange(ctx, socket, BasePath+"/composes/"+id+"/download", offset)
+	if resp == nil || err != nil {
+		return body, imageRange, err
+	}
+	defer resp.Body.Close()
+
+	return nil, client.ImageRange{}, decodeError(resp)
+}
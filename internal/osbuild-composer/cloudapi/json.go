@@ -0,0 +1,131 @@
+// Package cloudapi contains the types of the osbuild-composer's Cloud API (v2)
+// used by osbuild-image and the functions calling it. It's a subset of the API,
+// only the fields osbuild-image needs are described.
+package cloudapi
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// BasePath is the path all the Cloud API v2 routes start with
+const BasePath = "/api/image-builder-composer/v2"
+
+// ComposeRequest starts a new compose of one image
+type ComposeRequest struct {
+	Distribution string `json:"distribution"`
+	// Blueprint is a json blueprint, it replaces the customizations
+	Blueprint    json.RawMessage `json:"blueprint,omitempty"`
+	ImageRequest *ImageRequest   `json:"image_request,omitempty"`
+}
+
+// ImageRequest describes the image of a compose, no upload options means the
+// image is kept by osbuild-composer and it can be downloaded
+type ImageRequest struct {
+	Architecture string       `json:"architecture"`
+	ImageType    string       `json:"image_type"`
+	Repositories []Repository `json:"repositories"`
+	OSTree       *OSTree      `json:"ostree,omitempty"`
+	// Size is the size of the image in bytes, 0 means the default size of
+	// the image type
+	Size uint64 `json:"size,omitempty"`
+}
+
+// Repository is a repository the packages are installed from, exactly one of
+// Baseurl, Metalink and Mirrorlist is set
+type Repository struct {
+	Baseurl    string `json:"baseurl,omitempty"`
+	Metalink   string `json:"metalink,omitempty"`
+	Mirrorlist string `json:"mirrorlist,omitempty"`
+	CheckGPG   bool   `json:"check_gpg"`
+	RHSM       bool   `json:"rhsm"`
+}
+
+// OSTree are the ostree parameters of an edge or iot image
+type OSTree struct {
+	URL    string `json:"url,omitempty"`
+	Ref    string `json:"ref,omitempty"`
+	Parent string `json:"parent,omitempty"`
+}
+
+// ComposeID is returned when a compose is started
+type ComposeID struct {
+	Href string `json:"href"`
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+}
+
+// ComposeStatusValue is the overall status of a compose
+type ComposeStatusValue string
+
+const (
+	ComposeStatusPending ComposeStatusValue = "pending"
+	ComposeStatusSuccess ComposeStatusValue = "success"
+	ComposeStatusFailure ComposeStatusValue = "failure"
+)
+
+// ImageStatusValue is the status of the image of a compose, it's more
+// detailed than ComposeStatusValue
+type ImageStatusValue string
+
+const (
+	ImageStatusPending     ImageStatusValue = "pending"
+	ImageStatusBuilding    ImageStatusValue = "building"
+	ImageStatusUploading   ImageStatusValue = "uploading"
+	ImageStatusRegistering ImageStatusValue = "registering"
+	ImageStatusSuccess     ImageStatusValue = "success"
+	ImageStatusFailure     ImageStatusValue = "failure"
+)
+
+// ComposeStatus is the status of a compose
+type ComposeStatus struct {
+	Href        string             `json:"href"`
+	ID          string             `json:"id"`
+	Kind        string             `json:"kind"`
+	Status      ComposeStatusValue `json:"status"`
+	ImageStatus ImageStatus        `json:"image_status"`
+}
+
+// ImageStatus is the status of the image of a compose, Error describes why
+// a failed image failed
+type ImageStatus struct {
+	Status ImageStatusValue    `json:"status"`
+	Error  *ComposeStatusError `json:"error,omitempty"`
+}
+
+type ComposeStatusError struct {
+	ID      int         `json:"id"`
+	Reason  string      `json:"reason"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// ComposeLogs are the logs of the image builds of a compose, their format is
+// not specified by the API
+type ComposeLogs struct {
+	Href        string            `json:"href"`
+	ID          string            `json:"id"`
+	Kind        string            `json:"kind"`
+	ImageBuilds []json.RawMessage `json:"image_builds"`
+}
+
+// ComposeManifests are the osbuild manifests of the images of a compose
+type ComposeManifests struct {
+	Href      string            `json:"href"`
+	ID        string            `json:"id"`
+	Kind      string            `json:"kind"`
+	Manifests []json.RawMessage `json:"manifests"`
+}
+
+// Error is the body of every failed Cloud API response
+type Error struct {
+	Href        string `json:"href"`
+	ID          string `json:"id"`
+	Kind        string `json:"kind"`
+	Code        string `json:"code"`
+	Reason      string `json:"reason"`
+	OperationID string `json:"operation_id"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Reason)
+}
@@ -3,6 +3,8 @@
 package weldr
 
 import (
+	"encoding/json"
+
 	"github.com/google/uuid"
 
 	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/common"
@@ -15,10 +17,27 @@ type ResponseError struct {
 	Msg  string `json:"msg"`
 }
 
+// ComposeRequestV0 starts a new compose, Size is the size of the image in
+// bytes, 0 means the default size of the image type. Distro and Arch are
+// empty for the defaults of the host.
 type ComposeRequestV0 struct {
-	BlueprintName string `json:"blueprint_name"`
-	ComposeType   string `json:"compose_type"`
-	Branch        string `json:"branch"`
+	BlueprintName string           `json:"blueprint_name"`
+	ComposeType   string           `json:"compose_type"`
+	Distro        string           `json:"distro,omitempty"`
+	Arch          string           `json:"arch,omitempty"`
+	Size          uint64           `json:"size"`
+	OSTree        *OSTreeRequestV0 `json:"ostree,omitempty"`
+	Branch        string           `json:"branch"`
+	Upload        *UploadRequest   `json:"upload,omitempty"`
+}
+
+// OSTreeRequestV0 are the ostree parameters of a compose, Ref is the ref of
+// the new commit, Parent is the ref or checksum of its parent and URL is the
+// repository the parent is pulled from
+type OSTreeRequestV0 struct {
+	URL    string `json:"url,omitempty"`
+	Ref    string `json:"ref,omitempty"`
+	Parent string `json:"parent,omitempty"`
 }
 type ComposeResponseV0 struct {
 	BuildID uuid.UUID `json:"build_id"`
@@ -36,13 +55,28 @@ type ComposeEntryV0 struct {
 	JobCreated  float64                `json:"job_created"`
 	JobStarted  float64                `json:"job_started,omitempty"`
 	JobFinished float64                `json:"job_finished,omitempty"`
-	Uploads     []uploadResponse       `json:"uploads,omitempty"`
+	Uploads     []UploadResponse       `json:"uploads,omitempty"`
 }
 
 type ComposeStatusResponseV0 struct {
 	UUIDs []ComposeEntryV0 `json:"uuids"`
 }
 
+// ComposeQueueResponseV0 lists the composes waiting for a worker (New) and
+// the ones being built (Run)
+type ComposeQueueResponseV0 struct {
+	New []ComposeEntryV0 `json:"new"`
+	Run []ComposeEntryV0 `json:"run"`
+}
+
+type ComposeFinishedResponseV0 struct {
+	Finished []ComposeEntryV0 `json:"finished"`
+}
+
+type ComposeFailedResponseV0 struct {
+	Failed []ComposeEntryV0 `json:"failed"`
+}
+
 type ComposeTypeV0 struct {
 	Name    string `json:"name"`
 	Enabled bool   `json:"enabled"`
@@ -61,3 +95,173 @@ type DeleteComposeResponseV0 struct {
 	UUIDs  []DeleteComposeStatusV0 `json:"uuids"`
 	Errors []ResponseError         `json:"errors"`
 }
+
+type CancelComposeStatusV0 struct {
+	UUID   uuid.UUID `json:"uuid"`
+	Status bool      `json:"status"`
+}
+
+type BlueprintsListResponseV0 struct {
+	Total      uint     `json:"total"`
+	Offset     uint     `json:"offset"`
+	Limit      uint     `json:"limit"`
+	Blueprints []string `json:"blueprints"`
+}
+
+// BlueprintChangedV0 tells whether the blueprint has uncommitted changes in
+// its workspace
+type BlueprintChangedV0 struct {
+	Name    string `json:"name"`
+	Changed bool   `json:"changed"`
+}
+
+// The blueprints are kept as raw JSON so they can be passed on unchanged
+type BlueprintsInfoResponseV0 struct {
+	Blueprints []json.RawMessage    `json:"blueprints"`
+	Changes    []BlueprintChangedV0 `json:"changes"`
+	Errors     []ResponseError      `json:"errors"`
+}
+
+type BlueprintFrozenV0 struct {
+	Blueprint json.RawMessage `json:"blueprint"`
+}
+
+type BlueprintsFreezeResponseV0 struct {
+	Blueprints []BlueprintFrozenV0 `json:"blueprints"`
+	Errors     []ResponseError     `json:"errors"`
+}
+
+// BlueprintDiffV0 is one difference between two versions of a blueprint. Old
+// is null for additions and New is null for removals, each of them is an
+// object with a single key naming the changed part, e.g. {"Package": {...}}.
+type BlueprintDiffV0 struct {
+	Old json.RawMessage `json:"old"`
+	New json.RawMessage `json:"new"`
+}
+
+type BlueprintsDiffResponseV0 struct {
+	Diff []BlueprintDiffV0 `json:"diff"`
+}
+
+type BlueprintChangeV0 struct {
+	Commit    string `json:"commit"`
+	Message   string `json:"message"`
+	Revision  *int   `json:"revision"`
+	Timestamp string `json:"timestamp"`
+}
+
+type BlueprintChangesV0 struct {
+	Name    string              `json:"name"`
+	Changes []BlueprintChangeV0 `json:"changes"`
+	Total   int                 `json:"total"`
+}
+
+type BlueprintsChangesResponseV0 struct {
+	Blueprints []BlueprintChangesV0 `json:"blueprints"`
+	Errors     []ResponseError      `json:"errors"`
+	Offset     uint                 `json:"offset"`
+	Limit      uint                 `json:"limit"`
+}
+
+// PackageSourceV0 describes the source package of a build
+type PackageSourceV0 struct {
+	License   string `json:"license"`
+	Version   string `json:"version"`
+	SourceRef string `json:"source_ref"`
+}
+
+type PackageBuildV0 struct {
+	Arch           string          `json:"arch"`
+	BuildTime      string          `json:"build_time"`
+	Epoch          uint            `json:"epoch"`
+	Release        string          `json:"release"`
+	Source         PackageSourceV0 `json:"source"`
+	Changelog      string          `json:"changelog"`
+	BuildConfigRef string          `json:"build_config_ref"`
+	BuildEnvRef    string          `json:"build_env_ref"`
+}
+
+// PackageSpecV0 is a package resolved by a depsolve
+type PackageSpecV0 struct {
+	Name    string `json:"name"`
+	Epoch   uint   `json:"epoch"`
+	Version string `json:"version,omitempty"`
+	Release string `json:"release,omitempty"`
+	Arch    string `json:"arch,omitempty"`
+}
+
+// ProjectV0 describes a project (a package) or a module, the list endpoints
+// don't return its builds and only modules/info returns its dependencies
+type ProjectV0 struct {
+	Name         string           `json:"name"`
+	Summary      string           `json:"summary"`
+	Description  string           `json:"description"`
+	Homepage     string           `json:"homepage"`
+	UpstreamVCS  string           `json:"upstream_vcs"`
+	Builds       []PackageBuildV0 `json:"builds,omitempty"`
+	Dependencies []PackageSpecV0  `json:"dependencies,omitempty"`
+}
+
+type ProjectsListResponseV0 struct {
+	Total    uint        `json:"total"`
+	Offset   uint        `json:"offset"`
+	Limit    uint        `json:"limit"`
+	Projects []ProjectV0 `json:"projects"`
+}
+
+type ProjectsInfoResponseV0 struct {
+	Projects []ProjectV0 `json:"projects"`
+}
+
+type ProjectsDepsolveResponseV0 struct {
+	Projects []PackageSpecV0 `json:"projects"`
+}
+
+type ModuleNameV0 struct {
+	Name      string `json:"name"`
+	GroupType string `json:"group_type"`
+}
+
+type ModulesListResponseV0 struct {
+	Total   uint           `json:"total"`
+	Offset  uint           `json:"offset"`
+	Limit   uint           `json:"limit"`
+	Modules []ModuleNameV0 `json:"modules"`
+}
+
+type ModulesInfoResponseV0 struct {
+	Modules []ProjectV0 `json:"modules"`
+}
+
+type BlueprintDepsolvedV0 struct {
+	Blueprint    json.RawMessage `json:"blueprint"`
+	Dependencies []PackageSpecV0 `json:"dependencies"`
+}
+
+// BlueprintsDepsolveResponseV0 lists the resolved blueprints, the blueprints
+// whose packages cannot be resolved are reported in Errors
+type BlueprintsDepsolveResponseV0 struct {
+	Blueprints []BlueprintDepsolvedV0 `json:"blueprints"`
+	Errors     []ResponseError        `json:"errors"`
+}
+
+// SourceConfigV0 describes a repository used to resolve and install the
+// packages, System is true for the repositories of the host that cannot be
+// deleted
+type SourceConfigV0 struct {
+	Name     string `json:"name" toml:"name"`
+	Type     string `json:"type" toml:"type"`
+	URL      string `json:"url" toml:"url"`
+	CheckGPG bool   `json:"check_gpg" toml:"check_gpg"`
+	CheckSSL bool   `json:"check_ssl" toml:"check_ssl"`
+	System   bool   `json:"system" toml:"system"`
+}
+
+type SourceListV0 struct {
+	Sources []string `json:"sources"`
+}
+
+type SourceInfoResponseV0 struct {
+	Sources map[string]SourceConfigV0 `json:"sources"`
+	Errors  []ResponseError           `json:"errors"`
+}
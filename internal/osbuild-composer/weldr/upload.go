@@ -1,20 +1,195 @@
 package weldr
 
 import (
+	"encoding/json"
+	"fmt"
+
 	"github.com/google/uuid"
 
 	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/common"
 )
 
-type uploadResponse struct {
+// UploadResponse describes an upload of the compose's image to a cloud
+// provider. The type of Settings depends on ProviderName, e.g. it's
+// AWSUploadSettings for "aws".
+type UploadResponse struct {
 	UUID         uuid.UUID              `json:"uuid"`
 	Status       common.ImageBuildState `json:"status"`
 	ProviderName string                 `json:"provider_name"`
 	ImageName    string                 `json:"image_name"`
 	CreationTime float64                `json:"creation_time"`
-	Settings     uploadSettings         `json:"settings"`
+	Settings     UploadSettings         `json:"settings"`
+}
+
+// UploadRequest asks osbuild-composer to upload the image of the compose to a
+// cloud provider, the type of Settings depends on Provider
+type UploadRequest struct {
+	Provider  string         `json:"provider"`
+	ImageName string         `json:"image_name"`
+	Settings  UploadSettings `json:"settings"`
 }
 
-type uploadSettings interface {
+// UploadSettings are the provider specific settings of an upload, the secrets
+// are omitted by osbuild-composer in the responses
+type UploadSettings interface {
 	isUploadSettings()
 }
+
+type AWSUploadSettings struct {
+	Region          string `json:"region"`
+	AccessKeyID     string `json:"accessKeyID,omitempty"`
+	SecretAccessKey string `json:"secretAccessKey,omitempty"`
+	Bucket          string `json:"bucket"`
+	Key             string `json:"key"`
+}
+
+func (AWSUploadSettings) isUploadSettings() {}
+
+// AWSS3UploadSettings describe an upload to an S3 bucket, Endpoint selects an
+// S3-compatible service other than AWS (e.g. MinIO)
+type AWSS3UploadSettings struct {
+	Region              string `json:"region"`
+	AccessKeyID         string `json:"accessKeyID,omitempty"`
+	SecretAccessKey     string `json:"secretAccessKey,omitempty"`
+	Bucket              string `json:"bucket"`
+	Key                 string `json:"key"`
+	Endpoint            string `json:"endpoint,omitempty"`
+	CABundle            string `json:"ca_bundle,omitempty"`
+	SkipSSLVerification bool   `json:"skip_ssl_verification,omitempty"`
+}
+
+func (AWSS3UploadSettings) isUploadSettings() {}
+
+type AzureUploadSettings struct {
+	StorageAccount   string `json:"storageAccount,omitempty"`
+	StorageAccessKey string `json:"storageAccessKey,omitempty"`
+	Container        string `json:"container"`
+}
+
+func (AzureUploadSettings) isUploadSettings() {}
+
+type GCPUploadSettings struct {
+	Filename string `json:"filename"`
+	Region   string `json:"region"`
+	Bucket   string `json:"bucket"`
+	Object   string `json:"object"`
+
+	// base64 encoded GCP credentials JSON file
+	Credentials string `json:"credentials,omitempty"`
+}
+
+func (GCPUploadSettings) isUploadSettings() {}
+
+type VMwareUploadSettings struct {
+	Host       string `json:"host"`
+	Username   string `json:"username"`
+	Password   string `json:"password,omitempty"`
+	Datacenter string `json:"datacenter"`
+	Cluster    string `json:"cluster"`
+	Datastore  string `json:"datastore"`
+}
+
+func (VMwareUploadSettings) isUploadSettings() {}
+
+// GenericUploadSettings are the settings of a provider unknown to this
+// package, they're kept as they were received
+type GenericUploadSettings map[string]interface{}
+
+func (GenericUploadSettings) isUploadSettings() {}
+
+// decodeUploadSettings decodes the settings into the type matching the
+// provider, the settings of an unknown provider are decoded as
+// GenericUploadSettings
+func decodeUploadSettings(providerName string, data json.RawMessage) (UploadSettings, error) {
+	if len(data) == 0 {
+		data = json.RawMessage("null")
+	}
+
+	var err error
+	switch providerName {
+	case "aws":
+		var settings AWSUploadSettings
+		err = json.Unmarshal(data, &settings)
+		return settings, err
+	case "aws.s3":
+		var settings AWSS3UploadSettings
+		err = json.Unmarshal(data, &settings)
+		return settings, err
+	case "azure":
+		var settings AzureUploadSettings
+		err = json.Unmarshal(data, &settings)
+		return settings, err
+	case "gcp":
+		var settings GCPUploadSettings
+		err = json.Unmarshal(data, &settings)
+		return settings, err
+	case "vmware":
+		var settings VMwareUploadSettings
+		err = json.Unmarshal(data, &settings)
+		return settings, err
+	}
+
+	var settings GenericUploadSettings
+	err = json.Unmarshal(data, &settings)
+	return settings, err
+}
+
+// UnmarshalJSON decodes the settings into the type matching the provider
+func (u *UploadResponse) UnmarshalJSON(data []byte) error {
+	type rawUploadResponse struct {
+		UUID         uuid.UUID              `json:"uuid"`
+		Status       common.ImageBuildState `json:"status"`
+		ProviderName string                 `json:"provider_name"`
+		ImageName    string                 `json:"image_name"`
+		CreationTime float64                `json:"creation_time"`
+		Settings     json.RawMessage        `json:"settings"`
+	}
+	var raw rawUploadResponse
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	settings, err := decodeUploadSettings(raw.ProviderName, raw.Settings)
+	if err != nil {
+		return fmt.Errorf("cannot decode the %s upload settings: %v", raw.ProviderName, err)
+	}
+
+	*u = UploadResponse{
+		UUID:         raw.UUID,
+		Status:       raw.Status,
+		ProviderName: raw.ProviderName,
+		ImageName:    raw.ImageName,
+		CreationTime: raw.CreationTime,
+		Settings:     settings,
+	}
+
+	return nil
+}
+
+// UnmarshalJSON decodes the settings into the type matching the provider
+func (u *UploadRequest) UnmarshalJSON(data []byte) error {
+	type rawUploadRequest struct {
+		Provider  string          `json:"provider"`
+		ImageName string          `json:"image_name"`
+		Settings  json.RawMessage `json:"settings"`
+	}
+	var raw rawUploadRequest
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+
+	settings, err := decodeUploadSettings(raw.Provider, raw.Settings)
+	if err != nil {
+		return fmt.Errorf("cannot decode the %s upload settings: %v", raw.Provider, err)
+	}
+
+	*u = UploadRequest{
+		Provider:  raw.Provider,
+		ImageName: raw.ImageName,
+		Settings:  settings,
+	}
+
+	return nil
+}
@@ -0,0 +1,251 @@
+package weldr_image
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/client"
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/weldr"
+)
+
+// Backend is the API of osbuild-composer a Request is processed with. The
+// request pushes its definition (sources and blueprint), starts the composes,
+// polls their status, fetches their artifacts and cleans up through it.
+//
+// The weldr API is used by default, see NewWeldrBackend and NewCloudBackend.
+// The errors returned by a backend describe the failed call, failures of the
+// API itself are *APIError.
+type Backend interface {
+	// ImageTypes returns the image types of the distro and arch, empty
+	// values select the defaults of the osbuild-composer's host
+	ImageTypes(ctx context.Context, distro, arch string) ([]weldr.ComposeTypeV0, error)
+
+	// Sources returns the names of the sources known to the backend
+	Sources(ctx context.Context) ([]string, error)
+	// PushSource registers a json or toml source and returns its name
+	PushSource(ctx context.Context, source []byte) (string, error)
+	DeleteSource(ctx context.Context, name string) error
+	// PushBlueprint stores a json or toml blueprint and returns its name,
+	// an empty blueprint with a random name is pushed if it's empty
+	PushBlueprint(ctx context.Context, blueprint []byte) (string, error)
+	DeleteBlueprint(ctx context.Context, name string) error
+
+	// StartCompose starts a compose of a pushed blueprint
+	StartCompose(ctx context.Context, request weldr.ComposeRequestV0) (uuid.UUID, error)
+	// ComposeStatus returns the status of the compose in the form used by
+	// the weldr API
+	ComposeStatus(ctx context.Context, id uuid.UUID) (weldr.ComposeEntryV0, error)
+	CancelCompose(ctx context.Context, id uuid.UUID) error
+	DeleteCompose(ctx context.Context, id uuid.UUID) error
+
+	// ImageRange returns the image of the compose starting at the offset,
+	// see client.GetRange
+	ImageRange(ctx context.Context, id uuid.UUID, offset int64) (io.ReadCloser, client.ImageRange, error)
+	WriteManifest(ctx context.Context, w io.Writer, id uuid.UUID) error
+	WriteLog(ctx context.Context, w io.Writer, id uuid.UUID) error
+}
+
+// Depsolver is implemented by the backends able to resolve the packages of a
+// pushed blueprint without building it. The packages of a blueprint are
+// resolved before its composes are started only if the backend is a
+// Depsolver.
+type Depsolver interface {
+	// Depsolve returns the packages of the blueprint, or a *DepsolveError
+	// if they cannot be resolved
+	Depsolve(ctx context.Context, blueprintName string) ([]weldr.PackageSpecV0, error)
+}
+
+// weldrBackend talks to the weldr API of osbuild-composer, the one used by
+// composer-cli and cockpit-composer
+type weldrBackend struct {
+	client *http.Client
+}
+
+// NewWeldrBackend returns a backend using the weldr API through the client
+func NewWeldrBackend(c *http.Client) Backend {
+	return &weldrBackend{client: c}
+}
+
+func (b *weldrBackend) ImageTypes(ctx context.Context, distro, arch string) ([]weldr.ComposeTypeV0, error) {
+	types, response, err := client.GetComposesTypesV0(ctx, b.client, distro, arch)
+	if err := TranslateError(response, err); err != nil {
+		return nil, &APIError{
+			Message: "cannot retrieve compose types",
+			Cause:   err,
+		}
+	}
+
+	return types, nil
+}
+
+func (b *weldrBackend) Sources(ctx context.Context) ([]string, error) {
+	names, response, err := client.ListSourcesV0(ctx, b.client)
+	if err := TranslateError(response, err); err != nil {
+		return nil, &APIError{
+			Message: "cannot list the sources",
+			Cause:   err,
+		}
+	}
+
+	return names, nil
+}
+
+func (b *weldrBackend) PushSource(ctx context.Context, source []byte) (string, error) {
+	return PushSource(ctx, b.client, source)
+}
+
+func (b *weldrBackend) DeleteSource(ctx context.Context, name string) error {
+	return DeleteSource(ctx, b.client, name)
+}
+
+func (b *weldrBackend) PushBlueprint(ctx context.Context, blueprint []byte) (string, error) {
+	return PushBlueprint(ctx, b.client, blueprint)
+}
+
+func (b *weldrBackend) DeleteBlueprint(ctx context.Context, name string) error {
+	return DeleteBlueprint(ctx, b.client, name)
+}
+
+func (b *weldrBackend) StartCompose(ctx context.Context, request weldr.ComposeRequestV0) (uuid.UUID, error) {
+	compose, response, err := client.PostComposeRequestV0(ctx, b.client, request)
+	if err := TranslateError(response, err); err != nil {
+		return uuid.Nil, &APIError{
+			Message: "cannot post a new compose",
+			Cause:   err,
+		}
+	}
+
+	return compose.BuildID, nil
+}
+
+func (b *weldrBackend) ComposeStatus(ctx context.Context, id uuid.UUID) (weldr.ComposeEntryV0, error) {
+	composes, response, err := client.GetComposeStatusV0(ctx, b.client, id.String(), "", "", "")
+	if err := TranslateError(response, err); err != nil {
+		return weldr.ComposeEntryV0{}, &APIError{
+			Message: "cannot retrieve a compose status",
+			Cause:   err,
+		}
+	}
+
+	// an unknown id is not an error of the API, the list is just empty
+	if len(composes) == 0 {
+		return weldr.ComposeEntryV0{}, fmt.Errorf("compose %s not found", id)
+	}
+
+	return composes[0], nil
+}
+
+func (b *weldrBackend) CancelCompose(ctx context.Context, id uuid.UUID) error {
+	_, response, err := client.CancelComposeV0(ctx, b.client, id.String())
+	if err := TranslateError(response, err); err != nil {
+		return &APIError{
+			Message: "cannot cancel the compose",
+			Cause:   err,
+		}
+	}
+
+	return nil
+}
+
+func (b *weldrBackend) DeleteCompose(ctx context.Context, id uuid.UUID) error {
+	_, response, err := client.DeleteComposeV0(ctx, b.client, id.String())
+	if err := TranslateError(response, err); err != nil {
+		return &APIError{
+			Message: "cannot delete the compose",
+			Cause:   err,
+		}
+	}
+
+	return nil
+}
+
+func (b *weldrBackend) ImageRange(ctx context.Context, id uuid.UUID, offset int64) (io.ReadCloser, client.ImageRange, error) {
+	body, imageRange, response, err := client.GetComposeImageRangeV0(ctx, b.client, id.String(), offset)
+	if err := TranslateError(response, err); err != nil {
+		return nil, client.ImageRange{}, &APIError{
+			Message: "cannot download the image",
+			Cause:   err,
+		}
+	}
+
+	return body, imageRange, nil
+}
+
+// WriteManifest extracts the manifest from the metadata tar of the compose
+func (b *weldrBackend) WriteManifest(ctx context.Context, w io.Writer, id uuid.UUID) error {
+	var tarManifestBuffer bytes.Buffer
+	response, err := client.WriteComposeMetadataV0(ctx, b.client, &tarManifestBuffer, id.String())
+
+	if err := TranslateError(response, err); err != nil {
+		return &APIError{
+			Message: "cannot retrieve the manifest",
+			Cause:   err,
+		}
+	}
+
+	tarReader := tar.NewReader(&tarManifestBuffer)
+
+	manifestHeader, err := tarReader.Next()
+	if err != nil {
+		return fmt.Errorf("cannot decode the metadata tar: %v", err)
+	}
+
+	_, err = io.CopyN(w, tarReader, manifestHeader.Size)
+	if err != nil {
+		return fmt.Errorf("cannot copy the manifest: %v", err)
+	}
+
+	return nil
+}
+
+func (b *weldrBackend) WriteLog(ctx context.Context, w io.Writer, id uuid.UUID) error {
+	response, err := client.WriteComposeLogV0(ctx, b.client, w, id.String())
+
+	if err := TranslateError(response, err); err != nil {
+		return &APIError{
+			Message: "cannot retrieve the log",
+			Cause:   err,
+		}
+	}
+
+	return nil
+}
+
+func (b *weldrBackend) Depsolve(ctx context.Context, blueprintName string) ([]weldr.PackageSpecV0, error) {
+	response, apiResponse, err := client.DepsolveBlueprintsV0(ctx, b.client, blueprintName)
+	if err := TranslateError(apiResponse, err); err != nil {
+		return nil, &APIError{
+			Message: "cannot resolve the packages of the blueprint",
+			Cause:   err,
+		}
+	}
+
+	if len(response.Errors) > 0 {
+		var messages []string
+		for _, e := range response.Errors {
+			messages = append(messages, e.Msg)
+		}
+		message := strings.Join(messages, "\n")
+
+		missing := missingPackages(message)
+		return nil, &DepsolveError{
+			BlueprintName: blueprintName,
+			Message:       message,
+			Missing:       missing,
+			Suggestions:   b.suggestPackages(ctx, missing),
+		}
+	}
+
+	if len(response.Blueprints) == 0 {
+		return nil, fmt.Errorf("osbuild-composer returned no resolved blueprint %s", blueprintName)
+	}
+
+	return response.Blueprints[0].Dependencies, nil
+}
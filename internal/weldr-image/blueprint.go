@@ -0,0 +1,731 @@
+package weldr_image
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Blueprint is a blueprint in the format accepted by osbuild-composer, the
+// json and toml keys are the same. Optional customizations are pointers or
+// slices, so a blueprint converted from toml to json (or the other way)
+// contains only the keys of the original.
+type Blueprint struct {
+	Name           string          `json:"name" toml:"name"`
+	Description    string          `json:"description,omitempty" toml:"description,omitempty"`
+	Version        string          `json:"version,omitempty" toml:"version,omitempty"`
+	Distro         string          `json:"distro,omitempty" toml:"distro,omitempty"`
+	Packages       []Package       `json:"packages,omitempty" toml:"packages,omitempty"`
+	Modules        []Package       `json:"modules,omitempty" toml:"modules,omitempty"`
+	Groups         []Group         `json:"groups,omitempty" toml:"groups,omitempty"`
+	Containers     []Container     `json:"containers,omitempty" toml:"containers,omitempty"`
+	Customizations *Customizations `json:"customizations,omitempty" toml:"customizations,omitempty"`
+	// Include are the blueprint files this one is merged on top of, they're
+	// resolved by BlueprintLoader and unknown to osbuild-composer
+	Include []string `json:"include,omitempty" toml:"include,omitempty"`
+
+	// unknown are the keys of a blueprint decoded for a build that are
+	// missing in the model, document is the whole decoded blueprint keeping
+	// them. A merged blueprint has no document, unless the other blueprint
+	// was empty.
+	unknown  []string
+	document map[string]interface{}
+}
+
+// Package is a package or a module, the version is a glob (e.g. "2.*"), an
+// empty one means any version
+type Package struct {
+	Name    string `json:"name" toml:"name"`
+	Version string `json:"version,omitempty" toml:"version,omitempty"`
+}
+
+type Group struct {
+	Name string `json:"name" toml:"name"`
+}
+
+// Container is a container image embedded into the image
+type Container struct {
+	Source    string `json:"source" toml:"source"`
+	Name      string `json:"name,omitempty" toml:"name,omitempty"`
+	TLSVerify *bool  `json:"tls-verify,omitempty" toml:"tls-verify,omitempty"`
+}
+
+type Customizations struct {
+	Hostname           *string                   `json:"hostname,omitempty" toml:"hostname,omitempty"`
+	Kernel             *KernelCustomization      `json:"kernel,omitempty" toml:"kernel,omitempty"`
+	SSHKey             []SSHKeyCustomization     `json:"sshkey,omitempty" toml:"sshkey,omitempty"`
+	User               []UserCustomization       `json:"user,omitempty" toml:"user,omitempty"`
+	Group              []GroupCustomization      `json:"group,omitempty" toml:"group,omitempty"`
+	Timezone           *TimezoneCustomization    `json:"timezone,omitempty" toml:"timezone,omitempty"`
+	Locale             *LocaleCustomization      `json:"locale,omitempty" toml:"locale,omitempty"`
+	Firewall           *FirewallCustomization    `json:"firewall,omitempty" toml:"firewall,omitempty"`
+	Services           *ServicesCustomization    `json:"services,omitempty" toml:"services,omitempty"`
+	Filesystem         []FilesystemCustomization `json:"filesystem,omitempty" toml:"filesystem,omitempty"`
+	InstallationDevice string                    `json:"installation_device,omitempty" toml:"installation_device,omitempty"`
+	FIPS               *bool                     `json:"fips,omitempty" toml:"fips,omitempty"`
+	Directories        []DirectoryCustomization  `json:"directories,omitempty" toml:"directories,omitempty"`
+	Files              []FileCustomization       `json:"files,omitempty" toml:"files,omitempty"`
+	Repositories       []RepositoryCustomization `json:"repositories,omitempty" toml:"repositories,omitempty"`
+}
+
+type KernelCustomization struct {
+	Name   string `json:"name,omitempty" toml:"name,omitempty"`
+	Append string `json:"append,omitempty" toml:"append,omitempty"`
+}
+
+type SSHKeyCustomization struct {
+	User string `json:"user" toml:"user"`
+	Key  string `json:"key" toml:"key"`
+}
+
+type UserCustomization struct {
+	Name        string   `json:"name" toml:"name"`
+	Description *string  `json:"description,omitempty" toml:"description,omitempty"`
+	Password    *string  `json:"password,omitempty" toml:"password,omitempty"`
+	Key         *string  `json:"key,omitempty" toml:"key,omitempty"`
+	Home        *string  `json:"home,omitempty" toml:"home,omitempty"`
+	Shell       *string  `json:"shell,omitempty" toml:"shell,omitempty"`
+	Groups      []string `json:"groups,omitempty" toml:"groups,omitempty"`
+	UID         *int     `json:"uid,omitempty" toml:"uid,omitempty"`
+	GID         *int     `json:"gid,omitempty" toml:"gid,omitempty"`
+}
+
+type GroupCustomization struct {
+	Name string `json:"name" toml:"name"`
+	GID  *int   `json:"gid,omitempty" toml:"gid,omitempty"`
+}
+
+type TimezoneCustomization struct {
+	Timezone   *string  `json:"timezone,omitempty" toml:"timezone,omitempty"`
+	NTPServers []string `json:"ntpservers,omitempty" toml:"ntpservers,omitempty"`
+}
+
+type LocaleCustomization struct {
+	Languages []string `json:"languages,omitempty" toml:"languages,omitempty"`
+	Keyboard  *string  `json:"keyboard,omitempty" toml:"keyboard,omitempty"`
+}
+
+// FirewallCustomization opens the ports (e.g. "22:tcp") and enables or
+// disables the firewalld services
+type FirewallCustomization struct {
+	Ports    []string                       `json:"ports,omitempty" toml:"ports,omitempty"`
+	Services *FirewallServicesCustomization `json:"services,omitempty" toml:"services,omitempty"`
+	Zones    []FirewallZoneCustomization    `json:"zones,omitempty" toml:"zones,omitempty"`
+}
+
+type FirewallServicesCustomization struct {
+	Enabled  []string `json:"enabled,omitempty" toml:"enabled,omitempty"`
+	Disabled []string `json:"disabled,omitempty" toml:"disabled,omitempty"`
+}
+
+type FirewallZoneCustomization struct {
+	Name    *string  `json:"name,omitempty" toml:"name,omitempty"`
+	Sources []string `json:"sources,omitempty" toml:"sources,omitempty"`
+}
+
+// ServicesCustomization enables, disables or masks systemd units
+type ServicesCustomization struct {
+	Enabled  []string `json:"enabled,omitempty" toml:"enabled,omitempty"`
+	Disabled []string `json:"disabled,omitempty" toml:"disabled,omitempty"`
+	Masked   []string `json:"masked,omitempty" toml:"masked,omitempty"`
+}
+
+// FilesystemCustomization is a mountpoint with its minimal size, either in
+// bytes or as a string with a unit (e.g. "2 GiB")
+type FilesystemCustomization struct {
+	Mountpoint string      `json:"mountpoint" toml:"mountpoint"`
+	MinSize    interface{} `json:"minsize,omitempty" toml:"minsize,omitempty"`
+}
+
+// DirectoryCustomization is a directory created in the image, the user and
+// group are either names or ids
+type DirectoryCustomization struct {
+	Path          string      `json:"path" toml:"path"`
+	User          interface{} `json:"user,omitempty" toml:"user,omitempty"`
+	Group         interface{} `json:"group,omitempty" toml:"group,omitempty"`
+	Mode          string      `json:"mode,omitempty" toml:"mode,omitempty"`
+	EnsureParents bool        `json:"ensure_parents,omitempty" toml:"ensure_parents,omitempty"`
+}
+
+// FileCustomization is a file created in the image, the user and group are
+// either names or ids
+type FileCustomization struct {
+	Path  string      `json:"path" toml:"path"`
+	User  interface{} `json:"user,omitempty" toml:"user,omitempty"`
+	Group interface{} `json:"group,omitempty" toml:"group,omitempty"`
+	Mode  string      `json:"mode,omitempty" toml:"mode,omitempty"`
+	Data  string      `json:"data,omitempty" toml:"data,omitempty"`
+}
+
+// RepositoryCustomization is a repository configured in the image, it's not
+// used to build it
+type RepositoryCustomization struct {
+	ID             string   `json:"id" toml:"id"`
+	BaseURLs       []string `json:"baseurls,omitempty" toml:"baseurls,omitempty"`
+	GPGKeys        []string `json:"gpgkeys,omitempty" toml:"gpgkeys,omitempty"`
+	Metalink       string   `json:"metalink,omitempty" toml:"metalink,omitempty"`
+	Mirrorlist     string   `json:"mirrorlist,omitempty" toml:"mirrorlist,omitempty"`
+	Name           string   `json:"name,omitempty" toml:"name,omitempty"`
+	Priority       *int     `json:"priority,omitempty" toml:"priority,omitempty"`
+	Enabled        *bool    `json:"enabled,omitempty" toml:"enabled,omitempty"`
+	GPGCheck       *bool    `json:"gpgcheck,omitempty" toml:"gpgcheck,omitempty"`
+	RepoGPGCheck   *bool    `json:"repo_gpgcheck,omitempty" toml:"repo_gpgcheck,omitempty"`
+	SSLVerify      *bool    `json:"sslverify,omitempty" toml:"sslverify,omitempty"`
+	Filename       string   `json:"filename,omitempty" toml:"filename,omitempty"`
+	ModuleHotfixes *bool    `json:"module_hotfixes,omitempty" toml:"module_hotfixes,omitempty"`
+}
+
+// Normalize puts the blueprint into its canonical form: the packages, modules
+// and groups are sorted by name. The order of the customizations is kept, it
+// can matter (e.g. the users are created in order). The slices are replaced,
+// not sorted in place, so a shallow copy can be normalized.
+func (b *Blueprint) Normalize() {
+	b.Packages = sortedPackages(b.Packages)
+	b.Modules = sortedPackages(b.Modules)
+
+	if b.Groups != nil {
+		groups := append([]Group(nil), b.Groups...)
+		sort.SliceStable(groups, func(i, j int) bool {
+			return groups[i].Name < groups[j].Name
+		})
+		b.Groups = groups
+	}
+}
+
+func sortedPackages(packages []Package) []Package {
+	if packages == nil {
+		return nil
+	}
+
+	sorted := append([]Package(nil), packages...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Name != sorted[j].Name {
+			return sorted[i].Name < sorted[j].Name
+		}
+		return sorted[i].Version < sorted[j].Version
+	})
+	return sorted
+}
+
+// EncodeJSON encodes the blueprint as indented json, the keys are in the
+// order of the model. A blueprint with keys unknown to the model is encoded
+// as it was decoded instead, the model would drop them, so it's neither
+// normalized nor reordered.
+func (b *Blueprint) EncodeJSON() ([]byte, error) {
+	var v interface{} = b
+	if len(b.unknown) > 0 {
+		if b.document == nil {
+			return nil, b.unknownKeysError()
+		}
+		v = b.document
+	}
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// EncodeTOML encodes the blueprint as toml, the keys are in the order of the
+// model with the tables after the plain keys. A blueprint with keys unknown
+// to the model cannot be encoded as toml.
+func (b *Blueprint) EncodeTOML() ([]byte, error) {
+	if len(b.unknown) > 0 {
+		return nil, b.unknownKeysError()
+	}
+
+	var buf bytes.Buffer
+	encoder := toml.NewEncoder(&buf)
+	encoder.Indent = ""
+	if err := encoder.Encode(b); err != nil {
+		return nil, err
+	}
+
+	// the encoder doesn't separate a table from the keys of its parent,
+	// its strings never span several lines so every line starting with a
+	// bracket is a table header
+	lines := strings.Split(buf.String(), "\n")
+	var separated []string
+	for i, line := range lines {
+		if i > 0 && strings.HasPrefix(line, "[") && lines[i-1] != "" {
+			separated = append(separated, "")
+		}
+		separated = append(separated, line)
+	}
+	return []byte(strings.Join(separated, "\n")), nil
+}
+
+// unknownKeysError is returned when a blueprint with keys unknown to the
+// model would lose them, e.g. when it's merged with an overlay
+func (b *Blueprint) unknownKeysError() error {
+	return fmt.Errorf("the blueprint has keys unknown to osbuild-image (%s), it can only be sent as it is, without includes, overrides or a conversion", strings.Join(b.unknown, ", "))
+}
+
+// integerFromJSON converts a value that is either a string or an integer
+// (e.g. the user of a file or the size of a filesystem) decoded from json,
+// json numbers are decoded as floats while the values are integers
+func integerFromJSON(value interface{}) interface{} {
+	if f, ok := value.(float64); ok && f == float64(int64(f)) {
+		return int64(f)
+	}
+	return value
+}
+
+// BlueprintProblem is one problem of an invalid blueprint
+type BlueprintProblem struct {
+	// Line and Column locate the problem in the blueprint (starting at 1),
+	// they're 0 if it cannot be located
+	Line   int
+	Column int
+	// Key is the path of the key the problem is about, e.g.
+	// customizations.user[0].uid, it's empty for syntax errors
+	Key     string
+	Message string
+}
+
+// String formats the problem as LINE:COLUMN: KEY: MESSAGE, the parts that
+// are not known are left out
+func (p BlueprintProblem) String() string {
+	var b strings.Builder
+	if p.Line > 0 {
+		fmt.Fprintf(&b, "%d:%d: ", p.Line, p.Column)
+	}
+	if p.Key != "" {
+		fmt.Fprintf(&b, "%s: ", p.Key)
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+// BlueprintError is returned for a blueprint that is not valid json or toml,
+// or that doesn't match the Blueprint model
+type BlueprintError struct {
+	// File is the blueprint file, it's empty if the blueprint wasn't
+	// loaded from a file
+	File string
+	// IsTOML is true if the blueprint was decoded as toml
+	IsTOML   bool
+	Problems []BlueprintProblem
+}
+
+func (e *BlueprintError) Error() string {
+	format := "json"
+	if e.IsTOML {
+		format = "toml"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid %s blueprint", format)
+	if e.File != "" {
+		fmt.Fprintf(&b, " %s", e.File)
+	}
+	b.WriteString(":")
+	for _, p := range e.Problems {
+		fmt.Fprintf(&b, "\n  %s", p)
+	}
+	return b.String()
+}
+
+// isJSONBlueprint returns true if the blueprint is a json object, a toml
+// document cannot start with a brace
+func isJSONBlueprint(rawBlueprint []byte) bool {
+	trimmed := bytes.TrimLeft(bytes.TrimPrefix(rawBlueprint, []byte("\xef\xbb\xbf")), " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
+// DecodeBlueprint strictly decodes a json or toml blueprint. Unknown keys,
+// values of a wrong type and missing required keys are reported, together
+// with syntax errors, as a *BlueprintError locating each problem.
+func DecodeBlueprint(rawBlueprint []byte) (*Blueprint, error) {
+	blueprint, _, err := decodeBlueprint(rawBlueprint, decodeOptions{})
+	return blueprint, err
+}
+
+// decodeBuildBlueprint decodes a blueprint to be built. It's DecodeBlueprint
+// allowing unknown keys, they can be customizations osbuild-composer knows
+// but the model doesn't (yet).
+func decodeBuildBlueprint(rawBlueprint []byte) (*Blueprint, error) {
+	blueprint, _, err := decodeBlueprint(rawBlueprint, decodeOptions{lenient: true})
+	return blueprint, err
+}
+
+// decodeOptions relax the checks of decodeBlueprint
+type decodeOptions struct {
+	// partial skips the required keys of the whole blueprint (e.g. of an
+	// included one), only the ones of its tables are required
+	partial bool
+	// lenient allows the keys unknown to the model
+	lenient bool
+}
+
+// decodeBlueprint is DecodeBlueprint with options, it also returns whether
+// the blueprint is toml
+func decodeBlueprint(rawBlueprint []byte, options decodeOptions) (*Blueprint, bool, error) {
+	if isJSONBlueprint(rawBlueprint) {
+		blueprint, err := decodeJSONBlueprint(rawBlueprint, options)
+		return blueprint, false, err
+	}
+
+	blueprint, err := decodeTOMLBlueprint(rawBlueprint, options)
+	return blueprint, true, err
+}
+
+func decodeJSONBlueprint(rawBlueprint []byte, options decodeOptions) (*Blueprint, error) {
+	// the syntax is checked first, only the errors of json.Unmarshal have
+	// an offset
+	var raw json.RawMessage
+	if err := json.Unmarshal(rawBlueprint, &raw); err != nil {
+		problem := BlueprintProblem{Message: err.Error()}
+		if syntaxError, ok := err.(*json.SyntaxError); ok {
+			problem.Line, problem.Column = lineColumn(rawBlueprint, syntaxError.Offset-1)
+		}
+		return nil, &BlueprintError{Problems: []BlueprintProblem{problem}}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(rawBlueprint))
+	decoder.UseNumber()
+	var tree interface{}
+	if err := decoder.Decode(&tree); err != nil {
+		return nil, &BlueprintError{Problems: []BlueprintProblem{{Message: err.Error()}}}
+	}
+
+	checker := blueprintChecker{decodeOptions: options}
+	checker.check("", tree, reflect.TypeOf(Blueprint{}))
+	if len(checker.problems) > 0 {
+		positions := jsonKeyPositions(rawBlueprint)
+		return nil, &BlueprintError{Problems: locateProblems(checker.problems, positions)}
+	}
+
+	var blueprint Blueprint
+	if err := json.Unmarshal(rawBlueprint, &blueprint); err != nil {
+		return nil, &BlueprintError{Problems: []BlueprintProblem{{Message: err.Error()}}}
+	}
+
+	if c := blueprint.Customizations; c != nil {
+		for i := range c.Directories {
+			c.Directories[i].User = integerFromJSON(c.Directories[i].User)
+			c.Directories[i].Group = integerFromJSON(c.Directories[i].Group)
+		}
+		for i := range c.Files {
+			c.Files[i].User = integerFromJSON(c.Files[i].User)
+			c.Files[i].Group = integerFromJSON(c.Files[i].Group)
+		}
+		for i := range c.Filesystem {
+			c.Filesystem[i].MinSize = integerFromJSON(c.Filesystem[i].MinSize)
+		}
+	}
+
+	if len(checker.unknown) > 0 {
+		blueprint.unknown = checker.unknown
+		blueprint.document = tree.(map[string]interface{})
+	}
+
+	return &blueprint, nil
+}
+
+// tomlParseError matches the errors of the toml parser, e.g.
+// Near line 3 (last key parsed 'name'): expected value but found "=" instead
+var tomlParseError = regexp.MustCompile(`^Near line (\d+) \(last key parsed '(.*)'\): (?s:(.*))$`)
+
+func decodeTOMLBlueprint(rawBlueprint []byte, options decodeOptions) (*Blueprint, error) {
+	var tree map[string]interface{}
+	if _, err := toml.Decode(string(rawBlueprint), &tree); err != nil {
+		problem := BlueprintProblem{Message: err.Error()}
+		if match := tomlParseError.FindStringSubmatch(err.Error()); match != nil {
+			problem.Line, _ = strconv.Atoi(match[1])
+			problem.Column = 1
+			problem.Message = match[3]
+		}
+		return nil, &BlueprintError{IsTOML: true, Problems: []BlueprintProblem{problem}}
+	}
+
+	checker := blueprintChecker{isTOML: true, decodeOptions: options}
+	checker.check("", tree, reflect.TypeOf(Blueprint{}))
+	positions := tomlKeyPositions(string(rawBlueprint))
+	if len(checker.problems) > 0 {
+		return nil, &BlueprintError{IsTOML: true, Problems: locateProblems(checker.problems, positions)}
+	}
+
+	var blueprint Blueprint
+	metadata, err := toml.Decode(string(rawBlueprint), &blueprint)
+	if err != nil {
+		return nil, &BlueprintError{IsTOML: true, Problems: []BlueprintProblem{{Message: err.Error()}}}
+	}
+
+	// the checker and the toml decoder should agree on the keys, this
+	// catches the ones only the decoder doesn't know
+	if !options.lenient {
+		for _, key := range metadata.Undecoded() {
+			checker.problems = append(checker.problems, BlueprintProblem{
+				Key:     key.String(),
+				Message: "unknown key",
+			})
+		}
+	}
+	if len(checker.problems) > 0 {
+		return nil, &BlueprintError{IsTOML: true, Problems: locateProblems(checker.problems, positions)}
+	}
+
+	if len(checker.unknown) > 0 {
+		blueprint.unknown = checker.unknown
+		blueprint.document = tree
+	}
+
+	return &blueprint, nil
+}
+
+// blueprintChecker compares a decoded json or toml document with the
+// Blueprint model and collects its problems
+type blueprintChecker struct {
+	isTOML bool
+	decodeOptions
+	problems []BlueprintProblem
+	// unknown are the unknown keys allowed by the lenient option
+	unknown []string
+}
+
+func (c *blueprintChecker) add(key, format string, args ...interface{}) {
+	c.problems = append(c.problems, BlueprintProblem{
+		Key:     key,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// tableName is what a json object or a toml table is called in the
+// problems
+func (c *blueprintChecker) tableName() string {
+	if c.isTOML {
+		return "a table"
+	}
+	return "an object"
+}
+
+// describe returns the kind of a decoded value, e.g. "a string"
+func (c *blueprintChecker) describe(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case int64:
+		return "an integer"
+	case float64:
+		return "a float"
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return "an integer"
+		}
+		return "a number"
+	case time.Time:
+		return "a datetime"
+	case map[string]interface{}:
+		return c.tableName()
+	}
+
+	if reflect.ValueOf(value).Kind() == reflect.Slice {
+		return "an array"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// expected returns the kind of value the model expects, e.g. "a string"
+func (c *blueprintChecker) expected(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int64:
+		return "an integer"
+	case reflect.Uint, reflect.Uint64:
+		return "a non-negative integer"
+	case reflect.Slice:
+		return "an array"
+	case reflect.Struct:
+		return c.tableName()
+	case reflect.Interface:
+		return "a string or an integer"
+	}
+	return t.String()
+}
+
+// check checks the value of the key against the type of the model, key is
+// empty for the whole blueprint
+func (c *blueprintChecker) check(key string, value interface{}, t reflect.Type) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if value == nil {
+		c.add(key, "expected %s, found null", c.expected(t))
+		return
+	}
+
+	mismatch := func() {
+		c.add(key, "expected %s, found %s", c.expected(t), c.describe(value))
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			mismatch()
+		}
+
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			mismatch()
+		}
+
+	case reflect.Interface:
+		// the values that are either names or ids, or either bytes or
+		// sizes with a unit
+		switch v := value.(type) {
+		case string, int64:
+		case json.Number:
+			if _, err := v.Int64(); err != nil {
+				mismatch()
+			}
+		default:
+			mismatch()
+		}
+
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		var n int64
+		switch v := value.(type) {
+		case int64:
+			n = v
+		case json.Number:
+			var err error
+			n, err = v.Int64()
+			if err != nil {
+				mismatch()
+				return
+			}
+		default:
+			mismatch()
+			return
+		}
+		if n < 0 && (t.Kind() == reflect.Uint || t.Kind() == reflect.Uint64) {
+			c.add(key, "expected %s, found %d", c.expected(t), n)
+		}
+
+	case reflect.Slice:
+		v := reflect.ValueOf(value)
+		if v.Kind() != reflect.Slice {
+			mismatch()
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			c.check(fmt.Sprintf("%s[%d]", key, i), v.Index(i).Interface(), t.Elem())
+		}
+
+	case reflect.Struct:
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			mismatch()
+			return
+		}
+		c.checkTable(key, table, t)
+
+	default:
+		panic("the blueprint model has an unsupported type: " + t.String())
+	}
+}
+
+// checkTable checks the keys of a json object or toml table against the
+// fields of a struct of the model. A field without omitempty is required.
+func (c *blueprintChecker) checkTable(key string, table map[string]interface{}, t reflect.Type) {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		fields[tag[0]] = field
+
+		if len(tag) == 1 && !(c.partial && key == "") {
+			value, ok := table[tag[0]]
+			if !ok {
+				c.add(joinKey(key, tag[0]), "missing required key")
+			} else if value == "" {
+				c.add(joinKey(key, tag[0]), "cannot be empty")
+			}
+		}
+	}
+
+	var names []string
+	for name := range table {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		field, ok := fields[name]
+		if !ok {
+			if c.lenient {
+				c.unknown = append(c.unknown, joinKey(key, name))
+			} else {
+				c.add(joinKey(key, name), "unknown key")
+			}
+			continue
+		}
+		c.check(joinKey(key, name), table[name], field.Type)
+	}
+}
+
+// joinKey returns the path of a key in a table, the table is empty for the
+// whole blueprint
+func joinKey(table, key string) string {
+	if table == "" {
+		return key
+	}
+	return table + "." + key
+}
+
+// locateProblems fills in the positions of the problems and sorts them by
+// position. Problems of keys that are not in the document (missing keys,
+// elements of inline arrays) are located at their closest parent.
+func locateProblems(problems []BlueprintProblem, positions map[string]position) []BlueprintProblem {
+	for i := range problems {
+		key := problems[i].Key
+		for key != "" {
+			if p, ok := positions[key]; ok {
+				problems[i].Line, problems[i].Column = p.line, p.column
+				break
+			}
+			key = parentKey(key)
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Line != problems[j].Line {
+			return problems[i].Line < problems[j].Line
+		}
+		return problems[i].Column < problems[j].Column
+	})
+	return problems
+}
+
+// parentKey returns the path of the table or array containing the key, e.g.
+// customizations.user for customizations.user[0]
+func parentKey(key string) string {
+	if strings.HasSuffix(key, "]") {
+		return key[:strings.LastIndex(key, "[")]
+	}
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
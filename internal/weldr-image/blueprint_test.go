@@ -0,0 +1,282 @@
+package weldr_image_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/client"
+	"github.com/ondrejbudai/osbuild-image/internal/weldr-image"
+	"github.com/ondrejbudai/osbuild-image/internal/weldrtest"
+)
+
+// testOpenSCAPBlueprint has a customization unknown to the model
+const testOpenSCAPBlueprint = `
+name = "test"
+
+[[packages]]
+name = "tmux"
+
+[[customizations.filesystem]]
+mountpoint = "/var"
+minsize = "2 GiB"
+
+[customizations.openscap]
+datastream = "/usr/share/xml/scap/ssg/content/ssg-fedora-ds.xml"
+profile_id = "xccdf_org.ssgproject.content_profile_standard"
+`
+
+func TestDecodeBlueprintSizes(t *testing.T) {
+	tests := []struct {
+		name      string
+		blueprint string
+		expected  interface{}
+	}{
+		{
+			name:      "toml bytes",
+			blueprint: "name = \"test\"\n[[customizations.filesystem]]\nmountpoint = \"/var\"\nminsize = 2147483648\n",
+			expected:  int64(2147483648),
+		},
+		{
+			name:      "toml unit",
+			blueprint: "name = \"test\"\n[[customizations.filesystem]]\nmountpoint = \"/var\"\nminsize = \"2 GiB\"\n",
+			expected:  "2 GiB",
+		},
+		{
+			name:      "json bytes",
+			blueprint: `{"name": "test", "customizations": {"filesystem": [{"mountpoint": "/var", "minsize": 2147483648}]}}`,
+			expected:  int64(2147483648),
+		},
+		{
+			name:      "json unit",
+			blueprint: `{"name": "test", "customizations": {"filesystem": [{"mountpoint": "/var", "minsize": "2 GiB"}]}}`,
+			expected:  "2 GiB",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			blueprint, err := weldr_image.DecodeBlueprint([]byte(test.blueprint))
+			if err != nil {
+				t.Fatalf("cannot decode the blueprint: %v", err)
+			}
+
+			minSize := blueprint.Customizations.Filesystem[0].MinSize
+			if !reflect.DeepEqual(minSize, test.expected) {
+				t.Errorf("unexpected minsize %#v, expected %#v", minSize, test.expected)
+			}
+		})
+	}
+}
+
+func TestDecodeBlueprintProblems(t *testing.T) {
+	tests := []struct {
+		name      string
+		blueprint string
+		expected  []weldr_image.BlueprintProblem
+	}{
+		{
+			name:      "unknown customization",
+			blueprint: testOpenSCAPBlueprint,
+			expected: []weldr_image.BlueprintProblem{
+				{Line: 11, Column: 1, Key: "customizations.openscap", Message: "unknown key"},
+			},
+		},
+		{
+			name:      "wrong size",
+			blueprint: "name = \"test\"\n[[customizations.filesystem]]\nmountpoint = \"/var\"\nminsize = true\n",
+			expected: []weldr_image.BlueprintProblem{
+				{Line: 4, Column: 1, Key: "customizations.filesystem[0].minsize", Message: "expected a string or an integer, found a boolean"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := weldr_image.DecodeBlueprint([]byte(test.blueprint))
+			blueprintError, ok := err.(*weldr_image.BlueprintError)
+			if !ok {
+				t.Fatalf("expected a *BlueprintError, got %v", err)
+			}
+			if !reflect.DeepEqual(blueprintError.Problems, test.expected) {
+				t.Errorf("unexpected problems %+v, expected %+v", blueprintError.Problems, test.expected)
+			}
+		})
+	}
+}
+
+func TestProcessUnknownKeys(t *testing.T) {
+	server := weldrtest.NewServer()
+	defer server.Close()
+
+	request, remove := newTestRequest(t, server)
+	defer remove()
+	request.Blueprint = []byte(testOpenSCAPBlueprint)
+
+	if _, err := request.ProcessContext(context.Background()); err != nil {
+		t.Fatalf("the request failed: %v", err)
+	}
+
+	checkCleanedUp(t, server)
+}
+
+// checkOpenSCAP checks that the json blueprint still has the openscap
+// customization with the given profile and the filesystem size with a unit
+func checkOpenSCAP(t *testing.T, blueprint []byte, profile string) {
+	t.Helper()
+
+	var decoded struct {
+		Customizations struct {
+			OpenSCAP struct {
+				ProfileID string `json:"profile_id"`
+			} `json:"openscap"`
+			Filesystem []struct {
+				MinSize interface{} `json:"minsize"`
+			} `json:"filesystem"`
+		} `json:"customizations"`
+	}
+	if err := json.Unmarshal(blueprint, &decoded); err != nil {
+		t.Fatalf("cannot decode the blueprint: %v", err)
+	}
+
+	c := decoded.Customizations
+	if c.OpenSCAP.ProfileID != profile {
+		t.Errorf("unexpected openscap profile %q in %s", c.OpenSCAP.ProfileID, blueprint)
+	}
+	if len(c.Filesystem) != 1 || c.Filesystem[0].MinSize != "2 GiB" {
+		t.Errorf("unexpected filesystems %+v in %s", c.Filesystem, blueprint)
+	}
+}
+
+func TestPushBlueprintUnknownKeys(t *testing.T) {
+	server := weldrtest.NewServer()
+	defer server.Close()
+
+	ctx := context.Background()
+	name, err := weldr_image.PushBlueprint(ctx, server.Client(), []byte(testOpenSCAPBlueprint))
+	if err != nil {
+		t.Fatalf("cannot push the blueprint: %v", err)
+	}
+
+	info, response, err := client.GetBlueprintsInfoV0(ctx, server.Client(), name)
+	if err != nil || response != nil || len(info.Blueprints) != 1 {
+		t.Fatalf("cannot get the blueprint: %v %v %+v", err, response, info)
+	}
+
+	checkOpenSCAP(t, info.Blueprints[0], "xccdf_org.ssgproject.content_profile_standard")
+}
+
+func TestBlueprintLoaderUnknownKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weldr-image-test")
+	if err != nil {
+		t.Fatalf("cannot create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("cannot write %s: %v", name, err)
+		}
+		return path
+	}
+
+	templated := strings.Replace(testOpenSCAPBlueprint, "content_profile_standard", "content_profile_${PROFILE}", 1)
+	loader := &weldr_image.BlueprintLoader{Vars: map[string]string{"PROFILE": "cis"}}
+
+	t.Run("alone", func(t *testing.T) {
+		blueprint, err := loader.LoadFile(write("alone.toml", templated))
+		if err != nil {
+			t.Fatalf("cannot load the blueprint: %v", err)
+		}
+
+		data, err := blueprint.EncodeJSON()
+		if err != nil {
+			t.Fatalf("cannot encode the blueprint: %v", err)
+		}
+		checkOpenSCAP(t, data, "xccdf_org.ssgproject.content_profile_cis")
+	})
+
+	t.Run("included", func(t *testing.T) {
+		base := write("base.toml", templated)
+		_, err := loader.LoadFile(write("including.toml", "name = \"including\"\ninclude = [\"base.toml\"]\n"))
+
+		blueprintError, ok := err.(*weldr_image.BlueprintError)
+		if !ok || blueprintError.File != base {
+			t.Fatalf("expected an error about %s, got %v", base, err)
+		}
+		if len(blueprintError.Problems) != 1 || blueprintError.Problems[0].Key != "customizations.openscap" || blueprintError.Problems[0].Line == 0 {
+			t.Errorf("unexpected problems: %v", blueprintError.Problems)
+		}
+	})
+}
+
+func TestValidateOverlayUnknownKeys(t *testing.T) {
+	server := weldrtest.NewServer()
+	defer server.Close()
+
+	request, remove := newTestRequest(t, server)
+	defer remove()
+	request.Blueprint = []byte(testOpenSCAPBlueprint)
+	request.BlueprintOverlay = &weldr_image.Blueprint{Packages: []weldr_image.Package{{Name: "strace"}}}
+
+	err := request.ValidateContext(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "customizations.openscap") {
+		t.Errorf("expected an error about the unknown keys, got %v", err)
+	}
+}
+
+func TestProcessOverlayExistingUser(t *testing.T) {
+	server := weldrtest.NewServer()
+	defer server.Close()
+
+	request, remove := newTestRequest(t, server)
+	defer remove()
+	request.KeepArtifacts = true
+	request.Blueprint = []byte(`
+name = "test"
+
+[[customizations.user]]
+name = "admin"
+password = "$6$hash"
+groups = ["wheel"]
+uid = 1042
+`)
+	key := "ssh-ed25519 AAAA admin@example.com"
+	request.BlueprintOverlay = &weldr_image.Blueprint{
+		Customizations: &weldr_image.Customizations{
+			User: []weldr_image.UserCustomization{{Name: "admin", Key: &key}},
+		},
+	}
+
+	if _, err := request.ProcessContext(context.Background()); err != nil {
+		t.Fatalf("the request failed: %v", err)
+	}
+
+	info, response, err := client.GetBlueprintsInfoV0(context.Background(), server.Client(), "test")
+	if err != nil || response != nil || len(info.Blueprints) != 1 {
+		t.Fatalf("cannot get the blueprint: %v %v %+v", err, response, info)
+	}
+
+	blueprint, err := weldr_image.DecodeBlueprint(info.Blueprints[0])
+	if err != nil {
+		t.Fatalf("cannot decode the blueprint: %v", err)
+	}
+
+	users := blueprint.Customizations.User
+	if len(users) != 1 {
+		t.Fatalf("unexpected users: %+v", users)
+	}
+	user := users[0]
+	if user.Key == nil || *user.Key != key {
+		t.Errorf("the key was not set: %+v", user)
+	}
+	if user.Password == nil || *user.Password != "$6$hash" || !reflect.DeepEqual(user.Groups, []string{"wheel"}) || user.UID == nil || *user.UID != 1042 {
+		t.Errorf("the other settings of the user were lost: %+v", user)
+	}
+}
@@ -0,0 +1,356 @@
+package weldr_image
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/client"
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/cloudapi"
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/common"
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/weldr"
+)
+
+// cloudImageTypes are the image types of the Cloud API, it has no endpoint
+// listing them
+var cloudImageTypes = []string{
+	"aws",
+	"azure",
+	"edge-commit",
+	"edge-container",
+	"edge-installer",
+	"gcp",
+	"guest-image",
+	"image-installer",
+	"iot-commit",
+	"iot-container",
+	"iot-installer",
+	"iot-raw-image",
+	"oci",
+	"vsphere",
+	"wsl",
+}
+
+// cloudBackend talks to the Cloud API (v2) of osbuild-composer. The Cloud API
+// stores neither blueprints nor sources, every compose request carries its
+// blueprint and repositories. So the pushed blueprints and sources are only
+// kept by the backend until they're deleted.
+//
+// The Cloud API needs the distro and the arch of every compose, it cannot
+// resolve packages nor cancel composes and uploads are not supported.
+type cloudBackend struct {
+	client *http.Client
+
+	mu sync.Mutex
+	// blueprints maps the names of the pushed blueprints to their json
+	blueprints map[string]json.RawMessage
+	sources    map[string]weldr.SourceConfigV0
+	// composes are the composes started by the backend, the Cloud API
+	// doesn't return their blueprint nor their image type
+	composes map[uuid.UUID]weldr.ComposeRequestV0
+}
+
+// NewCloudBackend returns a backend using the Cloud API through the client
+func NewCloudBackend(c *http.Client) Backend {
+	return &cloudBackend{
+		client:     c,
+		blueprints: make(map[string]json.RawMessage),
+		sources:    make(map[string]weldr.SourceConfigV0),
+		composes:   make(map[uuid.UUID]weldr.ComposeRequestV0),
+	}
+}
+
+// ImageTypes returns all the image types of the Cloud API, the ones actually
+// supported by the distro and arch are known only once a compose is started
+func (b *cloudBackend) ImageTypes(ctx context.Context, distro, arch string) ([]weldr.ComposeTypeV0, error) {
+	if distro == "" || arch == "" {
+		return nil, errors.New("the Cloud API needs both the distro and the arch of the images")
+	}
+
+	var types []weldr.ComposeTypeV0
+	for _, name := range cloudImageTypes {
+		types = append(types, weldr.ComposeTypeV0{Name: name, Enabled: true})
+	}
+	return types, nil
+}
+
+func (b *cloudBackend) Sources(ctx context.Context) ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var names []string
+	for name := range b.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (b *cloudBackend) PushSource(ctx context.Context, rawSource []byte) (string, error) {
+	source, _, err := decodeSource(rawSource)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = cloudRepository(source)
+	if err != nil {
+		return "", err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.sources[source.Name] = source
+	return source.Name, nil
+}
+
+func (b *cloudBackend) DeleteSource(ctx context.Context, name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.sources, name)
+	return nil
+}
+
+func (b *cloudBackend) PushBlueprint(ctx context.Context, rawBlueprint []byte) (string, error) {
+	blueprint, err := loadBlueprintOrCreateEmpty(rawBlueprint)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := normalizedJSON(blueprint)
+	if err != nil {
+		return "", err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.blueprints[blueprint.Name] = data
+	return blueprint.Name, nil
+}
+
+func (b *cloudBackend) DeleteBlueprint(ctx context.Context, name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.blueprints, name)
+	return nil
+}
+
+// cloudRepository converts a weldr source into a Cloud API repository
+func cloudRepository(source weldr.SourceConfigV0) (cloudapi.Repository, error) {
+	repository := cloudapi.Repository{CheckGPG: source.CheckGPG}
+
+	switch source.Type {
+	case "yum-baseurl":
+		repository.Baseurl = source.URL
+	case "yum-metalink":
+		repository.Metalink = source.URL
+	case "yum-mirrorlist":
+		repository.Mirrorlist = source.URL
+	default:
+		return cloudapi.Repository{}, fmt.Errorf("the source %s has an unknown type %q, valid types: yum-baseurl, yum-metalink, yum-mirrorlist", source.Name, source.Type)
+	}
+
+	return repository, nil
+}
+
+// composeRequest converts a weldr compose request into a Cloud API one, the
+// pushed sources become its repositories
+func (b *cloudBackend) composeRequest(request weldr.ComposeRequestV0) (cloudapi.ComposeRequest, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	blueprint, ok := b.blueprints[request.BlueprintName]
+	if !ok {
+		return cloudapi.ComposeRequest{}, fmt.Errorf("the blueprint %s was not pushed through the Cloud API backend, the Cloud API cannot build stored blueprints", request.BlueprintName)
+	}
+	if request.Upload != nil {
+		return cloudapi.ComposeRequest{}, errors.New("uploads are not supported by the Cloud API backend")
+	}
+	if len(b.sources) == 0 {
+		return cloudapi.ComposeRequest{}, errors.New("the Cloud API needs the repositories of the image, give them as sources")
+	}
+
+	var names []string
+	for name := range b.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var repositories []cloudapi.Repository
+	for _, name := range names {
+		repository, err := cloudRepository(b.sources[name])
+		if err != nil {
+			return cloudapi.ComposeRequest{}, err
+		}
+		repositories = append(repositories, repository)
+	}
+
+	imageRequest := &cloudapi.ImageRequest{
+		Architecture: request.Arch,
+		ImageType:    request.ComposeType,
+		Repositories: repositories,
+		Size:         request.Size,
+	}
+	if request.OSTree != nil {
+		imageRequest.OSTree = &cloudapi.OSTree{
+			URL:    request.OSTree.URL,
+			Ref:    request.OSTree.Ref,
+			Parent: request.OSTree.Parent,
+		}
+	}
+
+	return cloudapi.ComposeRequest{
+		Distribution: request.Distro,
+		Blueprint:    blueprint,
+		ImageRequest: imageRequest,
+	}, nil
+}
+
+func (b *cloudBackend) StartCompose(ctx context.Context, request weldr.ComposeRequestV0) (uuid.UUID, error) {
+	cloudRequest, err := b.composeRequest(request)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	composeID, err := cloudapi.PostCompose(ctx, b.client, cloudRequest)
+	if err != nil {
+		return uuid.Nil, &APIError{
+			Message: "cannot post a new compose",
+			Cause:   err,
+		}
+	}
+
+	id, err := uuid.Parse(composeID.ID)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("the Cloud API returned an invalid compose id %q: %v", composeID.ID, err)
+	}
+
+	b.mu.Lock()
+	b.composes[id] = request
+	b.mu.Unlock()
+
+	return id, nil
+}
+
+// cloudComposeState converts the status of a Cloud API compose into the
+// state of a weldr compose
+func cloudComposeState(status cloudapi.ComposeStatus) common.ImageBuildState {
+	switch status.Status {
+	case cloudapi.ComposeStatusSuccess:
+		return common.IBFinished
+	case cloudapi.ComposeStatusFailure:
+		return common.IBFailed
+	}
+
+	if status.ImageStatus.Status == cloudapi.ImageStatusPending {
+		return common.IBWaiting
+	}
+	return common.IBRunning
+}
+
+func (b *cloudBackend) ComposeStatus(ctx context.Context, id uuid.UUID) (weldr.ComposeEntryV0, error) {
+	status, err := cloudapi.GetComposeStatus(ctx, b.client, id.String())
+	if err != nil {
+		return weldr.ComposeEntryV0{}, &APIError{
+			Message: "cannot retrieve a compose status",
+			Cause:   err,
+		}
+	}
+
+	b.mu.Lock()
+	request := b.composes[id]
+	b.mu.Unlock()
+
+	return weldr.ComposeEntryV0{
+		ID:          id,
+		Blueprint:   request.BlueprintName,
+		ComposeType: request.ComposeType,
+		ImageSize:   request.Size,
+		QueueStatus: cloudComposeState(status),
+	}, nil
+}
+
+func (b *cloudBackend) CancelCompose(ctx context.Context, id uuid.UUID) error {
+	return errors.New("the Cloud API cannot cancel composes")
+}
+
+func (b *cloudBackend) DeleteCompose(ctx context.Context, id uuid.UUID) error {
+	err := cloudapi.DeleteCompose(ctx, b.client, id.String())
+	if err != nil {
+		return &APIError{
+			Message: "cannot delete the compose",
+			Cause:   err,
+		}
+	}
+
+	b.mu.Lock()
+	delete(b.composes, id)
+	b.mu.Unlock()
+
+	return nil
+}
+
+func (b *cloudBackend) ImageRange(ctx context.Context, id uuid.UUID, offset int64) (io.ReadCloser, client.ImageRange, error) {
+	body, imageRange, err := cloudapi.GetComposeDownloadRange(ctx, b.client, id.String(), offset)
+	if err != nil {
+		return nil, client.ImageRange{}, &APIError{
+			Message: "cannot download the image",
+			Cause:   err,
+		}
+	}
+
+	return body, imageRange, nil
+}
+
+func (b *cloudBackend) WriteManifest(ctx context.Context, w io.Writer, id uuid.UUID) error {
+	manifests, err := cloudapi.GetComposeManifests(ctx, b.client, id.String())
+	if err != nil {
+		return &APIError{
+			Message: "cannot retrieve the manifest",
+			Cause:   err,
+		}
+	}
+
+	if len(manifests.Manifests) == 0 {
+		return errors.New("the Cloud API returned no manifest")
+	}
+
+	_, err = w.Write(manifests.Manifests[0])
+	if err != nil {
+		return fmt.Errorf("cannot copy the manifest: %v", err)
+	}
+
+	return nil
+}
+
+// WriteLog writes the logs of the image builds, each one as a json document
+func (b *cloudBackend) WriteLog(ctx context.Context, w io.Writer, id uuid.UUID) error {
+	logs, err := cloudapi.GetComposeLogs(ctx, b.client, id.String())
+	if err != nil {
+		return &APIError{
+			Message: "cannot retrieve the log",
+			Cause:   err,
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	for _, build := range logs.ImageBuilds {
+		err := encoder.Encode(build)
+		if err != nil {
+			return fmt.Errorf("cannot write the log: %v", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package weldr_image_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ondrejbudai/osbuild-image/internal/cloudapitest"
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/cloudapi"
+	"github.com/ondrejbudai/osbuild-image/internal/weldr-image"
+)
+
+const testCloudSource = `
+name = "fedora"
+type = "yum-baseurl"
+url = "https://repo.example.com/fedora/33/x86_64/"
+check_gpg = false
+check_ssl = true
+`
+
+// newCloudRequest returns a request building a guest image through the Cloud
+// API of the stand-in server into a temporary directory, the directory is
+// removed by the returned function
+func newCloudRequest(t *testing.T, server *cloudapitest.Server) (*weldr_image.Request, func()) {
+	dir, err := ioutil.TempDir("", "weldr-image-test")
+	if err != nil {
+		t.Fatalf("cannot create a temporary directory: %v", err)
+	}
+
+	request := &weldr_image.Request{
+		Images: []weldr_image.Image{{
+			Type:         "guest-image",
+			Path:         filepath.Join(dir, "image.qcow2"),
+			ManifestPath: filepath.Join(dir, "manifest.json"),
+			LogPath:      filepath.Join(dir, "log.txt"),
+		}},
+		Blueprint:    []byte(testBlueprint),
+		Distro:       "fedora-33",
+		Arch:         "x86_64",
+		Sources:      [][]byte{[]byte(testCloudSource)},
+		Backend:      weldr_image.NewCloudBackend(server.Client()),
+		PollInterval: time.Millisecond,
+	}
+
+	return request, func() { os.RemoveAll(dir) }
+}
+
+func TestCloudBackendSuccessful(t *testing.T) {
+	server := cloudapitest.NewServer()
+	defer server.Close()
+
+	request, remove := newCloudRequest(t, server)
+	defer remove()
+
+	results, err := request.ProcessContext(context.Background())
+	if err != nil {
+		t.Fatalf("the request failed: %v", err)
+	}
+
+	if len(results) != 1 || results[0].ImageType != "guest-image" || results[0].BlueprintName != "test" {
+		t.Errorf("unexpected results: %+v", results)
+	}
+
+	image := request.Images[0]
+	if got := readFile(t, image.Path); got != string(server.Image) {
+		t.Errorf("unexpected image %q", got)
+	}
+	if got := readFile(t, image.ManifestPath); !strings.Contains(got, `"pipelines"`) {
+		t.Errorf("unexpected manifest %q", got)
+	}
+	if got := readFile(t, image.LogPath); got == "" {
+		t.Error("the log is empty")
+	}
+
+	if composes := server.Composes(); len(composes) > 0 {
+		t.Errorf("%d composes left behind", len(composes))
+	}
+}
+
+func TestCloudBackendComposeFailed(t *testing.T) {
+	server := cloudapitest.NewServer()
+	defer server.Close()
+
+	server.SetStates("guest-image", cloudapi.ImageStatusPending, cloudapi.ImageStatusBuilding, cloudapi.ImageStatusFailure)
+
+	request, remove := newCloudRequest(t, server)
+	defer remove()
+
+	results, err := request.ProcessContext(context.Background())
+	if _, ok := err.(*weldr_image.ImagesError); !ok {
+		t.Fatalf("expected an *ImagesError, got %v", err)
+	}
+	if _, ok := results[0].Err.(*weldr_image.ComposeError); !ok {
+		t.Errorf("expected a *ComposeError, got %v", results[0].Err)
+	}
+
+	if _, err := os.Stat(request.Images[0].Path); !os.IsNotExist(err) {
+		t.Errorf("the image of a failed compose was written")
+	}
+	if composes := server.Composes(); len(composes) > 0 {
+		t.Errorf("%d composes left behind", len(composes))
+	}
+}
+
+func TestCloudBackendPollTimeout(t *testing.T) {
+	server := cloudapitest.NewServer()
+	defer server.Close()
+
+	// the compose never finishes
+	server.SetStates("guest-image", cloudapi.ImageStatusPending, cloudapi.ImageStatusBuilding)
+
+	request, remove := newCloudRequest(t, server)
+	defer remove()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	results, err := request.ProcessContext(ctx)
+	if err == nil {
+		t.Fatal("a request of a compose that never finishes succeeded")
+	}
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Errorf("the request took %v despite its deadline", elapsed)
+	}
+
+	if !strings.Contains(results[0].Err.Error(), context.DeadlineExceeded.Error()) {
+		t.Errorf("unexpected error: %v", results[0].Err)
+	}
+
+	var polls int
+	for _, r := range server.Requests() {
+		if r == "GET "+cloudapi.BasePath+"/composes/"+results[0].ComposeID.String() {
+			polls++
+		}
+	}
+	if polls < 2 {
+		t.Errorf("the compose was polled only %d times", polls)
+	}
+}
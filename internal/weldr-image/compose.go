@@ -0,0 +1,278 @@
+package weldr_image
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/common"
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/weldr"
+)
+
+// composeHandler takes care of one image of the request, from starting its
+// compose to downloading the artifacts
+type composeHandler struct {
+	*requestHandler
+
+	image *Image
+
+	composeId uuid.UUID
+	// compose is the last retrieved status of the compose
+	compose weldr.ComposeEntryV0
+}
+
+func (h *composeHandler) process(ctx context.Context) (err error) {
+	if h.image.attached() {
+		h.composeId = h.image.ComposeID
+	} else {
+		err := h.pushCompose(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	if h.request.Detach {
+		return nil
+	}
+	if !h.request.KeepArtifacts {
+		defer func() {
+			// an existing compose is kept unless all its artifacts
+			// were fetched, so the fetch can be tried again
+			if h.image.attached() && err != nil {
+				return
+			}
+
+			err := h.deleteCompose()
+			if err != nil {
+				log.Printf("cannot delete the compose: %v\n", err)
+			}
+
+			h.removePartialImage()
+		}()
+	}
+
+	// the request can be interrupted anywhere from now on, not only while
+	// waiting for the compose, so it's handled once (before the compose is
+	// deleted)
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			err = h.interrupted(ctx.Err())
+		}
+	}()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	err = h.waitForFinishedCompose(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = h.writeComposeImage(ctx)
+	if err != nil {
+		return err
+	}
+
+	if h.image.ManifestPath != "" {
+		err := h.writeManifest(ctx)
+		if err != nil {
+			return err
+		}
+		h.emit(Event{Type: EventManifestWritten, Path: h.image.ManifestPath})
+	}
+
+	if h.image.LogPath != "" {
+		err := h.writeLog(ctx)
+		if err != nil {
+			return err
+		}
+		h.emit(Event{Type: EventLogWritten, Path: h.image.LogPath})
+	}
+
+	return nil
+}
+
+// emit fills in the details of the compose and passes the event to the
+// request's observer
+func (h *composeHandler) emit(event Event) {
+	event.ImageType = h.image.Type
+	if h.composeId != uuid.Nil {
+		event.ComposeID = h.composeId.String()
+	}
+
+	h.requestHandler.emit(event)
+}
+
+func (h *composeHandler) pushCompose(ctx context.Context) error {
+	id, err := h.backend.StartCompose(ctx, weldr.ComposeRequestV0{
+		BlueprintName: h.blueprintName,
+		ComposeType:   h.image.Type,
+		Distro:        h.request.Distro,
+		Arch:          h.request.Arch,
+		Size:          h.image.Size,
+		OSTree:        h.image.OSTree,
+		Upload:        h.image.Upload,
+	})
+	if err != nil {
+		return err
+	}
+
+	h.composeId = id
+	atomic.AddInt32(&h.composesStarted, 1)
+
+	h.emit(Event{Type: EventComposeQueued})
+
+	return nil
+}
+
+// waitForFinishedCompose polls the status of the compose until it finishes or
+// fails, a finished compose is then polled until all its uploads finish or
+// fail as well
+func (h *composeHandler) waitForFinishedCompose(ctx context.Context) error {
+	var previousState *common.ImageBuildState
+	previousUploadStates := make(map[string]common.ImageBuildState)
+	for {
+		compose, err := h.backend.ComposeStatus(ctx, h.composeId)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+
+		h.compose = compose
+
+		state := compose.QueueStatus
+		if previousState == nil || *previousState != state {
+			h.emit(Event{Type: EventComposeStateChanged, State: &state, PreviousState: previousState})
+			previousState = &state
+		}
+		h.emitUploadStates(compose.Uploads, previousUploadStates)
+
+		if compose.QueueStatus == common.IBFailed {
+			var logBuffer bytes.Buffer
+			err := h.backend.WriteLog(ctx, &logBuffer, h.composeId)
+
+			if err != nil {
+				return &APIError{
+					Message: "cannot retrieve the log",
+					Cause: &APIError{
+						Message: "compose failed",
+					},
+				}
+			}
+
+			return &ComposeError{Log: logBuffer.String()}
+		}
+
+		if compose.QueueStatus == common.IBFinished && uploadsDone(compose.Uploads) {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(h.request.pollInterval()):
+		}
+	}
+
+	return checkUploads(h.compose.Uploads)
+}
+
+// interrupted cancels the compose in osbuild-composer because the request
+// was interrupted by cause, unless the compose already finished. It always
+// returns an error describing the interruption.
+func (h *composeHandler) interrupted(cause error) error {
+	// an existing compose wasn't started by this request, so it's not
+	// cancelled
+	if h.image.attached() || h.compose.QueueStatus == common.IBFinished {
+		return fmt.Errorf("the build was interrupted: %v", cause)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	err := h.backend.CancelCompose(ctx, h.composeId)
+	if err != nil {
+		log.Printf("cannot cancel the compose: %v\n", err)
+	}
+
+	return fmt.Errorf("the build was interrupted: %v", cause)
+}
+
+func (h *composeHandler) writeComposeImage(ctx context.Context) error {
+	if h.image.Path == "" && h.image.Writer == nil {
+		return nil
+	}
+
+	if h.image.Path != "" {
+		return h.downloadComposeImage(ctx)
+	}
+
+	h.emit(Event{Type: EventDownloadStarted})
+
+	body, _, err := h.backend.ImageRange(ctx, h.composeId, 0)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	writer := &progressWriter{w: h.image.Writer, handler: h, lastReport: time.Now()}
+	_, err = io.Copy(writer, body)
+	if err != nil {
+		return &APIError{
+			Message: "cannot download the image",
+			Cause:   err,
+		}
+	}
+
+	h.emit(Event{Type: EventDownloadFinished, Bytes: writer.written})
+
+	return nil
+}
+
+func (h *composeHandler) deleteCompose() error {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	return h.backend.DeleteCompose(ctx, h.composeId)
+}
+
+func (h *composeHandler) writeManifest(ctx context.Context) error {
+	// the manifest is retrieved first, so no file is created if it fails
+	var manifest bytes.Buffer
+	err := h.backend.WriteManifest(ctx, &manifest, h.composeId)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(h.image.ManifestPath)
+	if err != nil {
+		return fmt.Errorf("cannot created the manifest file: %v", err)
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, &manifest)
+	if err != nil {
+		return fmt.Errorf("cannot copy the manifest: %v", err)
+	}
+
+	return nil
+}
+
+func (h *composeHandler) writeLog(ctx context.Context) error {
+	f, err := os.Create(h.image.LogPath)
+	if err != nil {
+		return fmt.Errorf("cannot created the log file: %v", err)
+	}
+	defer f.Close()
+
+	return h.backend.WriteLog(ctx, f, h.composeId)
+}
@@ -0,0 +1,214 @@
+package weldr_image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/client"
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/weldr"
+)
+
+// maxSuggestions limits how many similarly named packages are suggested for
+// a missing one
+const maxSuggestions = 3
+
+// DepsolveError is returned when the packages of the blueprint cannot be
+// resolved, e.g. because of a typo in a package name
+type DepsolveError struct {
+	BlueprintName string
+	// Message is the error reported by osbuild-composer
+	Message string
+	// Missing are the packages, groups and modules that don't exist in the
+	// repositories, it's empty if they couldn't be figured out from Message
+	Missing []string
+	// Suggestions maps a missing package to existing packages with a
+	// similar name
+	Suggestions map[string][]string
+}
+
+func (e *DepsolveError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "cannot resolve the packages of the blueprint %s", e.BlueprintName)
+
+	if len(e.Missing) == 0 {
+		fmt.Fprintf(&b, ": %s", e.Message)
+		return b.String()
+	}
+
+	for _, missing := range e.Missing {
+		fmt.Fprintf(&b, "\n  %s not found", missing)
+		if suggestions := e.Suggestions[missing]; len(suggestions) > 0 {
+			fmt.Fprintf(&b, ", did you mean %s?", strings.Join(suggestions, ", "))
+		}
+	}
+	return b.String()
+}
+
+// Depsolve validates the image types, registers the sources, pushes the
+// blueprint and resolves all the packages needed to build it without starting
+// any compose. The images need no path nor writer. The sources and the
+// blueprint are deleted afterwards unless KeepArtifacts is set, an existing
+// blueprint given by BlueprintName is never deleted.
+func (r *Request) Depsolve() ([]weldr.PackageSpecV0, error) {
+	return r.DepsolveContext(context.Background())
+}
+
+// DepsolveContext is like Depsolve but all the API calls are bound to ctx
+func (r *Request) DepsolveContext(ctx context.Context) ([]weldr.PackageSpecV0, error) {
+	err := r.validate(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	rh := requestHandler{
+		backend: r.backend(),
+		request: r,
+	}
+	if _, ok := rh.backend.(Depsolver); !ok {
+		return nil, errors.New("the backend cannot resolve the packages of a blueprint")
+	}
+
+	if !r.KeepArtifacts {
+		defer rh.cleanup()
+	}
+
+	err = rh.pushSources(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = rh.pushBlueprint(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if rh.blueprintName == "" {
+		return nil, errors.New("no blueprint to be resolved, all the images are built by existing composes")
+	}
+
+	return rh.depsolve(ctx)
+}
+
+// depsolve resolves the packages of the blueprint, it's the pre-flight check
+// catching missing packages before any compose is started. Nothing is checked
+// if the backend cannot resolve packages.
+func (h *requestHandler) depsolve(ctx context.Context) ([]weldr.PackageSpecV0, error) {
+	depsolver, ok := h.backend.(Depsolver)
+	if !ok {
+		return nil, nil
+	}
+
+	deps, err := depsolver.Depsolve(ctx, h.blueprintName)
+	if err != nil {
+		return nil, err
+	}
+
+	h.emit(Event{Type: EventBlueprintDepsolved, Packages: len(deps)})
+
+	return deps, nil
+}
+
+// missingPackages extracts the names of the missing packages, groups and
+// modules from the depsolve error of osbuild-composer, e.g.
+//
+//	bp: DNF error occurred: MarkingErrors: Error occurred when marking
+//	packages for installation: Problems in request:
+//	missing packages: tmuxx, vim-enhancd
+func missingPackages(message string) []string {
+	var missing []string
+
+	for _, line := range strings.Split(message, "\n") {
+		for _, prefix := range []string{"missing packages:", "missing groups or modules:"} {
+			i := strings.Index(line, prefix)
+			if i < 0 {
+				continue
+			}
+			for _, name := range strings.Split(line[i+len(prefix):], ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					missing = append(missing, name)
+				}
+			}
+		}
+	}
+
+	return missing
+}
+
+// suggestPackages looks up existing packages with names similar to the
+// missing ones. The suggestions are only a hint, so failing to retrieve the
+// packages is not an error.
+func (b *weldrBackend) suggestPackages(ctx context.Context, missing []string) map[string][]string {
+	if len(missing) == 0 {
+		return nil
+	}
+
+	projects, response, err := client.ListAllProjectsV0(ctx, b.client)
+	if err := TranslateError(response, err); err != nil {
+		log.Printf("cannot retrieve the packages to suggest: %v\n", err)
+		return nil
+	}
+
+	suggestions := make(map[string][]string)
+	for _, name := range missing {
+		type candidate struct {
+			name     string
+			distance int
+		}
+		var candidates []candidate
+
+		maxDistance := len(name)/4 + 1
+		for _, project := range projects {
+			distance := editDistance(strings.ToLower(name), strings.ToLower(project.Name))
+			if distance <= maxDistance {
+				candidates = append(candidates, candidate{project.Name, distance})
+			}
+		}
+
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].distance < candidates[j].distance
+		})
+		for i := 0; i < len(candidates) && i < maxSuggestions; i++ {
+			suggestions[name] = append(suggestions[name], candidates[i].name)
+		}
+	}
+
+	return suggestions
+}
+
+// editDistance returns the Levenshtein distance of two strings
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,192 @@
+package weldr_image
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/client"
+)
+
+// maxDownloadAttempts limits how many times a download interrupted by a
+// connection failure is resumed
+const maxDownloadAttempts = 5
+
+// ChecksumMismatchError is returned when the downloaded image doesn't match
+// the expected checksum
+type ChecksumMismatchError struct {
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch: expected %s, got %s", e.Expected, e.Actual)
+}
+
+// partialPath returns the path where the image is downloaded before it's
+// verified. It contains the compose id, so the download is never resumed from
+// an image of a different compose. It's removed together with the compose.
+func (h *composeHandler) partialPath() string {
+	return fmt.Sprintf("%s.%s.part", h.image.Path, h.composeId)
+}
+
+// removePartialImage removes the partial file of a failed download once its
+// compose is deleted, the download cannot be resumed anymore then
+func (h *composeHandler) removePartialImage() {
+	if h.image.Path == "" {
+		return
+	}
+
+	err := os.Remove(h.partialPath())
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("cannot remove the partial image file: %v\n", err)
+	}
+}
+
+// downloadComposeImage downloads the image into a partial file, resuming the
+// download if the partial file already exists, verifies the image and
+// atomically moves it to the image path
+func (h *composeHandler) downloadComposeImage(ctx context.Context) error {
+	partialPath := h.partialPath()
+
+	f, err := os.OpenFile(partialPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open the partial image file: %v", err)
+	}
+	defer f.Close()
+
+	var imageRange client.ImageRange
+	for attempt := 1; ; attempt++ {
+		imageRange, err = h.downloadRange(ctx, f, attempt == 1)
+		if err == nil {
+			break
+		}
+		if _, isAPIError := err.(*APIError); isAPIError || ctx.Err() != nil || attempt >= maxDownloadAttempts {
+			return err
+		}
+		log.Printf("downloading the image failed, resuming: %v\n", err)
+	}
+
+	checksum, err := verifyImage(f, imageRange, h.image.Checksum)
+	if err != nil {
+		// the partial file is corrupted, it cannot be resumed
+		f.Close()
+		os.Remove(partialPath)
+		return fmt.Errorf("verification of the downloaded image failed: %v", err)
+	}
+
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("cannot write the image: %v", err)
+	}
+
+	err = os.Rename(partialPath, h.image.Path)
+	if err != nil {
+		return fmt.Errorf("cannot move the image into place: %v", err)
+	}
+
+	h.emit(Event{Type: EventDownloadFinished, Bytes: imageRange.Size, Path: h.image.Path, Checksum: checksum})
+
+	return nil
+}
+
+// downloadRange appends the rest of the image to the partial file f
+func (h *composeHandler) downloadRange(ctx context.Context, f *os.File, first bool) (client.ImageRange, error) {
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return client.ImageRange{}, fmt.Errorf("cannot seek in the partial image file: %v", err)
+	}
+
+	body, imageRange, err := h.backend.ImageRange(ctx, h.composeId, offset)
+	if err != nil {
+		return client.ImageRange{}, err
+	}
+	defer body.Close()
+
+	// the server ignored the range, start from scratch
+	if imageRange.Offset != offset {
+		err := f.Truncate(0)
+		if err == nil {
+			_, err = f.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			return client.ImageRange{}, fmt.Errorf("cannot truncate the partial image file: %v", err)
+		}
+	}
+
+	if first {
+		h.emit(Event{Type: EventDownloadStarted, Bytes: imageRange.Offset})
+	}
+
+	writer := &progressWriter{w: f, handler: h, written: imageRange.Offset, lastReport: time.Now()}
+	_, err = io.Copy(writer, body)
+	if err != nil {
+		return client.ImageRange{}, fmt.Errorf("cannot download the image: %v", err)
+	}
+
+	return imageRange, nil
+}
+
+// verifyImage checks the size of the downloaded image against the one
+// announced by the server, and its checksum against the announced one and the
+// expected one (in the "sha256:HEX" form, optional). It returns the image's
+// checksum. The image size of the compose is the size of the disk, not of the
+// file, so it cannot be checked.
+func verifyImage(f *os.File, imageRange client.ImageRange, expected string) (string, error) {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", err
+	}
+
+	if imageRange.Size >= 0 && size != imageRange.Size {
+		return "", fmt.Errorf("the image is truncated: got %d bytes, expected %d", size, imageRange.Size)
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return "", fmt.Errorf("cannot compute the checksum: %v", err)
+	}
+	sum := hash.Sum(nil)
+	checksum := "sha256:" + hex.EncodeToString(sum)
+
+	if digest := sha256Digest(imageRange.Digest); digest != "" && digest != base64.StdEncoding.EncodeToString(sum) {
+		return "", &ChecksumMismatchError{
+			Expected: "sha-256=" + digest,
+			Actual:   "sha-256=" + base64.StdEncoding.EncodeToString(sum),
+		}
+	}
+
+	if expected != "" && !strings.EqualFold(expected, checksum) {
+		return "", &ChecksumMismatchError{
+			Expected: expected,
+			Actual:   checksum,
+		}
+	}
+
+	return checksum, nil
+}
+
+// sha256Digest extracts the sha-256 value from a Digest header, e.g.
+// "sha-256=X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE="
+func sha256Digest(header string) string {
+	for _, digest := range strings.Split(header, ",") {
+		parts := strings.SplitN(strings.TrimSpace(digest), "=", 2)
+		if len(parts) == 2 && strings.EqualFold(parts[0], "sha-256") {
+			return parts[1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,180 @@
+package weldr_image
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const defaultSocketPath = "/run/weldr/api.socket"
+
+// DefaultAddress is the address of the local osbuild-composer's API socket
+const DefaultAddress = "unix://" + defaultSocketPath
+
+// Endpoint describes how to reach the osbuild-composer's API
+type Endpoint struct {
+	// Address is one of:
+	//   unix:///path/to/api.socket (a plain path works as well)
+	//   http://host:port
+	//   https://host:port
+	// An empty address means DefaultAddress.
+	Address string
+
+	// CAFile is a PEM file with the CA certificates used to verify the
+	// server (optional, https only, the system pool is used by default)
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and its key used to
+	// authenticate to the server (optional, https only)
+	CertFile string
+	KeyFile  string
+
+	// Retry is the policy for retrying transient failures (optional, the
+	// requests are not retried by default)
+	Retry RetryPolicy
+}
+
+// NewClient returns a client talking to the endpoint
+func (e *Endpoint) NewClient() (*http.Client, error) {
+	c, err := e.newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	if e.Retry.MaxRetries > 0 {
+		c.Transport = newRetryTransport(c.Transport, e.Retry)
+	}
+
+	return c, nil
+}
+
+func (e *Endpoint) newClient() (*http.Client, error) {
+	address := e.Address
+	if address == "" {
+		address = DefaultAddress
+	}
+
+	if strings.HasPrefix(address, "/") {
+		if e.hasTLSOptions() {
+			return nil, errors.New("tls options can be only used with https addresses")
+		}
+		return unixSocketClient(address), nil
+	}
+
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse the address %s: %v", address, err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		if u.Path == "" {
+			return nil, fmt.Errorf("the address %s contains no socket path", address)
+		}
+		if e.hasTLSOptions() {
+			return nil, errors.New("tls options can be only used with https addresses")
+		}
+		return unixSocketClient(u.Path), nil
+	case "http":
+		if e.hasTLSOptions() {
+			return nil, errors.New("tls options can be only used with https addresses")
+		}
+		return tcpClient(u, nil), nil
+	case "https":
+		tlsConfig, err := e.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		return tcpClient(u, tlsConfig), nil
+	}
+
+	return nil, fmt.Errorf("unsupported address scheme %q, valid schemes: unix, http, https", u.Scheme)
+}
+
+func (e *Endpoint) hasTLSOptions() bool {
+	return e.CAFile != "" || e.CertFile != "" || e.KeyFile != ""
+}
+
+func (e *Endpoint) tlsConfig() (*tls.Config, error) {
+	var config tls.Config
+
+	if e.CAFile != "" {
+		caCerts, err := ioutil.ReadFile(e.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the CA file: %v", err)
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(caCerts) {
+			return nil, fmt.Errorf("no certificate found in the CA file %s", e.CAFile)
+		}
+	}
+
+	if (e.CertFile == "") != (e.KeyFile == "") {
+		return nil, errors.New("both the client certificate and its key must be given")
+	}
+
+	if e.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(e.CertFile, e.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load the client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return &config, nil
+}
+
+func unixSocketClient(path string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+func tcpClient(base *url.URL, tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Transport: &baseURLTransport{
+			base: base,
+			next: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+	}
+}
+
+// baseURLTransport redirects the requests to the base URL. The client package
+// always sends requests to http://localhost, this transport replaces the
+// scheme and the host and prefixes the path with the base path. The escaping
+// of the path (e.g. of a blueprint name containing a slash) is kept.
+type baseURLTransport struct {
+	base *url.URL
+	next http.RoundTripper
+}
+
+func (t *baseURLTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u := *req.URL
+	u.Scheme = t.base.Scheme
+	u.Host = t.base.Host
+	u.Path = strings.TrimSuffix(t.base.Path, "/") + req.URL.Path
+	u.RawPath = strings.TrimSuffix(t.base.EscapedPath(), "/") + req.URL.EscapedPath()
+
+	// RoundTrip must not modify the original request
+	redirected := new(http.Request)
+	*redirected = *req
+	redirected.URL = &u
+	redirected.Host = ""
+
+	return t.next.RoundTrip(redirected)
+}
@@ -0,0 +1,111 @@
+package weldr_image
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/common"
+)
+
+func getEventTypeMapping() []string {
+	return []string{
+		"blueprint-pushed",
+		"compose-queued",
+		"compose-state-changed",
+		"download-started",
+		"download-progress",
+		"download-finished",
+		"manifest-written",
+		"log-written",
+		"cleanup-done",
+		"blueprint-depsolved",
+		"source-added",
+		"upload-state-changed",
+	}
+}
+
+// EventType identifies what happened during processing of a Request
+type EventType int
+
+const (
+	EventBlueprintPushed EventType = iota
+	EventComposeQueued
+	EventComposeStateChanged
+	EventDownloadStarted
+	EventDownloadProgress
+	EventDownloadFinished
+	EventManifestWritten
+	EventLogWritten
+	EventCleanupDone
+	EventBlueprintDepsolved
+	EventSourceAdded
+	EventUploadStateChanged
+)
+
+// String converts EventType into a human readable string
+func (t EventType) String() string {
+	return getEventTypeMapping()[int(t)]
+}
+
+func (t EventType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+// Event describes a single step in the lifecycle of a Request. Only the
+// fields relevant for the given Type are set.
+type Event struct {
+	Type           EventType               `json:"type"`
+	Time           time.Time               `json:"time"`
+	BlueprintName  string                  `json:"blueprint_name,omitempty"`
+	ComposeID      string                  `json:"compose_id,omitempty"`
+	ImageType      string                  `json:"image_type,omitempty"`
+	State          *common.ImageBuildState `json:"state,omitempty"`
+	PreviousState  *common.ImageBuildState `json:"previous_state,omitempty"`
+	Bytes          int64                   `json:"bytes,omitempty"`
+	Path           string                  `json:"path,omitempty"`
+	Checksum       string                  `json:"checksum,omitempty"`
+	Packages       int                     `json:"packages,omitempty"`
+	SourceName     string                  `json:"source_name,omitempty"`
+	UploadProvider string                  `json:"upload_provider,omitempty"`
+}
+
+// Observer receives events emitted while a Request is processed. OnEvent is
+// called synchronously, so it should not block for long.
+type Observer interface {
+	OnEvent(event Event)
+}
+
+// ObserverFunc is an adapter to allow the use of ordinary functions as
+// observers.
+type ObserverFunc func(event Event)
+
+func (f ObserverFunc) OnEvent(event Event) {
+	f(event)
+}
+
+// downloadProgressInterval is the minimal time between two download progress
+// events
+const downloadProgressInterval = 1 * time.Second
+
+// progressWriter counts the bytes written through it and periodically
+// reports them as download progress events
+type progressWriter struct {
+	w       io.Writer
+	handler *composeHandler
+
+	written    int64
+	lastReport time.Time
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.written += int64(n)
+
+	if time.Since(pw.lastReport) >= downloadProgressInterval {
+		pw.lastReport = time.Now()
+		pw.handler.emit(Event{Type: EventDownloadProgress, Bytes: pw.written})
+	}
+
+	return n, err
+}
@@ -0,0 +1,299 @@
+package weldr_image
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// position is a line and a column in a document, both starting at 1
+type position struct {
+	line   int
+	column int
+}
+
+// lineColumn converts a byte offset in the document into a line and a column
+// (counted in characters)
+func lineColumn(document []byte, offset int64) (int, int) {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > int64(len(document)) {
+		offset = int64(len(document))
+	}
+
+	before := document[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	lineStart := bytes.LastIndexByte(before, '\n') + 1
+	return line, utf8.RuneCount(before[lineStart:]) + 1
+}
+
+// jsonLocator walks a valid json document and records where its keys and
+// array elements start
+type jsonLocator struct {
+	document  []byte
+	offset    int
+	positions map[string]position
+}
+
+// jsonKeyPositions returns the positions of the keys of a valid json
+// document, the keys are paths like customizations.user[0].uid
+func jsonKeyPositions(document []byte) map[string]position {
+	l := jsonLocator{
+		document:  document,
+		positions: make(map[string]position),
+	}
+	l.value("")
+	return l.positions
+}
+
+func (l *jsonLocator) skipSpace() {
+	for l.offset < len(l.document) && strings.IndexByte(" \t\r\n", l.document[l.offset]) >= 0 {
+		l.offset++
+	}
+}
+
+// here returns the position of the current offset
+func (l *jsonLocator) here() position {
+	line, column := lineColumn(l.document, int64(l.offset))
+	return position{line, column}
+}
+
+// str skips a string and returns its value
+func (l *jsonLocator) str() string {
+	start := l.offset
+	l.offset++
+	for l.offset < len(l.document) && l.document[l.offset] != '"' {
+		if l.document[l.offset] == '\\' {
+			l.offset++
+		}
+		l.offset++
+	}
+	l.offset++
+
+	var s string
+	_ = json.Unmarshal(l.document[start:l.offset], &s)
+	return s
+}
+
+func (l *jsonLocator) value(key string) {
+	l.skipSpace()
+	if l.offset >= len(l.document) {
+		return
+	}
+
+	switch l.document[l.offset] {
+	case '{':
+		l.offset++
+		for {
+			l.skipSpace()
+			if l.offset >= len(l.document) {
+				return
+			}
+			switch l.document[l.offset] {
+			case '}':
+				l.offset++
+				return
+			case ',':
+				l.offset++
+				continue
+			}
+
+			pos := l.here()
+			member := joinKey(key, l.str())
+			l.positions[member] = pos
+
+			l.skipSpace()
+			l.offset++ // the colon
+			l.value(member)
+		}
+
+	case '[':
+		l.offset++
+		for i := 0; ; {
+			l.skipSpace()
+			if l.offset >= len(l.document) {
+				return
+			}
+			switch l.document[l.offset] {
+			case ']':
+				l.offset++
+				return
+			case ',':
+				l.offset++
+				continue
+			}
+
+			element := fmt.Sprintf("%s[%d]", key, i)
+			l.positions[element] = l.here()
+			l.value(element)
+			i++
+		}
+
+	case '"':
+		l.str()
+
+	default:
+		for l.offset < len(l.document) && strings.IndexByte(",}] \t\r\n", l.document[l.offset]) < 0 {
+			l.offset++
+		}
+	}
+}
+
+// tomlLocator scans a valid toml document line by line and records where its
+// keys and tables start. Keys inside inline tables and the elements of inline
+// arrays are not recorded.
+type tomlLocator struct {
+	positions map[string]position
+	// arrayTables counts the tables of each array of tables seen so far
+	arrayTables map[string]int
+	// table is the path of the current table
+	table string
+}
+
+// tomlKeyPositions returns the positions of the keys and tables of a valid
+// toml document, the keys are paths like customizations.user[0].uid
+func tomlKeyPositions(document string) map[string]position {
+	l := tomlLocator{
+		positions:   make(map[string]position),
+		arrayTables: make(map[string]int),
+	}
+
+	// multiline is the delimiter of the multiline string being skipped,
+	// depth is the nesting of the multiline array being skipped
+	multiline := ""
+	depth := 0
+
+	for i, line := range strings.Split(document, "\n") {
+		if multiline != "" {
+			if strings.Count(line, multiline)%2 == 1 {
+				multiline = ""
+			}
+			continue
+		}
+
+		if depth > 0 {
+			depth += bracketDepth(line)
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' {
+			continue
+		}
+
+		column := utf8.RuneCountInString(line[:strings.Index(line, trimmed)]) + 1
+		pos := position{i + 1, column}
+
+		if strings.HasPrefix(trimmed, "[[") {
+			end := strings.Index(trimmed, "]]")
+			if end > 0 {
+				l.enterTable(trimmed[2:end], true, pos)
+			}
+			continue
+		}
+		if trimmed[0] == '[' {
+			end := strings.Index(trimmed, "]")
+			if end > 0 {
+				l.enterTable(trimmed[1:end], false, pos)
+			}
+			continue
+		}
+
+		equals := strings.Index(trimmed, "=")
+		if equals < 0 {
+			continue
+		}
+
+		key := l.table
+		for _, part := range splitTOMLKey(trimmed[:equals]) {
+			key = joinKey(key, part)
+		}
+		if _, ok := l.positions[key]; !ok {
+			l.positions[key] = pos
+		}
+
+		value := trimmed[equals+1:]
+		for _, delimiter := range []string{`"""`, `'''`} {
+			if strings.Count(value, delimiter)%2 == 1 {
+				multiline = delimiter
+			}
+		}
+		if multiline == "" {
+			depth = bracketDepth(value)
+		}
+	}
+
+	return l.positions
+}
+
+// enterTable makes the table (or a new table of the array of tables) named
+// by a header the current one
+func (l *tomlLocator) enterTable(header string, array bool, pos position) {
+	parts := splitTOMLKey(header)
+	key := ""
+	for i, part := range parts {
+		key = joinKey(key, part)
+		if i == len(parts)-1 {
+			break
+		}
+		if n, ok := l.arrayTables[key]; ok {
+			key = fmt.Sprintf("%s[%d]", key, n-1)
+		}
+	}
+
+	if _, ok := l.positions[key]; !ok {
+		l.positions[key] = pos
+	}
+
+	if array {
+		n := l.arrayTables[key]
+		l.arrayTables[key] = n + 1
+		key = fmt.Sprintf("%s[%d]", key, n)
+		l.positions[key] = pos
+	}
+
+	l.table = key
+}
+
+// splitTOMLKey splits a dotted key into its parts, removing the quotes
+func splitTOMLKey(key string) []string {
+	var parts []string
+	for _, part := range strings.Split(key, ".") {
+		part = strings.TrimSpace(part)
+		if len(part) >= 2 && (part[0] == '"' || part[0] == '\'') {
+			part = part[1 : len(part)-1]
+		}
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+// bracketDepth returns how many more arrays are opened than closed on the
+// line, brackets in strings and comments are ignored
+func bracketDepth(line string) int {
+	depth := 0
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return depth
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		}
+	}
+	return depth
+}
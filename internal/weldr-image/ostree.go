@@ -0,0 +1,99 @@
+package weldr_image
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ostreeRefRegexp matches valid ostree refs, e.g. "rhel/8/x86_64/edge", the
+// same rule is used by osbuild-composer
+var ostreeRefRegexp = regexp.MustCompile(`^(?:[\w\d][-._\w\d]*\/)*[\w\d][-._\w\d]*$`)
+
+// ostreeChecksumRegexp matches ostree commit checksums
+var ostreeChecksumRegexp = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ostreeImageTypePrefixes are the prefixes of the edge and iot image types,
+// the only ones built from ostree commits
+var ostreeImageTypePrefixes = []string{"edge-", "rhel-edge-", "iot-", "fedora-iot-"}
+
+// isOSTreeImageType returns true if the image type is an ostree commit or an
+// image built from one
+func isOSTreeImageType(imageType string) bool {
+	for _, prefix := range ostreeImageTypePrefixes {
+		if strings.HasPrefix(imageType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isOSTreeCommitType returns true if the image type is an ostree commit,
+// either as a tarball or inside a container
+func isOSTreeCommitType(imageType string) bool {
+	return isOSTreeImageType(imageType) && (strings.HasSuffix(imageType, "-commit") || strings.HasSuffix(imageType, "-container"))
+}
+
+// validateParameters checks that the size and the ostree parameters make sense
+// for the image type
+func (i *Image) validateParameters() error {
+	if i.Size != 0 && isOSTreeCommitType(i.Type) {
+		return fmt.Errorf("the %s image is an ostree commit archive, its size cannot be set", i.Type)
+	}
+
+	// installers and disk images are built from a commit pulled from the
+	// url
+	if isOSTreeImageType(i.Type) && !isOSTreeCommitType(i.Type) && (i.OSTree == nil || i.OSTree.URL == "") {
+		return fmt.Errorf("the %s image is built from an existing commit, the ostree url cannot be empty", i.Type)
+	}
+
+	if i.OSTree == nil {
+		return nil
+	}
+
+	if !isOSTreeImageType(i.Type) {
+		return fmt.Errorf("the %s image is not an edge nor iot image, it takes no ostree parameters", i.Type)
+	}
+
+	return validateOSTree(i)
+}
+
+// validateOSTree checks the ostree parameters of an ostree image:
+//
+//   - the ref must be a valid ostree ref
+//   - the parent must be a ref or a commit checksum, and only commits have one
+//   - the parent and the url cannot be combined
+//   - the url must be an http or https url
+func validateOSTree(i *Image) error {
+	ostree := i.OSTree
+
+	if ostree.Ref != "" && !ostreeRefRegexp.MatchString(ostree.Ref) {
+		return fmt.Errorf("invalid ostree ref: %q", ostree.Ref)
+	}
+
+	if ostree.Parent != "" {
+		if !isOSTreeCommitType(i.Type) {
+			return fmt.Errorf("the %s image is not an ostree commit, it cannot have a parent", i.Type)
+		}
+		if !ostreeRefRegexp.MatchString(ostree.Parent) && !ostreeChecksumRegexp.MatchString(ostree.Parent) {
+			return fmt.Errorf("invalid ostree parent, it's neither a ref nor a commit checksum: %q", ostree.Parent)
+		}
+		if ostree.URL != "" {
+			return errors.New("the ostree parent and url cannot be combined, give at most one of them")
+		}
+	}
+
+	if ostree.URL != "" {
+		u, err := url.Parse(ostree.URL)
+		if err != nil {
+			return fmt.Errorf("invalid ostree url: %v", err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid ostree url, it must be an http or https url: %q", ostree.URL)
+		}
+	}
+
+	return nil
+}
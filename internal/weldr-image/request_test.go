@@ -0,0 +1,436 @@
+package weldr_image_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/common"
+	"github.com/ondrejbudai/osbuild-image/internal/weldr-image"
+	"github.com/ondrejbudai/osbuild-image/internal/weldrtest"
+)
+
+const testBlueprint = `
+name = "test"
+
+[[packages]]
+name = "tmux"
+`
+
+// newTestRequest returns a request building a qcow2 image against the fake
+// server into a temporary directory, the directory is removed by the returned
+// function
+func newTestRequest(t *testing.T, server *weldrtest.Server) (*weldr_image.Request, func()) {
+	dir, err := ioutil.TempDir("", "weldr-image-test")
+	if err != nil {
+		t.Fatalf("cannot create a temporary directory: %v", err)
+	}
+
+	request := &weldr_image.Request{
+		Images: []weldr_image.Image{{
+			Type:         "qcow2",
+			Path:         filepath.Join(dir, "image.qcow2"),
+			ManifestPath: filepath.Join(dir, "manifest.json"),
+			LogPath:      filepath.Join(dir, "log.txt"),
+		}},
+		Blueprint:    []byte(testBlueprint),
+		Client:       server.Client(),
+		PollInterval: time.Millisecond,
+	}
+
+	return request, func() { os.RemoveAll(dir) }
+}
+
+// checkCleanedUp checks that the request left no blueprint nor compose behind
+func checkCleanedUp(t *testing.T, server *weldrtest.Server) {
+	t.Helper()
+
+	if blueprints := server.Blueprints(); len(blueprints) > 0 {
+		t.Errorf("blueprints left behind: %v", blueprints)
+	}
+	if composes := server.Composes(); len(composes) > 0 {
+		t.Errorf("%d composes left behind", len(composes))
+	}
+}
+
+// checkRequested checks that a request with the given prefix, e.g.
+// "DELETE /api/v0/compose/cancel/", was sent to the server
+func checkRequested(t *testing.T, server *weldrtest.Server, prefix string) {
+	t.Helper()
+
+	for _, request := range server.Requests() {
+		if strings.HasPrefix(request, prefix) {
+			return
+		}
+	}
+	t.Errorf("no %s request was sent, requests: %v", prefix, server.Requests())
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("cannot read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestProcessSuccessful(t *testing.T) {
+	server := weldrtest.NewServer()
+	defer server.Close()
+
+	request, remove := newTestRequest(t, server)
+	defer remove()
+
+	var events []weldr_image.EventType
+	request.Observer = weldr_image.ObserverFunc(func(event weldr_image.Event) {
+		events = append(events, event.Type)
+	})
+
+	results, err := request.ProcessContext(context.Background())
+	if err != nil {
+		t.Fatalf("the request failed: %v", err)
+	}
+
+	if len(results) != 1 || results[0].Err != nil || results[0].BlueprintName != "test" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if state := results[0].Compose.QueueStatus; state != common.IBFinished {
+		t.Errorf("the compose is %s, expected it to be finished", state.ToString())
+	}
+
+	image := request.Images[0]
+	if got := readFile(t, image.Path); got != string(server.Image) {
+		t.Errorf("unexpected image %q", got)
+	}
+	if got := readFile(t, image.ManifestPath); got != string(server.Manifest) {
+		t.Errorf("unexpected manifest %q", got)
+	}
+	if got := readFile(t, image.LogPath); got != string(server.Log) {
+		t.Errorf("unexpected log %q", got)
+	}
+
+	partials, _ := filepath.Glob(image.Path + "*.part")
+	if len(partials) > 0 {
+		t.Errorf("partial files left behind: %v", partials)
+	}
+
+	checkCleanedUp(t, server)
+
+	if len(events) == 0 || events[0] != weldr_image.EventBlueprintPushed || events[len(events)-1] != weldr_image.EventCleanupDone {
+		t.Errorf("unexpected events: %v", events)
+	}
+}
+
+func TestProcessComposeFailed(t *testing.T) {
+	server := weldrtest.NewServer()
+	defer server.Close()
+
+	server.SetStates("qcow2", common.IBWaiting, common.IBRunning, common.IBFailed)
+
+	request, remove := newTestRequest(t, server)
+	defer remove()
+
+	results, err := request.ProcessContext(context.Background())
+	if _, ok := err.(*weldr_image.ImagesError); !ok {
+		t.Fatalf("expected an *ImagesError, got %v", err)
+	}
+
+	composeError, ok := results[0].Err.(*weldr_image.ComposeError)
+	if !ok {
+		t.Fatalf("expected a *ComposeError, got %v", results[0].Err)
+	}
+	if composeError.Log != string(server.Log) {
+		t.Errorf("unexpected log %q", composeError.Log)
+	}
+
+	if _, err := os.Stat(request.Images[0].Path); !os.IsNotExist(err) {
+		t.Errorf("the image of a failed compose was written")
+	}
+
+	checkCleanedUp(t, server)
+}
+
+func TestProcessCancelled(t *testing.T) {
+	tests := []struct {
+		name string
+		// cancelOn is the event the request is cancelled on
+		cancelOn weldr_image.EventType
+		// cancelled is true if the compose is still unfinished then
+		cancelled bool
+	}{
+		{"once queued", weldr_image.EventComposeQueued, true},
+		{"while waiting", weldr_image.EventComposeStateChanged, true},
+		{"while downloading", weldr_image.EventDownloadStarted, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := weldrtest.NewServer()
+			defer server.Close()
+
+			if test.cancelled {
+				// the compose never finishes
+				server.SetStates("qcow2", common.IBWaiting, common.IBRunning)
+			}
+
+			request, remove := newTestRequest(t, server)
+			defer remove()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			request.Observer = weldr_image.ObserverFunc(func(event weldr_image.Event) {
+				if event.Type == test.cancelOn {
+					cancel()
+				}
+			})
+
+			results, err := request.ProcessContext(ctx)
+			if err == nil {
+				t.Fatal("a cancelled request succeeded")
+			}
+			if !strings.Contains(results[0].Err.Error(), "interrupted") {
+				t.Errorf("unexpected error: %v", results[0].Err)
+			}
+
+			id := results[0].ComposeID.String()
+			if test.cancelled {
+				checkRequested(t, server, "DELETE /api/v0/compose/cancel/"+id)
+			}
+			checkRequested(t, server, "DELETE /api/v0/compose/delete/"+id)
+			checkCleanedUp(t, server)
+		})
+	}
+}
+
+func TestProcessInjectedErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   string
+		failure weldrtest.Failure
+		// succeeds is true if the request recovers from the failure
+		succeeds bool
+	}{
+		{"blueprint push", "blueprints/new", weldrtest.Failure{Status: http.StatusInternalServerError}, false},
+		{"depsolve", "blueprints/depsolve", weldrtest.Failure{Status: http.StatusInternalServerError}, false},
+		{"compose start", "compose", weldrtest.Failure{Status: http.StatusBadRequest}, false},
+		{"compose status", "compose/status", weldrtest.Failure{Status: http.StatusInternalServerError}, false},
+		{"truncated image", "compose/image", weldrtest.Failure{Truncate: 4}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := weldrtest.NewServer()
+			defer server.Close()
+
+			server.FailNext(test.route, test.failure)
+
+			request, remove := newTestRequest(t, server)
+			defer remove()
+
+			_, err := request.ProcessContext(context.Background())
+			if test.succeeds {
+				if err != nil {
+					t.Fatalf("the request failed: %v", err)
+				}
+				if got := readFile(t, request.Images[0].Path); got != string(server.Image) {
+					t.Errorf("unexpected image %q", got)
+				}
+			} else if err == nil {
+				t.Fatal("the request succeeded despite the failure")
+			}
+
+			// a compose stuck in a non-final state cannot be deleted
+			for _, compose := range server.Composes() {
+				if compose.QueueStatus == common.IBFinished || compose.QueueStatus == common.IBFailed {
+					t.Errorf("compose %s left behind", compose.ID)
+				}
+			}
+			if blueprints := server.Blueprints(); len(blueprints) > 0 {
+				t.Errorf("blueprints left behind: %v", blueprints)
+			}
+		})
+	}
+}
+
+// rangeRecorder records the Range headers of the image requests
+type rangeRecorder struct {
+	next   http.RoundTripper
+	mu     sync.Mutex
+	ranges []string
+}
+
+func (r *rangeRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.Contains(req.URL.Path, "/compose/image/") {
+		r.mu.Lock()
+		r.ranges = append(r.ranges, req.Header.Get("Range"))
+		r.mu.Unlock()
+	}
+	return r.next.RoundTrip(req)
+}
+
+func TestProcessResumesDownload(t *testing.T) {
+	server := weldrtest.NewServer()
+	defer server.Close()
+
+	server.FailNext("compose/image", weldrtest.Failure{Truncate: 4})
+
+	request, remove := newTestRequest(t, server)
+	defer remove()
+
+	// the retrying client of a real endpoint, the download is resumed once
+	// and only by the request
+	endpoint := weldr_image.Endpoint{
+		Address: server.Address(),
+		Retry:   weldr_image.RetryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	}
+	c, err := endpoint.NewClient()
+	if err != nil {
+		t.Fatalf("cannot create the client: %v", err)
+	}
+	recorder := &rangeRecorder{next: c.Transport}
+	c.Transport = recorder
+	request.Client = c
+
+	if _, err := request.ProcessContext(context.Background()); err != nil {
+		t.Fatalf("the request failed: %v", err)
+	}
+	if got := readFile(t, request.Images[0].Path); got != string(server.Image) {
+		t.Errorf("unexpected image %q", got)
+	}
+
+	expected := []string{"", "bytes=4-"}
+	if !reflect.DeepEqual(recorder.ranges, expected) {
+		t.Errorf("unexpected image requests with the ranges %q, expected %q", recorder.ranges, expected)
+	}
+
+	checkCleanedUp(t, server)
+}
+
+func TestProcessRemovesPartialImage(t *testing.T) {
+	server := weldrtest.NewServer()
+	defer server.Close()
+
+	// every download attempt is interrupted after a byte, the attempts run
+	// out before the image is complete
+	for i := 0; i < 10; i++ {
+		server.FailNext("compose/image", weldrtest.Failure{Truncate: 1})
+	}
+
+	request, remove := newTestRequest(t, server)
+	defer remove()
+
+	_, err := request.ProcessContext(context.Background())
+	if err == nil {
+		t.Fatal("the request succeeded despite the interrupted downloads")
+	}
+
+	partials, _ := filepath.Glob(request.Images[0].Path + "*.part")
+	if len(partials) > 0 {
+		t.Errorf("partial files left behind: %v", partials)
+	}
+
+	checkCleanedUp(t, server)
+}
+
+func TestProcessIgnoresDiskSize(t *testing.T) {
+	// the image size of a compose is the size of its disk, the downloaded
+	// image file is smaller
+	for _, size := range []uint64{0, 4 * 1024 * 1024 * 1024} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			server := weldrtest.NewServer()
+			defer server.Close()
+
+			request, remove := newTestRequest(t, server)
+			defer remove()
+			request.Images[0].Size = size
+
+			if _, err := request.ProcessContext(context.Background()); err != nil {
+				t.Fatalf("the request failed: %v", err)
+			}
+			if got := readFile(t, request.Images[0].Path); got != string(server.Image) {
+				t.Errorf("unexpected image %q", got)
+			}
+
+			checkCleanedUp(t, server)
+		})
+	}
+}
+
+func TestProcessDetached(t *testing.T) {
+	tests := []struct {
+		name string
+		// failure is injected into the compose start
+		failure *weldrtest.Failure
+	}{
+		{"started", nil},
+		{"failed to start", &weldrtest.Failure{Status: http.StatusBadRequest}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := weldrtest.NewServer()
+			defer server.Close()
+
+			if test.failure != nil {
+				server.FailNext("compose", *test.failure)
+			}
+
+			request, remove := newTestRequest(t, server)
+			defer remove()
+			request.Detach = true
+
+			results, err := request.ProcessContext(context.Background())
+
+			if test.failure != nil {
+				if err == nil {
+					t.Fatal("the request succeeded despite the failure")
+				}
+				// nothing needs the blueprint then
+				checkCleanedUp(t, server)
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("the request failed: %v", err)
+			}
+			if len(server.Blueprints()) != 1 || len(server.Composes()) != 1 {
+				t.Errorf("the detached compose or its blueprint was deleted")
+			}
+			if results[0].ComposeID.String() != server.Composes()[0].ID.String() {
+				t.Errorf("unexpected compose id %s", results[0].ComposeID)
+			}
+		})
+	}
+}
+
+func TestProcessAttachedNotFound(t *testing.T) {
+	server := weldrtest.NewServer()
+	defer server.Close()
+
+	request, remove := newTestRequest(t, server)
+	defer remove()
+	id := uuid.New()
+	request.Images[0].Type = ""
+	request.Images[0].ComposeID = id
+	request.Blueprint = nil
+
+	_, err := request.ProcessContext(context.Background())
+
+	expected := fmt.Sprintf("compose %s not found", id)
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected the error %q, got %v", expected, err)
+	}
+}
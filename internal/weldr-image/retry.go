@@ -0,0 +1,155 @@
+package weldr_image
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy describes how requests failing with a transient error are
+// retried. The delay between two attempts grows exponentially from
+// InitialBackoff up to MaxBackoff, a random jitter is applied to each delay.
+type RetryPolicy struct {
+	// MaxRetries is the maximal number of retries of one request, 0
+	// disables retrying
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy is suitable for riding out a restart of osbuild-composer
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:     5,
+	InitialBackoff: 500 * time.Millisecond,
+	MaxBackoff:     30 * time.Second,
+}
+
+// backoff returns the delay before the given retry (counted from 0). It uses
+// the "full jitter" strategy: a random delay between 0 and the exponential
+// backoff.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.InitialBackoff
+	for i := 0; i < retry && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+// sleep waits before the given retry, it returns early with an error if the
+// context is done
+func (p RetryPolicy) sleep(ctx context.Context, retry int) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(p.backoff(retry)):
+		return nil
+	}
+}
+
+// newRetryTransport returns a transport retrying transient failures of the
+// requests sent through next according to the policy.
+//
+// Only idempotent requests (GET and HEAD) are retried after connection errors
+// and 5xx responses. Other requests, e.g. posting a new compose, are retried
+// only if the connection to the server (or to the proxy) could not be
+// established at all, so they can never be submitted twice.
+//
+// A failure while reading a response body is not retried here, the image
+// downloads are resumed from their partial files instead, see
+// downloadComposeImage.
+func newRetryTransport(next http.RoundTripper, policy RetryPolicy) http.RoundTripper {
+	return &retryTransport{
+		next:   next,
+		policy: policy,
+	}
+}
+
+type retryTransport struct {
+	next   http.RoundTripper
+	policy RetryPolicy
+}
+
+func isIdempotent(method string) bool {
+	return method == "GET" || method == "HEAD"
+}
+
+// isDialError returns true if the error happened before the request was sent
+// to the server: connecting to it, or to the proxy of the request
+// (http.ProxyFromEnvironment)
+func isDialError(err error) bool {
+	opErr, ok := err.(*net.OpError)
+	return ok && (opErr.Op == "dial" || opErr.Op == "proxyconnect")
+}
+
+func isRetryableStatus(status int) bool {
+	return status == http.StatusInternalServerError ||
+		status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout
+}
+
+// rewind returns a copy of the request with a fresh body so it can be sent
+// again
+func rewind(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("the request body cannot be rewound")
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+
+	rewound := new(http.Request)
+	*rewound = *req
+	rewound.Body = body
+	return rewound, nil
+}
+
+// RoundTrip sends the request and retries it if it's safe to do so
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for retry := 0; ; retry++ {
+		attempt, err := rewind(req)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := t.next.RoundTrip(attempt)
+
+		retryable := false
+		switch {
+		case err != nil:
+			retryable = isDialError(err) || isIdempotent(req.Method)
+		case isRetryableStatus(resp.StatusCode):
+			retryable = isIdempotent(req.Method)
+		}
+
+		if !retryable || retry >= t.policy.MaxRetries {
+			return resp, err
+		}
+
+		if resp != nil {
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if err := t.policy.sleep(req.Context(), retry); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package weldr_image
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{
+		MaxRetries:     10,
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+	}
+
+	limits := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+
+	for retry, limit := range limits {
+		delays := make(map[time.Duration]bool)
+		for i := 0; i < 100; i++ {
+			delay := policy.backoff(retry)
+			if delay <= 0 || delay > limit {
+				t.Fatalf("the delay %v of the retry %d is not in (0, %v]", delay, retry, limit)
+			}
+			delays[delay] = true
+		}
+
+		// the full jitter spreads the delays over the whole range
+		if len(delays) < 50 {
+			t.Errorf("only %d distinct delays of the retry %d", len(delays), retry)
+		}
+	}
+
+	if delay := (RetryPolicy{}).backoff(3); delay != 0 {
+		t.Errorf("unexpected delay %v without a backoff", delay)
+	}
+}
+
+// scriptedTransport answers the requests with the results of its steps in
+// order, it records the bodies of the requests
+type scriptedTransport struct {
+	steps  []func() (*http.Response, error)
+	bodies []string
+}
+
+func (t *scriptedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = ioutil.ReadAll(req.Body)
+	}
+	t.bodies = append(t.bodies, string(body))
+
+	step := t.steps[0]
+	if len(t.steps) > 1 {
+		t.steps = t.steps[1:]
+	}
+	return step()
+}
+
+func respond(status int) func() (*http.Response, error) {
+	return func() (*http.Response, error) {
+		return &http.Response{
+			StatusCode: status,
+			Body:       ioutil.NopCloser(strings.NewReader(http.StatusText(status))),
+		}, nil
+	}
+}
+
+func fail(err error) func() (*http.Response, error) {
+	return func() (*http.Response, error) {
+		return nil, err
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	dialError := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	proxyError := &net.OpError{Op: "proxyconnect", Net: "tcp", Err: errors.New("connection refused")}
+	// the connection was lost after the request was sent
+	sentError := io.ErrUnexpectedEOF
+
+	tests := []struct {
+		name   string
+		method string
+		steps  []func() (*http.Response, error)
+		// status is the final status, 0 if the request fails
+		status   int
+		attempts int
+	}{
+		{"get after 5xx", "GET", []func() (*http.Response, error){respond(503), respond(502), respond(200)}, 200, 3},
+		{"get after a lost connection", "GET", []func() (*http.Response, error){fail(sentError), respond(200)}, 200, 2},
+		{"get gives up", "GET", []func() (*http.Response, error){respond(503)}, 503, 4},
+		{"get not after 4xx", "GET", []func() (*http.Response, error){respond(404), respond(200)}, 404, 1},
+		{"post after a dial error", "POST", []func() (*http.Response, error){fail(dialError), respond(200)}, 200, 2},
+		{"post after a proxy error", "POST", []func() (*http.Response, error){fail(proxyError), respond(200)}, 200, 2},
+		{"post not after 5xx", "POST", []func() (*http.Response, error){respond(503), respond(200)}, 503, 1},
+		{"post not after a lost connection", "POST", []func() (*http.Response, error){fail(sentError), respond(200)}, 0, 1},
+	}
+
+	policy := RetryPolicy{
+		MaxRetries:     3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     2 * time.Millisecond,
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next := &scriptedTransport{steps: test.steps}
+			c := &http.Client{Transport: newRetryTransport(next, policy)}
+
+			req, err := http.NewRequest(test.method, "http://composer.example.com/api/v0/compose", strings.NewReader(`{"blueprint_name":"test"}`))
+			if err != nil {
+				t.Fatalf("cannot create the request: %v", err)
+			}
+
+			resp, err := c.Do(req)
+			switch {
+			case test.status == 0 && err == nil:
+				t.Errorf("the request succeeded with the status %d", resp.StatusCode)
+			case test.status != 0 && err != nil:
+				t.Errorf("the request failed: %v", err)
+			case test.status != 0 && resp.StatusCode != test.status:
+				t.Errorf("unexpected status %d, expected %d", resp.StatusCode, test.status)
+			}
+			if resp != nil {
+				resp.Body.Close()
+			}
+
+			if len(next.bodies) != test.attempts {
+				t.Errorf("the request was sent %d times, expected %d", len(next.bodies), test.attempts)
+			}
+			for i, body := range next.bodies {
+				if body != `{"blueprint_name":"test"}` {
+					t.Errorf("unexpected body %q of the attempt %d", body, i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryTransportUnreachableProxy(t *testing.T) {
+	// a closed port, connecting to it is refused
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+	proxy := listener.Addr().String()
+	listener.Close()
+
+	var dials int
+	dialer := &net.Dialer{}
+	next := &http.Transport{
+		Proxy: func(*http.Request) (*url.URL, error) {
+			return &url.URL{Scheme: "http", Host: proxy}, nil
+		},
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dials++
+			return dialer.DialContext(ctx, network, address)
+		},
+	}
+
+	policy := RetryPolicy{MaxRetries: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	c := &http.Client{Transport: newRetryTransport(next, policy)}
+
+	resp, err := c.Post("http://composer.example.com/api/v0/compose", "application/json", strings.NewReader("{}"))
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("a request through an unreachable proxy succeeded")
+	}
+	if dials != 3 {
+		t.Errorf("the proxy was dialed %d times, expected 3", dials)
+	}
+}
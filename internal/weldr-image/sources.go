@@ -0,0 +1,129 @@
+package weldr_image
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/client"
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/weldr"
+)
+
+// pushSources registers the request's sources before the blueprint is pushed,
+// so they're used to resolve its packages. The names of the registered
+// sources are remembered even if pushing one of them fails, so they can be
+// cleaned up.
+func (h *requestHandler) pushSources(ctx context.Context) error {
+	if len(h.request.Sources) == 0 {
+		return nil
+	}
+
+	existing, err := h.backend.Sources(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, source := range h.request.Sources {
+		name, err := SourceName(source)
+		if err != nil {
+			return err
+		}
+
+		// the source is deleted afterwards, so it must not replace one
+		// that was already there
+		for _, e := range existing {
+			if e == name {
+				return fmt.Errorf("the source %s already exists in osbuild-composer", name)
+			}
+		}
+
+		_, err = h.backend.PushSource(ctx, source)
+		if err != nil {
+			return err
+		}
+		existing = append(existing, name)
+		h.sourceNames = append(h.sourceNames, name)
+
+		h.emit(Event{Type: EventSourceAdded, SourceName: name})
+	}
+
+	return nil
+}
+
+// PushSource registers a json or toml source in osbuild-composer and returns
+// its name. An existing source with the same name is replaced.
+func PushSource(ctx context.Context, c *http.Client, source []byte) (string, error) {
+	name, isTOML, err := loadSource(source)
+	if err != nil {
+		return "", err
+	}
+
+	var response *client.APIResponse
+	if isTOML {
+		response, err = client.PostTOMLSourceV0(ctx, c, string(source))
+	} else {
+		response, err = client.PostJSONSourceV0(ctx, c, string(source))
+	}
+	if err := TranslateError(response, err); err != nil {
+		return "", &APIError{
+			Message: "cannot add the source " + name,
+			Cause:   err,
+		}
+	}
+
+	return name, nil
+}
+
+// DeleteSource deletes the named source from osbuild-composer
+func DeleteSource(ctx context.Context, c *http.Client, name string) error {
+	response, err := client.DeleteSourceV0(ctx, c, name)
+	if err := TranslateError(response, err); err != nil {
+		return &APIError{
+			Message: "cannot delete the source " + name,
+			Cause:   err,
+		}
+	}
+
+	return nil
+}
+
+// SourceName returns the name of a json or toml source
+func SourceName(source []byte) (string, error) {
+	name, _, err := loadSource(source)
+	return name, err
+}
+
+func loadSource(rawSource []byte) (string, bool, error) {
+	source, isTOML, err := decodeSource(rawSource)
+	if err != nil {
+		return "", false, err
+	}
+
+	return source.Name, isTOML, nil
+}
+
+// decodeSource decodes a json or toml source, it returns true if the source
+// is toml
+func decodeSource(rawSource []byte) (weldr.SourceConfigV0, bool, error) {
+	var source weldr.SourceConfigV0
+	isTOML := false
+	err := json.Unmarshal(rawSource, &source)
+
+	if err != nil {
+		err := toml.Unmarshal(rawSource, &source)
+		isTOML = true
+		if err != nil {
+			return weldr.SourceConfigV0{}, false, fmt.Errorf("cannot unmarshal the source, it's not json nor toml")
+		}
+	}
+
+	if source.Name == "" {
+		return weldr.SourceConfigV0{}, false, errors.New("the source has no name")
+	}
+
+	return source, isTOML, nil
+}
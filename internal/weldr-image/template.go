@@ -0,0 +1,425 @@
+package weldr_image
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// BlueprintLoader loads blueprint files which are templates: the string values
+// can reference variables as ${NAME} ($$ is a literal $), and a blueprint can
+// include other blueprint files it's merged on top of, e.g.
+//
+//	name = "web-${ENV}"
+//	include = ["base.toml", "users/${ENV}.toml"]
+//
+//	[customizations]
+//	hostname = "web.${DOMAIN}"
+//
+// The included files are loaded the same way, their paths are relative to the
+// including file. They're merged in order and the including blueprint last,
+// see MergeBlueprints. Only the resulting blueprint needs a name. A blueprint
+// with keys unknown to the model can neither include nor be included.
+type BlueprintLoader struct {
+	// Vars are the values of the variables
+	Vars map[string]string
+	// LookupEnv looks up the variables missing in Vars, e.g. os.LookupEnv,
+	// the environment is not used if it's nil
+	LookupEnv func(name string) (string, bool)
+}
+
+// LoadFile loads the blueprint file with its variables substituted and its
+// includes merged. The problems of a file are reported as a *BlueprintError.
+func (l *BlueprintLoader) LoadFile(path string) (*Blueprint, error) {
+	blueprint, err := l.load(path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if blueprint.Name == "" {
+		return nil, fmt.Errorf("the blueprint %s has no name, neither it nor its includes set one", path)
+	}
+
+	return blueprint, nil
+}
+
+// load loads a blueprint file and the files it includes, stack are the files
+// including it
+func (l *BlueprintLoader) load(path string, stack []string) (*Blueprint, error) {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve the blueprint path %s: %v", path, err)
+	}
+	for _, including := range stack {
+		if including == absolute {
+			return nil, fmt.Errorf("the blueprint %s includes itself through %s", path, strings.Join(stack, " -> "))
+		}
+	}
+	stack = append(stack, absolute)
+
+	rawBlueprint, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the blueprint file: %v", err)
+	}
+
+	blueprint, isTOML, err := decodeBlueprint(rawBlueprint, decodeOptions{partial: true, lenient: true})
+	if blueprintError, ok := err.(*BlueprintError); ok {
+		blueprintError.File = path
+		return nil, blueprintError
+	} else if err != nil {
+		return nil, err
+	}
+
+	locate := func(problems []BlueprintProblem) error {
+		var positions map[string]position
+		if isTOML {
+			positions = tomlKeyPositions(string(rawBlueprint))
+		} else {
+			positions = jsonKeyPositions(rawBlueprint)
+		}
+		return &BlueprintError{
+			File:     path,
+			IsTOML:   isTOML,
+			Problems: locateProblems(problems, positions),
+		}
+	}
+
+	// the unknown keys cannot be merged, so they're allowed only in a
+	// blueprint that neither includes nor is included
+	if len(blueprint.unknown) > 0 && (len(blueprint.Include) > 0 || len(stack) > 1) {
+		var problems []BlueprintProblem
+		for _, key := range blueprint.unknown {
+			problems = append(problems, BlueprintProblem{
+				Key:     key,
+				Message: "unknown key, it cannot be merged with the included or including blueprints",
+			})
+		}
+		return nil, locate(problems)
+	}
+
+	substitution := variableSubstitution{lookup: l.lookup}
+	if blueprint.document != nil {
+		// the document has all the keys of the model, the problems are
+		// collected there so they're reported once
+		modelSubstitution := variableSubstitution{lookup: l.lookup}
+		modelSubstitution.walk("", reflect.ValueOf(blueprint).Elem())
+		blueprint.document = substitution.walkDocument("", blueprint.document).(map[string]interface{})
+	} else {
+		substitution.walk("", reflect.ValueOf(blueprint).Elem())
+	}
+	if len(substitution.problems) > 0 {
+		return nil, locate(substitution.problems)
+	}
+
+	merged := &Blueprint{}
+	for _, include := range blueprint.Include {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+
+		included, err := l.load(include, stack)
+		if err != nil {
+			return nil, err
+		}
+		merged = MergeBlueprints(merged, included)
+	}
+
+	blueprint.Include = nil
+	if blueprint.document != nil {
+		delete(blueprint.document, "include")
+	}
+	return MergeBlueprints(merged, blueprint), nil
+}
+
+func (l *BlueprintLoader) lookup(name string) (string, bool) {
+	if value, ok := l.Vars[name]; ok {
+		return value, true
+	}
+	if l.LookupEnv != nil {
+		return l.LookupEnv(name)
+	}
+	return "", false
+}
+
+// variableReference matches the variable references and the escaped dollar
+// signs, an unterminated or invalid reference is matched as ${ alone
+var variableReference = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)\}|\$\{`)
+
+// variableSubstitution substitutes the variables in all the strings of a
+// blueprint and collects the undefined ones
+type variableSubstitution struct {
+	lookup   func(name string) (string, bool)
+	problems []BlueprintProblem
+}
+
+func (s *variableSubstitution) substitute(key, value string) string {
+	return variableReference.ReplaceAllStringFunc(value, func(reference string) string {
+		switch reference {
+		case "$$":
+			return "$"
+		case "${":
+			s.problems = append(s.problems, BlueprintProblem{
+				Key:     key,
+				Message: "invalid variable reference, expected ${NAME} (use $$ for a literal $)",
+			})
+			return reference
+		}
+
+		name := reference[2 : len(reference)-1]
+		substituted, ok := s.lookup(name)
+		if !ok {
+			s.problems = append(s.problems, BlueprintProblem{
+				Key:     key,
+				Message: fmt.Sprintf("undefined variable %s", name),
+			})
+		}
+		return substituted
+	})
+}
+
+// walk substitutes the variables in the strings of v, key is its path
+func (s *variableSubstitution) walk(key string, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			s.walk(key, v.Elem())
+		}
+
+	case reflect.Interface:
+		if str, ok := v.Interface().(string); ok {
+			v.Set(reflect.ValueOf(s.substitute(key, str)))
+		}
+
+	case reflect.String:
+		v.SetString(s.substitute(key, v.String()))
+
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			s.walk(fmt.Sprintf("%s[%d]", key, i), v.Index(i))
+		}
+
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			name := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+			s.walk(joinKey(key, name), v.Field(i))
+		}
+	}
+}
+
+// walkDocument substitutes the variables in all the strings of a decoded
+// json or toml document and returns the substituted copy
+func (s *variableSubstitution) walkDocument(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return s.substitute(key, v)
+
+	case map[string]interface{}:
+		substituted := make(map[string]interface{}, len(v))
+		for name, value := range v {
+			substituted[name] = s.walkDocument(joinKey(key, name), value)
+		}
+		return substituted
+
+	case []interface{}:
+		substituted := make([]interface{}, len(v))
+		for i, value := range v {
+			substituted[i] = s.walkDocument(fmt.Sprintf("%s[%d]", key, i), value)
+		}
+		return substituted
+
+	case []map[string]interface{}:
+		substituted := make([]map[string]interface{}, len(v))
+		for i, value := range v {
+			substituted[i] = s.walkDocument(fmt.Sprintf("%s[%d]", key, i), value).(map[string]interface{})
+		}
+		return substituted
+	}
+
+	return value
+}
+
+// MergeBlueprints returns the overlay blueprint merged on top of the base
+// one, neither of them is modified:
+//
+//   - strings, numbers and single customizations (e.g. the hostname) of the
+//     overlay replace the ones of the base if they're set
+//   - tables (e.g. customizations.kernel) are merged key by key
+//   - lists of strings (e.g. the enabled services) are joined, without
+//     duplicates
+//   - lists of tables are merged by their first key (e.g. the name of a
+//     package or a user, the mountpoint of a filesystem): an entry of the
+//     overlay replaces the base entry with the same key, or it's appended
+//
+// The keys unknown to the model cannot be merged, a blueprint having them can
+// only be merged with an empty one.
+func MergeBlueprints(base, overlay *Blueprint) *Blueprint {
+	merged := mergeValues(reflect.ValueOf(base).Elem(), reflect.ValueOf(overlay).Elem())
+	blueprint := merged.Interface().(Blueprint)
+
+	blueprint.unknown = append(append([]string(nil), base.unknown...), overlay.unknown...)
+	switch {
+	case reflect.DeepEqual(*base, Blueprint{}):
+		blueprint.document = overlay.document
+	case reflect.DeepEqual(*overlay, Blueprint{}):
+		blueprint.document = base.document
+	}
+	return &blueprint
+}
+
+// updateExistingUsers returns the overlay with its users present in the base
+// blueprint merged key by key on top of them, so e.g. a user with only a key
+// changes the key of the existing user instead of replacing the whole user
+func updateExistingUsers(base, overlay *Blueprint) *Blueprint {
+	if base.Customizations == nil || overlay.Customizations == nil || len(overlay.Customizations.User) == 0 {
+		return overlay
+	}
+
+	customizations := *overlay.Customizations
+	customizations.User = append([]UserCustomization(nil), customizations.User...)
+	for i, user := range customizations.User {
+		for _, existing := range base.Customizations.User {
+			if existing.Name == user.Name {
+				merged := mergeValues(reflect.ValueOf(existing), reflect.ValueOf(user))
+				customizations.User[i] = merged.Interface().(UserCustomization)
+				break
+			}
+		}
+	}
+
+	updated := *overlay
+	updated.Customizations = &customizations
+	return &updated
+}
+
+func mergeValues(base, overlay reflect.Value) reflect.Value {
+	merged := reflect.New(base.Type()).Elem()
+
+	switch base.Kind() {
+	case reflect.Ptr:
+		switch {
+		case overlay.IsNil():
+			merged.Set(base)
+		case base.IsNil() || base.Elem().Kind() != reflect.Struct:
+			merged.Set(overlay)
+		default:
+			merged.Set(reflect.New(base.Type().Elem()))
+			merged.Elem().Set(mergeValues(base.Elem(), overlay.Elem()))
+		}
+
+	case reflect.Struct:
+		for i := 0; i < base.NumField(); i++ {
+			if base.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			merged.Field(i).Set(mergeValues(base.Field(i), overlay.Field(i)))
+		}
+
+	case reflect.Slice:
+		merged.Set(mergeSlices(base, overlay))
+
+	default:
+		if isZeroValue(overlay) {
+			merged.Set(base)
+		} else {
+			merged.Set(overlay)
+		}
+	}
+
+	return merged
+}
+
+// mergeSlices joins two lists, the entries are identified by their value or
+// by the first field of their struct
+func mergeSlices(base, overlay reflect.Value) reflect.Value {
+	if base.Len() == 0 {
+		return overlay
+	}
+	if overlay.Len() == 0 {
+		return base
+	}
+
+	identity := func(v reflect.Value) interface{} {
+		if v.Kind() == reflect.Struct {
+			return v.Field(0).Interface()
+		}
+		return v.Interface()
+	}
+
+	merged := reflect.AppendSlice(reflect.MakeSlice(base.Type(), 0, base.Len()+overlay.Len()), base)
+	for i := 0; i < overlay.Len(); i++ {
+		entry := overlay.Index(i)
+		replaced := false
+		for j := 0; j < merged.Len(); j++ {
+			if reflect.DeepEqual(identity(merged.Index(j)), identity(entry)) {
+				merged.Index(j).Set(entry)
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = reflect.Append(merged, entry)
+		}
+	}
+	return merged
+}
+
+func isZeroValue(v reflect.Value) bool {
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+// ParseVars parses KEY=VALUE lines, e.g. of a --var-file. Empty lines and
+// lines starting with # are skipped, the value can be quoted.
+func ParseVars(data []byte) (map[string]string, error) {
+	vars := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+
+		name, value, err := ParseVar(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		vars[name] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// variableName matches the valid names of variables
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParseVar parses a KEY=VALUE assignment of a variable, the value can be
+// quoted
+func ParseVar(assignment string) (string, string, error) {
+	i := strings.Index(assignment, "=")
+	if i < 0 {
+		return "", "", errors.New("expected KEY=VALUE")
+	}
+
+	name := strings.TrimSpace(assignment[:i])
+	if !variableName.MatchString(name) {
+		return "", "", fmt.Errorf("invalid variable name %q, it must start with a letter or an underscore followed by letters, digits or underscores", name)
+	}
+
+	value := strings.TrimSpace(assignment[i+1:])
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = value[1 : len(value)-1]
+	}
+	return name, value, nil
+}
@@ -0,0 +1,286 @@
+package weldr_image_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ondrejbudai/osbuild-image/internal/weldr-image"
+)
+
+// writeTestBlueprints writes the files to a temporary directory, the
+// directory is removed by the returned function
+func writeTestBlueprints(t *testing.T, files map[string]string) (string, func()) {
+	dir, err := ioutil.TempDir("", "weldr-image-test")
+	if err != nil {
+		t.Fatalf("cannot create a temporary directory: %v", err)
+	}
+	remove := func() { os.RemoveAll(dir) }
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			remove()
+			t.Fatalf("cannot create the directory of %s: %v", name, err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			remove()
+			t.Fatalf("cannot write %s: %v", name, err)
+		}
+	}
+
+	return dir, remove
+}
+
+func TestBlueprintLoaderVariables(t *testing.T) {
+	env := map[string]string{"DOMAIN": "example.com", "ENV": "env"}
+	loader := &weldr_image.BlueprintLoader{
+		Vars: map[string]string{"ENV": "prod", "EMPTY": ""},
+		LookupEnv: func(name string) (string, bool) {
+			value, ok := env[name]
+			return value, ok
+		},
+	}
+
+	tests := []struct {
+		name     string
+		hostname string
+		expected string
+		// problem is the message of the expected problem, the hostname is
+		// expected to be substituted if it's empty
+		problem string
+	}{
+		{"var", "web-${ENV}", "web-prod", ""},
+		{"env", "web.${DOMAIN}", "web.example.com", ""},
+		{"empty", "web${EMPTY}", "web", ""},
+		{"several", "${ENV}.${DOMAIN}", "prod.example.com", ""},
+		{"escaped", "cost$$", "cost$", ""},
+		{"escaped reference", "$${ENV}", "${ENV}", ""},
+		{"lone dollar", "$ENV", "$ENV", ""},
+		{"undefined", "web-${UNDEFINED}", "", "undefined variable UNDEFINED"},
+		{"unterminated", "web-${ENV", "", "invalid variable reference"},
+		{"invalid name", "web-${1ENV}", "", "invalid variable reference"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, remove := writeTestBlueprints(t, map[string]string{
+				"bp.toml": "name = \"test\"\n\n[customizations]\nhostname = \"" + test.hostname + "\"\n",
+			})
+			defer remove()
+			path := filepath.Join(dir, "bp.toml")
+
+			blueprint, err := loader.LoadFile(path)
+
+			if test.problem == "" {
+				if err != nil {
+					t.Fatalf("cannot load the blueprint: %v", err)
+				}
+				if hostname := *blueprint.Customizations.Hostname; hostname != test.expected {
+					t.Errorf("unexpected hostname %q, expected %q", hostname, test.expected)
+				}
+				return
+			}
+
+			blueprintError, ok := err.(*weldr_image.BlueprintError)
+			if !ok || blueprintError.File != path || len(blueprintError.Problems) != 1 {
+				t.Fatalf("expected one problem of %s, got %v", path, err)
+			}
+			problem := blueprintError.Problems[0]
+			if problem.Key != "customizations.hostname" || problem.Line != 4 || !strings.Contains(problem.Message, test.problem) {
+				t.Errorf("unexpected problem %v, expected %q at customizations.hostname", problem, test.problem)
+			}
+		})
+	}
+}
+
+func TestBlueprintLoaderIncludes(t *testing.T) {
+	dir, remove := writeTestBlueprints(t, map[string]string{
+		"web.toml": `
+name = "web"
+include = ["common/base.toml", "common/${ENV}.toml"]
+
+[[packages]]
+name = "nginx"
+version = "1.*"
+`,
+		// the include is relative to common, not to the including file
+		"common/base.toml": `
+include = ["packages.toml"]
+
+[customizations]
+hostname = "base"
+`,
+		"common/packages.toml": `
+[[packages]]
+name = "nginx"
+
+[[packages]]
+name = "tmux"
+`,
+		"common/prod.toml": `
+[customizations]
+hostname = "prod"
+
+[customizations.services]
+enabled = ["nginx"]
+`,
+	})
+	defer remove()
+
+	loader := &weldr_image.BlueprintLoader{Vars: map[string]string{"ENV": "prod"}}
+	blueprint, err := loader.LoadFile(filepath.Join(dir, "web.toml"))
+	if err != nil {
+		t.Fatalf("cannot load the blueprint: %v", err)
+	}
+
+	hostname := "prod"
+	expected := &weldr_image.Blueprint{
+		Name: "web",
+		Packages: []weldr_image.Package{
+			{Name: "nginx", Version: "1.*"},
+			{Name: "tmux"},
+		},
+		Customizations: &weldr_image.Customizations{
+			Hostname: &hostname,
+			Services: &weldr_image.ServicesCustomization{Enabled: []string{"nginx"}},
+		},
+	}
+	if !reflect.DeepEqual(blueprint, expected) {
+		t.Errorf("unexpected blueprint %+v, expected %+v", blueprint, expected)
+	}
+}
+
+func TestBlueprintLoaderIncludeErrors(t *testing.T) {
+	dir, remove := writeTestBlueprints(t, map[string]string{
+		"self.toml":    "name = \"self\"\ninclude = [\"self.toml\"]\n",
+		"a.toml":       "name = \"a\"\ninclude = [\"sub/b.toml\"]\n",
+		"sub/b.toml":   "include = [\"../a.toml\"]\n",
+		"missing.toml": "name = \"missing\"\ninclude = [\"nonexistent.toml\"]\n",
+		"noname.toml":  "include = [\"sub/c.toml\"]\n",
+		"sub/c.toml":   "[[packages]]\nname = \"tmux\"\n",
+	})
+	defer remove()
+
+	tests := []struct {
+		file    string
+		message string
+	}{
+		{"self.toml", "includes itself"},
+		{"a.toml", "includes itself"},
+		{"missing.toml", "cannot read the blueprint file"},
+		{"noname.toml", "has no name"},
+	}
+
+	loader := &weldr_image.BlueprintLoader{}
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			_, err := loader.LoadFile(filepath.Join(dir, test.file))
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Errorf("expected an error containing %q, got %v", test.message, err)
+			}
+		})
+	}
+}
+
+func TestParseVars(t *testing.T) {
+	vars, err := weldr_image.ParseVars([]byte(`
+# the environment
+ENV=prod
+  DOMAIN = example.com
+QUOTED="two words"
+SINGLE='$$'
+EMPTY=
+WITH_EQUALS=a=b
+`))
+	if err != nil {
+		t.Fatalf("cannot parse the variables: %v", err)
+	}
+
+	expected := map[string]string{
+		"ENV":         "prod",
+		"DOMAIN":      "example.com",
+		"QUOTED":      "two words",
+		"SINGLE":      "$$",
+		"EMPTY":       "",
+		"WITH_EQUALS": "a=b",
+	}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Errorf("unexpected variables %v, expected %v", vars, expected)
+	}
+
+	for data, message := range map[string]string{
+		"ENV=prod\nNOVALUE\n":     "line 2: expected KEY=VALUE",
+		"\n\n1ENV=prod\n":         "line 3: invalid variable name",
+		"ENV=prod\nMY-VAR=prod\n": "line 2: invalid variable name",
+	} {
+		_, err := weldr_image.ParseVars([]byte(data))
+		if err == nil || !strings.HasPrefix(err.Error(), message) {
+			t.Errorf("expected an error starting with %q for %q, got %v", message, data, err)
+		}
+	}
+}
+
+func TestMergeBlueprints(t *testing.T) {
+	baseHostname := "base"
+	overlayHostname := "overlay"
+	uid := 1042
+	base := &weldr_image.Blueprint{
+		Name:     "base",
+		Version:  "0.0.1",
+		Packages: []weldr_image.Package{{Name: "tmux"}, {Name: "vim"}, {Name: "git"}},
+		Customizations: &weldr_image.Customizations{
+			Hostname: &baseHostname,
+			User:     []weldr_image.UserCustomization{{Name: "admin", UID: &uid}, {Name: "guest"}},
+			Services: &weldr_image.ServicesCustomization{Enabled: []string{"sshd", "chronyd"}},
+		},
+	}
+	overlay := &weldr_image.Blueprint{
+		Name:     "overlay",
+		Packages: []weldr_image.Package{{Name: "strace"}, {Name: "vim", Version: "9.*"}, {Name: "htop"}},
+		Customizations: &weldr_image.Customizations{
+			Hostname: &overlayHostname,
+			User:     []weldr_image.UserCustomization{{Name: "admin"}},
+			Services: &weldr_image.ServicesCustomization{Enabled: []string{"nginx", "sshd"}, Masked: []string{"cups"}},
+		},
+	}
+
+	// the base entries keep their order, the new overlay entries follow in
+	// their own order
+	expected := &weldr_image.Blueprint{
+		Name:    "overlay",
+		Version: "0.0.1",
+		Packages: []weldr_image.Package{
+			{Name: "tmux"},
+			{Name: "vim", Version: "9.*"},
+			{Name: "git"},
+			{Name: "strace"},
+			{Name: "htop"},
+		},
+		Customizations: &weldr_image.Customizations{
+			Hostname: &overlayHostname,
+			User:     []weldr_image.UserCustomization{{Name: "admin"}, {Name: "guest"}},
+			Services: &weldr_image.ServicesCustomization{
+				Enabled: []string{"sshd", "chronyd", "nginx"},
+				Masked:  []string{"cups"},
+			},
+		},
+	}
+
+	for i := 0; i < 20; i++ {
+		merged := weldr_image.MergeBlueprints(base, overlay)
+		if !reflect.DeepEqual(merged, expected) {
+			t.Fatalf("unexpected merged blueprint %+v, expected %+v", merged, expected)
+		}
+	}
+
+	if base.Name != "base" || len(base.Packages) != 3 || base.Packages[1].Version != "" || len(base.Customizations.Services.Enabled) != 2 {
+		t.Errorf("the base blueprint was modified: %+v", base)
+	}
+	if overlay.Version != "" || len(overlay.Packages) != 3 {
+		t.Errorf("the overlay blueprint was modified: %+v", overlay)
+	}
+}
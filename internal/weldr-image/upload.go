@@ -0,0 +1,111 @@
+package weldr_image
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/common"
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/weldr"
+)
+
+// UploadError is returned when osbuild-composer built the image but failed to
+// upload it to the cloud provider
+type UploadError struct {
+	Provider  string
+	ImageName string
+}
+
+func (e *UploadError) Error() string {
+	return fmt.Sprintf("upload of the image %s to %s failed", e.ImageName, e.Provider)
+}
+
+// LoadUploadConfig loads the json or toml configuration of an upload in the
+// format used by composer-cli, e.g.
+//
+//	provider = "aws"
+//	image_name = "my-image"
+//
+//	[settings]
+//	region = "eu-west-1"
+//	bucket = "images"
+//	key = "my-image"
+//	accessKeyID = "..."
+//	secretAccessKey = "..."
+//
+// The settings are decoded according to the provider.
+func LoadUploadConfig(config []byte) (*weldr.UploadRequest, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(config, &raw); err != nil {
+		if _, err := toml.Decode(string(config), &raw); err != nil {
+			return nil, errors.New("cannot unmarshal the upload config, it's not json nor toml")
+		}
+	}
+
+	// toml is converted to json, so the settings are decoded only once, by
+	// weldr.UploadRequest
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("cannot convert the upload config: %v", err)
+	}
+
+	var upload weldr.UploadRequest
+	err = json.Unmarshal(data, &upload)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode the upload config: %v", err)
+	}
+
+	if upload.Provider == "" {
+		return nil, errors.New("the upload config has no provider")
+	}
+
+	return &upload, nil
+}
+
+// uploadsDone returns true if all the uploads either finished or failed
+func uploadsDone(uploads []weldr.UploadResponse) bool {
+	for _, upload := range uploads {
+		if upload.Status != common.IBFinished && upload.Status != common.IBFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// checkUploads returns an *UploadError describing the first failed upload
+func checkUploads(uploads []weldr.UploadResponse) error {
+	for _, upload := range uploads {
+		if upload.Status == common.IBFailed {
+			return &UploadError{
+				Provider:  upload.ProviderName,
+				ImageName: upload.ImageName,
+			}
+		}
+	}
+	return nil
+}
+
+// emitUploadStates emits an event for every upload whose state changed since
+// the last status of the compose, previous maps the upload ids to their
+// states
+func (h *composeHandler) emitUploadStates(uploads []weldr.UploadResponse, previous map[string]common.ImageBuildState) {
+	for _, upload := range uploads {
+		state := upload.Status
+		id := upload.UUID.String()
+
+		previousState, ok := previous[id]
+		if ok && previousState == state {
+			continue
+		}
+
+		event := Event{Type: EventUploadStateChanged, UploadProvider: upload.ProviderName, State: &state}
+		if ok {
+			event.PreviousState = &previousState
+		}
+		h.emit(event)
+
+		previous[id] = state
+	}
+}
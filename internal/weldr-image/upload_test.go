@@ -0,0 +1,173 @@
+package weldr_image_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/common"
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/weldr"
+	"github.com/ondrejbudai/osbuild-image/internal/weldr-image"
+	"github.com/ondrejbudai/osbuild-image/internal/weldrtest"
+)
+
+// newUploadRequest returns a request uploading a qcow2 image to aws instead
+// of downloading it, the upload states are recorded into states
+func newUploadRequest(t *testing.T, server *weldrtest.Server, states *[]string) (*weldr_image.Request, func()) {
+	request, remove := newTestRequest(t, server)
+
+	request.Images[0].Path = ""
+	request.Images[0].Upload = &weldr.UploadRequest{
+		Provider:  "aws",
+		ImageName: "test-image",
+		Settings: weldr.AWSUploadSettings{
+			Region: "eu-west-1",
+			Bucket: "images",
+			Key:    "test-image",
+		},
+	}
+
+	request.Observer = weldr_image.ObserverFunc(func(event weldr_image.Event) {
+		if event.Type != weldr_image.EventUploadStateChanged {
+			return
+		}
+		if event.UploadProvider != "aws" {
+			t.Errorf("unexpected upload provider %q", event.UploadProvider)
+		}
+
+		previous := "none"
+		if event.PreviousState != nil {
+			previous = event.PreviousState.ToString()
+		}
+		*states = append(*states, previous+" -> "+event.State.ToString())
+	})
+
+	return request, remove
+}
+
+func TestProcessUploadFinished(t *testing.T) {
+	server := weldrtest.NewServer()
+	defer server.Close()
+
+	server.SetUploadStates("aws", common.IBWaiting, common.IBWaiting, common.IBRunning, common.IBFinished)
+
+	var states []string
+	request, remove := newUploadRequest(t, server, &states)
+	defer remove()
+
+	results, err := request.ProcessContext(context.Background())
+	if err != nil {
+		t.Fatalf("the request failed: %v", err)
+	}
+
+	expected := []string{"none -> WAITING", "WAITING -> RUNNING", "RUNNING -> FINISHED"}
+	if !reflect.DeepEqual(states, expected) {
+		t.Errorf("unexpected upload states %v, expected %v", states, expected)
+	}
+
+	uploads := results[0].Compose.Uploads
+	if len(uploads) != 1 || uploads[0].Status != common.IBFinished || uploads[0].ImageName != "test-image" {
+		t.Errorf("unexpected uploads: %+v", uploads)
+	}
+
+	checkCleanedUp(t, server)
+}
+
+func TestProcessUploadFailed(t *testing.T) {
+	server := weldrtest.NewServer()
+	defer server.Close()
+
+	server.SetUploadStates("aws", common.IBWaiting, common.IBRunning, common.IBFailed)
+
+	var states []string
+	request, remove := newUploadRequest(t, server, &states)
+	defer remove()
+
+	results, err := request.ProcessContext(context.Background())
+	if _, ok := err.(*weldr_image.ImagesError); !ok {
+		t.Fatalf("expected an *ImagesError, got %v", err)
+	}
+
+	uploadError, ok := results[0].Err.(*weldr_image.UploadError)
+	if !ok {
+		t.Fatalf("expected an *UploadError, got %v", results[0].Err)
+	}
+	if uploadError.Provider != "aws" || uploadError.ImageName != "test-image" {
+		t.Errorf("unexpected upload error: %+v", uploadError)
+	}
+
+	expected := []string{"none -> WAITING", "WAITING -> RUNNING", "RUNNING -> FAILED"}
+	if !reflect.DeepEqual(states, expected) {
+		t.Errorf("unexpected upload states %v, expected %v", states, expected)
+	}
+
+	checkCleanedUp(t, server)
+}
+
+func TestLoadUploadConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		expected *weldr.UploadRequest
+	}{
+		{
+			name: "toml",
+			config: `
+provider = "aws.s3"
+image_name = "test-image"
+
+[settings]
+region = "us-east-1"
+bucket = "images"
+key = "test-image.raw"
+endpoint = "http://localhost:9000"
+`,
+			expected: &weldr.UploadRequest{
+				Provider:  "aws.s3",
+				ImageName: "test-image",
+				Settings: weldr.AWSS3UploadSettings{
+					Region:   "us-east-1",
+					Bucket:   "images",
+					Key:      "test-image.raw",
+					Endpoint: "http://localhost:9000",
+				},
+			},
+		},
+		{
+			name:   "json",
+			config: `{"provider": "aws", "image_name": "test-image", "settings": {"region": "eu-west-1", "bucket": "images", "key": "test-image"}}`,
+			expected: &weldr.UploadRequest{
+				Provider:  "aws",
+				ImageName: "test-image",
+				Settings: weldr.AWSUploadSettings{
+					Region: "eu-west-1",
+					Bucket: "images",
+					Key:    "test-image",
+				},
+			},
+		},
+		{
+			name:   "no provider",
+			config: `image_name = "test-image"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			upload, err := weldr_image.LoadUploadConfig([]byte(test.config))
+			if test.expected == nil {
+				if err == nil {
+					t.Fatalf("an invalid config was loaded: %+v", upload)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("cannot load the config: %v", err)
+			}
+			if !reflect.DeepEqual(upload, test.expected) {
+				t.Errorf("unexpected upload %+v, expected %+v", upload, test.expected)
+			}
+		})
+	}
+}
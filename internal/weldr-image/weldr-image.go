@@ -1,35 +1,123 @@
 package weldr_image
 
 import (
-	"archive/tar"
-	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"net/http"
-	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/BurntSushi/toml"
 	"github.com/google/uuid"
 
 	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/client"
-	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/common"
 	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/weldr"
 )
 
+// Image describes one of the images built from the request's blueprint
+type Image struct {
+	Type string
+	// Path is where the image is saved. The image is first downloaded into
+	// a partial file next to it, an interrupted download is resumed from
+	// it. Once the size and the checksum of the image are verified, the
+	// partial file is atomically renamed to Path.
+	Path string
+	// Writer is where the image is written if Path is empty. Downloads into
+	// a writer cannot be resumed nor verified.
+	Writer io.Writer
+	// Checksum is the expected checksum of the image in the "sha256:HEX"
+	// form (optional, Path only)
+	Checksum     string
+	ManifestPath string
+	LogPath      string
+
+	// ComposeID is the id of an existing compose (optional). If it's set,
+	// no new compose is started for the image, the request waits for the
+	// existing one and fetches its artifacts instead. Type is not needed
+	// then and the image is not downloaded if neither Path nor Writer is
+	// set. An existing compose is never cancelled and it's deleted only
+	// after all its artifacts were fetched.
+	ComposeID uuid.UUID
+
+	// Upload makes osbuild-composer upload the image to a cloud provider
+	// (optional). The request waits until the upload finishes, the image
+	// is downloaded only if Path or Writer is set as well.
+	Upload *weldr.UploadRequest
+
+	// Size is the size of the image in bytes (optional, 0 means the
+	// default size of the image type). It cannot be set for ostree commits
+	// and containers, they're archives.
+	Size uint64
+	// OSTree are the ostree parameters of an edge or iot image (optional).
+	// Commits can be built on top of a parent, the other ostree images
+	// require the url of the repository their commit is pulled from.
+	OSTree *weldr.OSTreeRequestV0
+}
+
+// attached returns true if the image is built by an existing compose
+func (i *Image) attached() bool {
+	return i.ComposeID != uuid.Nil
+}
+
 type Request struct {
+	Images    []Image
+	Blueprint []byte
+	// BlueprintName is the name of a blueprint already present in
+	// osbuild-composer (optional). If it's set, Blueprint is ignored and the
+	// blueprint is neither pushed nor deleted by the request.
+	BlueprintName string
+	// BlueprintOverlay is merged on top of Blueprint, or of the empty
+	// blueprint if Blueprint is empty, before it's pushed (optional), see
+	// MergeBlueprints. Its users already in Blueprint only change the set
+	// fields, e.g. the key. It cannot be combined with BlueprintName.
+	BlueprintOverlay *Blueprint
+	KeepArtifacts    bool
+	// Detach stops the processing once the composes are started, they're
+	// neither waited for nor cleaned up. The results contain the ids of
+	// the composes, their artifacts can be fetched later by attaching to
+	// them (see Image.ComposeID). The blueprint and the sources are kept
+	// for the composes, unless none of them was started.
+	Detach bool
+	// Distro and Arch select the distribution and the architecture of the
+	// images (optional, the defaults of the osbuild-composer's host are used
+	// otherwise). They're supported only by newer osbuild-composer versions.
+	Distro string
+	Arch   string
+	// Sources are json or toml sources (repositories) registered in
+	// osbuild-composer before the blueprint is pushed (optional). They're
+	// deleted together with the blueprint unless KeepArtifacts or Detach
+	// is set (see Detach). A source that already exists is never replaced.
+	Sources [][]byte
+
+	// Client is used to talk to osbuild-composer (optional, a client
+	// connected to the default API socket is used if it's nil)
+	Client *http.Client
+	// Backend is the API the request is processed with (optional, the
+	// weldr API reached by Client is used if it's nil)
+	Backend Backend
+	// PollInterval is the time between two compose status requests
+	// (optional, 1 second by default)
+	PollInterval time.Duration
+
+	// Observer is notified about the progress of the request (optional).
+	// The images are built concurrently, so it must be safe to call it from
+	// multiple goroutines.
+	Observer Observer
+}
+
+// ImageResult is the outcome of building one image of the request
+type ImageResult struct {
 	ImageType     string
-	ImageWriter   io.Writer
-	ManifestPath  string
-	Blueprint     []byte
-	LogPath       string
-	KeepArtifacts bool
+	BlueprintName string
+	ComposeID     uuid.UUID
+	// Compose is the last retrieved status of the compose, it's empty if
+	// the status was never retrieved
+	Compose weldr.ComposeEntryV0
+	Err     error
 }
 
 type APIError struct {
@@ -44,11 +132,60 @@ func (e *APIError) Error() string {
 type UnknownImageTypeError struct {
 	unknownImageType string
 	validImageTypes  []string
+	// distro and arch are the selected combination, empty for the defaults
+	// of the host
+	distro string
+	arch   string
 }
 
 func (e *UnknownImageTypeError) Error() string {
 	validImageTypes := strings.Join(e.validImageTypes, ", ")
-	return fmt.Sprintf("unknown image type: %s\nvalid image types: %s", e.unknownImageType, validImageTypes)
+	if e.distro == "" && e.arch == "" {
+		return fmt.Sprintf("unknown image type: %s\nvalid image types: %s", e.unknownImageType, validImageTypes)
+	}
+
+	combination := describeCombination(e.distro, e.arch)
+	return fmt.Sprintf("unknown image type: %s for %s\nvalid image types for %s: %s", e.unknownImageType, combination, combination, validImageTypes)
+}
+
+// describeCombination describes the selected distro and arch, e.g.
+// "fedora-33/aarch64", the host's default is used for an empty one
+func describeCombination(distro, arch string) string {
+	if distro == "" {
+		distro = "the default distro"
+	}
+	if arch == "" {
+		arch = "the default arch"
+	}
+	return distro + "/" + arch
+}
+
+// ImagesError is returned when building at least one of the request's images
+// failed. Results contain the outcome of all the images, including the
+// successful ones.
+type ImagesError struct {
+	Results []ImageResult
+}
+
+func (e *ImagesError) Error() string {
+	var failed []string
+	for _, result := range e.Results {
+		switch {
+		case result.Err == nil:
+		case result.ImageType == "":
+			// the type of an attached compose that couldn't be
+			// retrieved is unknown, the error names the compose
+			failed = append(failed, result.Err.Error())
+		default:
+			failed = append(failed, fmt.Sprintf("building %s failed: %v", result.ImageType, result.Err))
+		}
+	}
+
+	if len(e.Results) == 1 {
+		return failed[0]
+	}
+
+	return fmt.Sprintf("%d of %d images failed:\n%s", len(failed), len(e.Results), strings.Join(failed, "\n"))
 }
 
 type ComposeError struct {
@@ -59,281 +196,387 @@ func (e *ComposeError) Error() string {
 	return fmt.Sprintf("compose failed, log:\n%s", e.Log)
 }
 
+// cleanupTimeout limits how long the cleanup of the artifacts can take. The
+// cleanup runs even if the request's context was cancelled, so it cannot be
+// bound to it.
+const cleanupTimeout = 30 * time.Second
+
 type requestHandler struct {
 	request *Request
 
-	client *http.Client
+	backend Backend
 
 	blueprintName string
-	composeId     uuid.UUID
+	// ownsBlueprint is true if the blueprint was pushed by this request
+	ownsBlueprint bool
+	// sourceNames are the sources registered by this request
+	sourceNames []string
+	// composesStarted counts the composes started by this request, it's
+	// updated atomically
+	composesStarted int32
 }
 
 func (r *Request) Validate() error {
-	c := newClient()
+	return r.ValidateContext(context.Background())
+}
 
-	types, response, err := client.GetComposesTypesV0(c)
-	if err := translateError(response, err); err != nil {
-		return &APIError{
-			Message: "cannot retrieve compose types",
-			Cause:   err,
+// ValidateContext is like Validate but the API calls are bound to ctx.
+func (r *Request) ValidateContext(ctx context.Context) error {
+	return r.validate(ctx, !r.Detach)
+}
+
+// validate checks that the images can be built, needsOutput requires a path
+// or a writer for each of them
+func (r *Request) validate(ctx context.Context, needsOutput bool) error {
+	if r.BlueprintName == "" && len(r.Blueprint) > 0 {
+		if _, err := decodeBuildBlueprint(r.Blueprint); err != nil {
+			return err
 		}
 	}
 
-	if !isImageTypeValid(r.ImageType, types) {
-		var validImageTypes []string
+	if r.BlueprintName != "" && r.BlueprintOverlay != nil {
+		return errors.New("a blueprint overlay cannot be applied to a blueprint already present in osbuild-composer")
+	}
+
+	// the overlay cannot be merged with keys unknown to the model
+	if r.BlueprintOverlay != nil {
+		if _, err := applyBlueprintOverlay(r.Blueprint, r.BlueprintOverlay); err != nil {
+			return err
+		}
+	}
+
+	types, err := r.backend().ImageTypes(ctx, r.Distro, r.Arch)
+	if err != nil {
+		return err
+	}
+
+	if len(r.Images) == 0 {
+		return errors.New("no image to be built")
+	}
+
+	for _, image := range r.Images {
+		if image.attached() {
+			continue
+		}
+
+		if image.Path == "" && image.Writer == nil && image.Upload == nil && needsOutput {
+			return fmt.Errorf("no path, writer nor upload given for the %s image", image.Type)
+		}
+
+		if !isImageTypeValid(image.Type, types) {
+			var validImageTypes []string
+
+			for _, imageType := range types {
+				if imageType.Enabled {
+					validImageTypes = append(validImageTypes, imageType.Name)
+				}
+			}
 
-		for _, imageType := range types {
-			if imageType.Enabled {
-				validImageTypes = append(validImageTypes, imageType.Name)
+			return &UnknownImageTypeError{
+				unknownImageType: image.Type,
+				validImageTypes:  validImageTypes,
+				distro:           r.Distro,
+				arch:             r.Arch,
 			}
 		}
 
-		return &UnknownImageTypeError{
-			unknownImageType: r.ImageType,
-			validImageTypes:  validImageTypes,
+		if err := image.validateParameters(); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func (r *Request) Process() error {
+// Process registers the sources, pushes the blueprint, checks that its
+// packages can be resolved, builds all the images concurrently and downloads
+// their artifacts. If the packages cannot be resolved, no compose is started
+// and the returned error is a *DepsolveError. One failing image doesn't stop the others, the
+// outcome of each image is returned in the results. If any of the images
+// failed, the returned error is an *ImagesError.
+func (r *Request) Process() ([]ImageResult, error) {
+	return r.ProcessContext(context.Background())
+}
+
+// ProcessContext is like Process but all the API calls are bound to ctx.
+//
+// When ctx is cancelled or its deadline passes once the composes are started,
+// the composes that haven't finished yet are cancelled in osbuild-composer.
+// The artifacts are cleaned up even then (unless KeepArtifacts is set).
+func (r *Request) ProcessContext(ctx context.Context) ([]ImageResult, error) {
 	rh := requestHandler{
-		client:  newClient(),
+		backend: r.backend(),
 		request: r,
 	}
 
-	err := rh.pushBlueprint()
-	if err != nil {
-		return err
-	}
 	if !r.KeepArtifacts {
 		defer func() {
-			err := rh.deleteBlueprint()
-			if err != nil {
-				log.Printf("cannot delete the blueprint: %v\n", err)
+			// the detached composes still need the blueprint and the
+			// sources
+			if r.Detach && atomic.LoadInt32(&rh.composesStarted) > 0 {
+				return
 			}
+			rh.cleanup()
 		}()
 	}
 
-	err = rh.pushCompose()
+	err := rh.pushSources(ctx)
 	if err != nil {
-		return err
-	}
-	if !r.KeepArtifacts {
-		defer func() {
-			err := rh.deleteCompose()
-			if err != nil {
-				log.Printf("cannot delete the compose: %v\n", err)
-			}
-		}()
-	}
-
-	err = rh.waitForFinishedCompose()
-	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = rh.writeComposeImage()
+	err = rh.pushBlueprint(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if rh.request.ManifestPath != "" {
-		err := rh.writeManifest()
+	// catch missing packages before starting composes that would fail
+	// minutes later (if the backend can resolve them)
+	if rh.blueprintName != "" {
+		_, err := rh.depsolve(ctx)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	if rh.request.LogPath != "" {
-		err := rh.writeLog()
-		if err != nil {
-			return err
-		}
-	}
+	results := make([]ImageResult, len(r.Images))
 
-	return nil
-}
+	var wg sync.WaitGroup
+	for i := range r.Images {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
 
-func (h *requestHandler) pushBlueprint() error {
-	blueprintDetail, err := loadBlueprintDetailOrCreateEmpty(h.request.Blueprint)
-	if err != nil {
-		return err
-	}
-
-	h.blueprintName = blueprintDetail.name
+			ch := composeHandler{
+				requestHandler: &rh,
+				image:          &r.Images[i],
+			}
+			err := ch.process(ctx)
 
-	if blueprintDetail.isTOML {
-		response, err := client.PostTOMLBlueprintV0(h.client, blueprintDetail.blueprint)
-		if err := translateError(response, err); err != nil {
-			return &APIError{
-				Message: "cannot post a new toml blueprint",
-				Cause:   err,
+			imageType := ch.image.Type
+			if imageType == "" {
+				imageType = ch.compose.ComposeType
 			}
-		}
-	} else {
-		response, err := client.PostJSONBlueprintV0(h.client, blueprintDetail.blueprint)
-		if err := translateError(response, err); err != nil {
-			return &APIError{
-				Message: "cannot post a new json blueprint",
-				Cause:   err,
+			blueprintName := rh.blueprintName
+			if blueprintName == "" {
+				blueprintName = ch.compose.Blueprint
+			}
+
+			results[i] = ImageResult{
+				ImageType:     imageType,
+				BlueprintName: blueprintName,
+				ComposeID:     ch.composeId,
+				Compose:       ch.compose,
+				Err:           err,
 			}
+		}(i)
+	}
+	wg.Wait()
+
+	for _, result := range results {
+		if result.Err != nil {
+			return results, &ImagesError{Results: results}
 		}
 	}
 
-	return nil
+	return results, nil
 }
 
-func (h *requestHandler) pushCompose() error {
-	response, err := client.PostComposeV0(h.client, `{"blueprint_name": "`+h.blueprintName+`", "compose_type": "`+h.request.ImageType+`"}`)
-	if err := translateError(response, err); err != nil {
-		return &APIError{
-			Message: "cannot post a new compose",
-			Cause:   err,
+// needsBlueprint returns true if a new compose is started for any of the
+// images
+func (r *Request) needsBlueprint() bool {
+	for _, image := range r.Images {
+		if !image.attached() {
+			return true
 		}
 	}
+	return false
+}
 
-	composes, response, err := client.GetComposeStatusV0(h.client, "*", h.blueprintName, "", "")
-	if err := translateError(response, err); err != nil {
-		return &APIError{
-			Message: "cannot retrieve a compose status",
-			Cause:   err,
-		}
+func (r *Request) pollInterval() time.Duration {
+	if r.PollInterval > 0 {
+		return r.PollInterval
 	}
+	return 1 * time.Second
+}
 
-	if len(composes) != 1 {
-		return fmt.Errorf("expected one compose, got %d", len(composes))
+func (r *Request) client() *http.Client {
+	if r.Client != nil {
+		return r.Client
 	}
+	return newClient()
+}
 
-	h.composeId = composes[0].ID
-
-	return nil
+func (r *Request) backend() Backend {
+	if r.Backend != nil {
+		return r.Backend
+	}
+	return NewWeldrBackend(r.client())
 }
 
-func (h *requestHandler) waitForFinishedCompose() error {
-	for {
-		composes, response, err := client.GetComposeStatusV0(h.client, h.composeId.String(), "", "", "")
-		if err := translateError(response, err); err != nil {
-			return &APIError{
-				Message: "cannot retrieve a compose status",
-				Cause:   err,
-			}
-		}
+// emit fills in the details known by the handler and passes the event to the
+// request's observer, if there's any
+func (h *requestHandler) emit(event Event) {
+	if h.request.Observer == nil {
+		return
+	}
 
-		if len(composes) != 1 {
-			panic("wrong number of composes")
-		}
+	event.Time = time.Now()
+	event.BlueprintName = h.blueprintName
 
-		if composes[0].QueueStatus == common.IBFailed {
-			var logBuffer bytes.Buffer
-			response, err := client.WriteComposeLogV0(h.client, &logBuffer, h.composeId.String())
+	h.request.Observer.OnEvent(event)
+}
 
-			if err := translateError(response, err); err != nil {
-				return &APIError{
-					Message: "cannot retrieve the log",
-					Cause: &APIError{
-						Message: "compose failed",
-					},
-				}
-			}
+func (h *requestHandler) pushBlueprint(ctx context.Context) error {
+	if !h.request.needsBlueprint() {
+		return nil
+	}
 
-			return &ComposeError{Log: logBuffer.String()}
-		}
+	if h.request.BlueprintName != "" {
+		h.blueprintName = h.request.BlueprintName
+		return nil
+	}
 
-		if composes[0].QueueStatus == common.IBFinished {
-			break
+	blueprint := h.request.Blueprint
+	if h.request.BlueprintOverlay != nil {
+		var err error
+		blueprint, err = applyBlueprintOverlay(blueprint, h.request.BlueprintOverlay)
+		if err != nil {
+			return err
 		}
+	}
 
-		time.Sleep(1 * time.Second)
+	name, err := h.backend.PushBlueprint(ctx, blueprint)
+	if err != nil {
+		return err
 	}
 
+	h.blueprintName = name
+	h.ownsBlueprint = true
+
+	h.emit(Event{Type: EventBlueprintPushed})
+
 	return nil
 }
 
-func (h *requestHandler) writeComposeImage() error {
-	response, err := client.WriteComposeImageV0(h.client, h.request.ImageWriter, h.composeId.String())
-	if err := translateError(response, err); err != nil {
-		return &APIError{
-			Message: "cannot download the image",
-			Cause:   err,
-		}
+// cleanup deletes the blueprint pushed by the request and the registered
+// sources, a failure is only logged
+func (h *requestHandler) cleanup() {
+	if !h.ownsBlueprint && len(h.sourceNames) == 0 {
+		return
 	}
 
-	return nil
-}
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
 
-func (h *requestHandler) deleteBlueprint() error {
-	response, err := client.DeleteBlueprintV0(h.client, h.blueprintName)
-	if err := translateError(response, err); err != nil {
-		return &APIError{
-			Message: "cannot delete the blueprint",
-			Cause:   err,
+	if h.ownsBlueprint {
+		err := h.backend.DeleteBlueprint(ctx, h.blueprintName)
+		if err != nil {
+			log.Printf("cannot delete the blueprint: %v\n", err)
 		}
 	}
 
-	return nil
-}
-
-func (h *requestHandler) deleteCompose() error {
-	_, response, err := client.DeleteComposeV0(h.client, h.composeId.String())
-	if err := translateError(response, err); err != nil {
-		return &APIError{
-			Message: "cannot delete the blueprint",
-			Cause:   err,
+	for _, name := range h.sourceNames {
+		err := h.backend.DeleteSource(ctx, name)
+		if err != nil {
+			log.Printf("cannot delete the source %s: %v\n", name, err)
 		}
 	}
 
-	return nil
+	h.emit(Event{Type: EventCleanupDone})
 }
 
-func (h *requestHandler) writeManifest() error {
-	var tarManifestBuffer bytes.Buffer
-	response, err := client.WriteComposeMetadataV0(h.client, &tarManifestBuffer, h.composeId.String())
+// PushBlueprint pushes a json or toml blueprint to osbuild-composer and
+// returns its name. If the blueprint is empty, an empty blueprint with a
+// random name is pushed instead. The blueprint is posted as normalized json,
+// see Blueprint.Normalize, unless it has keys unknown to the model: it's
+// posted as it was decoded then, so they're not lost.
+func PushBlueprint(ctx context.Context, c *http.Client, rawBlueprint []byte) (string, error) {
+	blueprint, err := loadBlueprintOrCreateEmpty(rawBlueprint)
+	if err != nil {
+		return "", err
+	}
 
-	if err := translateError(response, err); err != nil {
-		return &APIError{
-			Message: "cannot retrieve the manifest",
+	data, err := normalizedJSON(blueprint)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := client.PostJSONBlueprintV0(ctx, c, string(data))
+	if err := TranslateError(response, err); err != nil {
+		return "", &APIError{
+			Message: "cannot post a new blueprint",
 			Cause:   err,
 		}
 	}
 
-	tarReader := tar.NewReader(&tarManifestBuffer)
+	return blueprint.Name, nil
+}
 
-	manifestHeader, err := tarReader.Next()
+// PushBlueprintWorkspace stores a json or toml blueprint in the
+// osbuild-composer's workspace without committing it and returns its name
+func PushBlueprintWorkspace(ctx context.Context, c *http.Client, rawBlueprint []byte) (string, error) {
+	blueprint, err := decodeBuildBlueprint(rawBlueprint)
 	if err != nil {
-		return fmt.Errorf("cannot decode the metadata tar: %v", err)
+		return "", err
 	}
 
-	f, err := os.Create(h.request.ManifestPath)
+	data, err := normalizedJSON(blueprint)
 	if err != nil {
-		return fmt.Errorf("cannot created the manifest file: %v", err)
+		return "", err
 	}
 
-	_, err = io.CopyN(f, tarReader, manifestHeader.Size)
-	if err != nil {
-		return fmt.Errorf("cannot copy the manifest: %v", err)
+	response, err := client.PostJSONWorkspaceV0(ctx, c, string(data))
+	if err := TranslateError(response, err); err != nil {
+		return "", &APIError{
+			Message: "cannot store the blueprint in the workspace",
+			Cause:   err,
+		}
 	}
 
-	return nil
+	return blueprint.Name, nil
 }
 
-func (h *requestHandler) writeLog() error {
-	f, err := os.Create(h.request.LogPath)
-	if err != nil {
-		return fmt.Errorf("cannot created the log file: %v", err)
+// DeleteBlueprint deletes the named blueprint from osbuild-composer
+func DeleteBlueprint(ctx context.Context, c *http.Client, name string) error {
+	response, err := client.DeleteBlueprintV0(ctx, c, name)
+	if err := TranslateError(response, err); err != nil {
+		return &APIError{
+			Message: "cannot delete the blueprint",
+			Cause:   err,
+		}
 	}
 
-	response, err := client.WriteComposeLogV0(h.client, f, h.composeId.String())
+	return nil
+}
 
-	if err := translateError(response, err); err != nil {
-		return &APIError{
-			Message: "cannot retrieve the log",
+// DeleteBlueprintIfUnused deletes the named blueprint from osbuild-composer
+// unless there are still composes of it. It returns true if the blueprint was
+// deleted.
+func DeleteBlueprintIfUnused(ctx context.Context, c *http.Client, name string) (bool, error) {
+	composes, response, err := client.GetComposeStatusV0(ctx, c, "*", name, "", "")
+	if err := TranslateError(response, err); err != nil {
+		return false, &APIError{
+			Message: "cannot retrieve the composes of the blueprint",
 			Cause:   err,
 		}
 	}
 
-	return nil
+	if len(composes) > 0 {
+		return false, nil
+	}
+
+	return true, DeleteBlueprint(ctx, c, name)
+}
+
+// BlueprintName returns the name of a json or toml blueprint
+func BlueprintName(rawBlueprint []byte) (string, error) {
+	blueprint, err := decodeBuildBlueprint(rawBlueprint)
+	if err != nil {
+		return "", err
+	}
+
+	return blueprint.Name, nil
 }
 
 func isImageTypeValid(imageTypeToValidate string, types []weldr.ComposeTypeV0) bool {
@@ -347,16 +590,12 @@ func isImageTypeValid(imageTypeToValidate string, types []weldr.ComposeTypeV0) b
 }
 
 func newClient() *http.Client {
-	return &http.Client{
-		Transport: &http.Transport{
-			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
-				return net.Dial("unix", "/run/weldr/api.socket")
-			},
-		},
-	}
+	return unixSocketClient(defaultSocketPath)
 }
 
-func translateError(response *client.APIResponse, err error) error {
+// TranslateError converts the result of a weldr API call into an error: the
+// error of the call, or the errors of a response with a false status
+func TranslateError(response *client.APIResponse, err error) error {
 	if err != nil {
 		return err
 	}
@@ -377,44 +616,45 @@ func translateError(response *client.APIResponse, err error) error {
 	return nil
 }
 
-type blueprintDetail struct {
-	isTOML    bool
-	name      string
-	blueprint string
+// loadBlueprintOrCreateEmpty decodes the blueprint, or creates an empty one
+// with a random name if it's empty
+func loadBlueprintOrCreateEmpty(rawBlueprint []byte) (*Blueprint, error) {
+	if len(rawBlueprint) == 0 {
+		return &Blueprint{Name: uuid.New().String()}, nil
+	}
+
+	return decodeBuildBlueprint(rawBlueprint)
 }
 
-func loadBlueprintDetailOrCreateEmpty(blueprint []byte) (*blueprintDetail, error) {
-	if len(blueprint) == 0 {
-		name := uuid.New().String()
-		return &blueprintDetail{
-			isTOML:    false,
-			name:      name,
-			blueprint: fmt.Sprintf(`{"name":"%s"}`, name),
-		}, nil
+// applyBlueprintOverlay merges the overlay on top of the json or toml
+// blueprint (or of an empty one with a random name) and returns the result
+// as json
+func applyBlueprintOverlay(rawBlueprint []byte, overlay *Blueprint) ([]byte, error) {
+	blueprint, err := loadBlueprintOrCreateEmpty(rawBlueprint)
+	if err != nil {
+		return nil, err
 	}
 
-	return loadBlueprintDetail(blueprint)
+	data, err := MergeBlueprints(blueprint, updateExistingUsers(blueprint, overlay)).EncodeJSON()
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode the blueprint as json: %v", err)
+	}
+	return data, nil
 }
 
-func loadBlueprintDetail(rawBlueprint []byte) (*blueprintDetail, error) {
-	type blueprintStruct struct {
-		Name string `json:"name" toml:"name"`
+// normalizedJSON encodes a normalized copy of the blueprint as json, see
+// Blueprint.EncodeJSON for the blueprints with unknown keys
+func normalizedJSON(blueprint *Blueprint) ([]byte, error) {
+	if len(blueprint.Include) > 0 {
+		return nil, errors.New("the blueprint includes other blueprints, they're merged only when it's loaded by a BlueprintLoader")
 	}
-	var blueprint blueprintStruct
-	isTOML := false
-	err := json.Unmarshal(rawBlueprint, &blueprint)
 
+	normalized := *blueprint
+	normalized.Normalize()
+
+	data, err := normalized.EncodeJSON()
 	if err != nil {
-		err := toml.Unmarshal(rawBlueprint, &blueprint)
-		isTOML = true
-		if err != nil {
-			return nil, fmt.Errorf("cannot unmarshal the blueprint, it's not json nor toml")
-		}
+		return nil, fmt.Errorf("cannot encode the blueprint as json: %v", err)
 	}
-
-	return &blueprintDetail{
-		name:      blueprint.Name,
-		isTOML:    isTOML,
-		blueprint: string(rawBlueprint),
-	}, nil
+	return data, nil
 }
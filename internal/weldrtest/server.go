@@ -0,0 +1,1160 @@
+// Package weldrtest provides an in-process fake of the osbuild-composer's
+// weldr API for testing.
+//
+// The server listens on a unix socket in a temporary directory and implements
+// the endpoints used by the client package. Every compose goes through a
+// scripted list of states, one state per status request, so the tests can
+// drive waiting, running, finished and failed composes deterministically.
+// Failures can be injected into any route.
+//
+//	server := weldrtest.NewServer()
+//	defer server.Close()
+//
+//	server.SetStates("qcow2", common.IBWaiting, common.IBRunning, common.IBFailed)
+//	server.FailNext("compose/types", weldrtest.Failure{Status: http.StatusInternalServerError})
+//
+//	req := weldr_image.Request{Client: server.Client(), ...}
+package weldrtest
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/google/uuid"
+
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/client"
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/common"
+	"github.com/ondrejbudai/osbuild-image/internal/osbuild-composer/weldr"
+)
+
+// Failure describes an injected failure of a request
+type Failure struct {
+	// Status is the HTTP status code of the response. The body is always a
+	// weldr API error.
+	Status int
+	// Drop closes the connection without any response, Status is ignored
+	Drop bool
+	// Truncate lets the request succeed but closes the connection after
+	// the given number of bytes of the response body, the other fields
+	// are ignored
+	Truncate int64
+}
+
+// Compose is a compose known to the fake server
+type Compose struct {
+	weldr.ComposeEntryV0
+
+	// states are the remaining states of the compose, the first one is
+	// the current one
+	states []common.ImageBuildState
+	// uploadStates are the remaining states of each of the compose's
+	// uploads
+	uploadStates [][]common.ImageBuildState
+}
+
+// Server is a fake weldr API server
+type Server struct {
+	server *httptest.Server
+	dir    string
+
+	mu         sync.Mutex
+	imageTypes []weldr.ComposeTypeV0
+	states     map[string][]common.ImageBuildState
+	uploads    map[string][]common.ImageBuildState
+	blueprints map[string]string
+	packages   []weldr.ProjectV0
+	sources    map[string]weldr.SourceConfigV0
+	composes   map[uuid.UUID]*Compose
+	failures   map[string][]Failure
+	requests   []string
+
+	// distroImageTypes maps "distro/arch" to the image types of the
+	// combination
+	distroImageTypes map[string][]weldr.ComposeTypeV0
+
+	// Image, Log and Manifest are the artifacts returned for every
+	// compose. They can be changed before the artifacts are requested.
+	Image    []byte
+	Log      []byte
+	Manifest []byte
+}
+
+// DefaultStates are the states every compose goes through unless SetStates
+// was called for its image type
+var DefaultStates = []common.ImageBuildState{common.IBWaiting, common.IBRunning, common.IBFinished}
+
+// DefaultImageSize is the image size of a compose requested without a size.
+// Like in osbuild-composer, the image size is the size of the disk (the
+// default one of the image type if none was requested), not of the image
+// file.
+const DefaultImageSize = 4 * 1024 * 1024 * 1024
+
+// DefaultPackages are the packages available in the fake repositories unless
+// SetPackages was called. They have no dependencies.
+var DefaultPackages = []weldr.ProjectV0{
+	fakePackage("bash", "The GNU Bourne Again shell", 0, "5.0.17", "2.fc33"),
+	fakePackage("nginx", "A high performance web server and reverse proxy server", 1, "1.18.0", "3.fc33"),
+	fakePackage("tmux", "A terminal multiplexer", 0, "3.1c", "2.fc33"),
+	fakePackage("vim-enhanced", "A version of the VIM editor which includes recent enhancements", 2, "8.2.2143", "1.fc33"),
+	fakePackage("vim-minimal", "A minimal version of the VIM editor", 2, "8.2.2143", "1.fc33"),
+}
+
+func fakePackage(name, summary string, epoch uint, version, release string) weldr.ProjectV0 {
+	return weldr.ProjectV0{
+		Name:     name,
+		Summary:  summary,
+		Homepage: "https://example.com/" + name,
+		Builds: []weldr.PackageBuildV0{
+			{
+				Arch:      "x86_64",
+				BuildTime: "2020-12-01T00:00:00",
+				Epoch:     epoch,
+				Release:   release,
+				Source:    weldr.PackageSourceV0{License: "GPLv3+", Version: version},
+			},
+		},
+	}
+}
+
+// NewServer starts a new fake weldr API server. It panics if the server
+// cannot be started, the caller should call Close when finished.
+func NewServer() *Server {
+	dir, err := ioutil.TempDir("", "weldrtest")
+	if err != nil {
+		panic(fmt.Sprintf("weldrtest: cannot create a temporary directory: %v", err))
+	}
+
+	listener, err := net.Listen("unix", filepath.Join(dir, "api.socket"))
+	if err != nil {
+		os.RemoveAll(dir)
+		panic(fmt.Sprintf("weldrtest: cannot listen on a unix socket: %v", err))
+	}
+
+	s := &Server{
+		dir: dir,
+		imageTypes: []weldr.ComposeTypeV0{
+			{Name: "ami", Enabled: true},
+			{Name: "openstack", Enabled: true},
+			{Name: "qcow2", Enabled: true},
+			{Name: "tar", Enabled: true},
+			{Name: "vhd", Enabled: true},
+			{Name: "vmdk", Enabled: true},
+		},
+		states:     make(map[string][]common.ImageBuildState),
+		uploads:    make(map[string][]common.ImageBuildState),
+		blueprints: make(map[string]string),
+		packages:   DefaultPackages,
+		sources: map[string]weldr.SourceConfigV0{
+			"fedora": {Name: "fedora", Type: "yum-metalink", URL: "https://mirrors.fedoraproject.org/metalink?repo=fedora-33&arch=x86_64", CheckGPG: true, CheckSSL: true, System: true},
+		},
+		composes: make(map[uuid.UUID]*Compose),
+		failures: make(map[string][]Failure),
+
+		distroImageTypes: make(map[string][]weldr.ComposeTypeV0),
+
+		Image:    []byte("fake image\n"),
+		Log:      []byte("fake log\n"),
+		Manifest: []byte(`{"pipeline":{},"sources":{}}`),
+	}
+
+	s.server = httptest.NewUnstartedServer(http.HandlerFunc(s.serveHTTP))
+	s.server.Listener.Close()
+	s.server.Listener = listener
+	s.server.Start()
+
+	return s
+}
+
+// Close shuts down the server and removes its socket
+func (s *Server) Close() {
+	s.server.Close()
+	os.RemoveAll(s.dir)
+}
+
+// SocketPath returns the path of the server's unix socket
+func (s *Server) SocketPath() string {
+	return filepath.Join(s.dir, "api.socket")
+}
+
+// Address returns the address of the server in the form accepted by
+// weldr_image.Endpoint
+func (s *Server) Address() string {
+	return "unix://" + s.SocketPath()
+}
+
+// Client returns a client connected to the server
+func (s *Server) Client() *http.Client {
+	path := s.SocketPath()
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("unix", path)
+			},
+		},
+	}
+}
+
+// SetImageTypes replaces the image types returned by compose/types
+func (s *Server) SetImageTypes(types ...weldr.ComposeTypeV0) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.imageTypes = types
+}
+
+// SetDistroImageTypes sets the image types returned by compose/types for the
+// distro and arch selectors, e.g. "fedora-33" and "aarch64". Either of them
+// can be empty to match a request omitting it. Composes and compose types of
+// the combinations never set are rejected.
+func (s *Server) SetDistroImageTypes(distro, arch string, types ...weldr.ComposeTypeV0) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.distroImageTypes[distro+"/"+arch] = types
+}
+
+// imageTypesFor returns the image types of the distro and arch, the default
+// ones if both are empty
+func (s *Server) imageTypesFor(distro, arch string) ([]weldr.ComposeTypeV0, bool) {
+	if distro == "" && arch == "" {
+		return s.imageTypes, true
+	}
+	types, ok := s.distroImageTypes[distro+"/"+arch]
+	return types, ok
+}
+
+// SetStates sets the states the composes of the given image type go through.
+// The state advances with every status request of the compose and stays at
+// the last state.
+func (s *Server) SetStates(imageType string, states ...common.ImageBuildState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.states[imageType] = states
+}
+
+// SetUploadStates sets the states the uploads to the given provider go
+// through, DefaultStates are used otherwise. The state of an upload advances
+// with every status request of its compose and stays at the last state.
+func (s *Server) SetUploadStates(provider string, states ...common.ImageBuildState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.uploads[provider] = states
+}
+
+// SetPackages replaces the packages available in the fake repositories, each
+// package should have exactly one build
+func (s *Server) SetPackages(packages ...weldr.ProjectV0) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.packages = packages
+}
+
+// FailNext makes the next requests to the route fail, one failure per
+// request. The route is the part of the path after /api/v0/ without any
+// arguments, e.g. "compose/status" or "blueprints/new".
+func (s *Server) FailNext(route string, failures ...Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures[route] = append(s.failures[route], failures...)
+}
+
+// Blueprints returns the names of the blueprints currently stored
+func (s *Server) Blueprints() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var names []string
+	for name := range s.blueprints {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Sources returns the names of the sources currently stored, including the
+// system "fedora" source
+func (s *Server) Sources() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var names []string
+	for name := range s.sources {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Composes returns the composes currently stored
+func (s *Server) Composes() []weldr.ComposeEntryV0 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var composes []weldr.ComposeEntryV0
+	for _, compose := range s.composes {
+		composes = append(composes, compose.ComposeEntryV0)
+	}
+	return composes
+}
+
+// Requests returns all the requests received so far in the form "METHOD path"
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.requests...)
+}
+
+// route splits the request path into the route and its argument, e.g.
+// /api/v0/compose/status/UUID is split into "compose/status" and "UUID"
+func route(path string) (string, string) {
+	path = strings.TrimPrefix(path, "/api/v0/")
+
+	for _, r := range []string{
+		"blueprints/list",
+		"blueprints/info",
+		"blueprints/depsolve",
+		"blueprints/new",
+		"blueprints/delete",
+		"projects/list",
+		"projects/info",
+		"projects/depsolve",
+		"modules/list",
+		"modules/info",
+		"projects/source/list",
+		"projects/source/info",
+		"projects/source/new",
+		"projects/source/delete",
+		"compose/status",
+		"compose/queue",
+		"compose/finished",
+		"compose/failed",
+		"compose/types",
+		"compose/image",
+		"compose/log",
+		"compose/metadata",
+		"compose/delete",
+		"compose/cancel",
+	} {
+		if path == r || strings.HasPrefix(path, r+"/") {
+			return r, strings.TrimPrefix(strings.TrimPrefix(path, r), "/")
+		}
+	}
+
+	return path, ""
+}
+
+// serveHTTP handles the request with the server locked, except for streaming
+// the artifacts, so a slow download doesn't block the other requests
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	stream := s.handle(w, r)
+	s.mu.Unlock()
+
+	if stream != nil {
+		stream()
+	}
+}
+
+// handle handles the request, it returns the function streaming the response
+// body if it's to be written without the lock, or nil
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) func() {
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	name, arg := route(r.URL.Path)
+
+	if failures := s.failures[name]; len(failures) > 0 {
+		s.failures[name] = failures[1:]
+		if failures[0].Truncate == 0 {
+			s.fail(w, failures[0])
+			return nil
+		}
+		w = &truncatingWriter{ResponseWriter: w, remaining: failures[0].Truncate}
+	}
+
+	type handler struct {
+		method string
+		handle func(w http.ResponseWriter, r *http.Request, arg string)
+	}
+
+	handlers := map[string]handler{
+		"blueprints/list":        {"GET", s.listBlueprints},
+		"blueprints/info":        {"GET", s.getBlueprintsInfo},
+		"blueprints/depsolve":    {"GET", s.depsolveBlueprints},
+		"blueprints/new":         {"POST", s.postBlueprint},
+		"blueprints/delete":      {"DELETE", s.deleteBlueprint},
+		"projects/list":          {"GET", s.listProjects},
+		"projects/info":          {"GET", s.getProjectsInfo("projects")},
+		"projects/depsolve":      {"GET", s.depsolveProjects},
+		"modules/list":           {"GET", s.listModules},
+		"modules/info":           {"GET", s.getProjectsInfo("modules")},
+		"projects/source/list":   {"GET", s.listSources},
+		"projects/source/info":   {"GET", s.getSourcesInfo},
+		"projects/source/new":    {"POST", s.postSource},
+		"projects/source/delete": {"DELETE", s.deleteSource},
+		"compose":                {"POST", s.postCompose},
+		"compose/status":         {"GET", s.getComposeStatus},
+		"compose/queue":          {"GET", s.getComposeQueue},
+		"compose/finished":       {"GET", s.getComposesInState(common.IBFinished, "finished")},
+		"compose/failed":         {"GET", s.getComposesInState(common.IBFailed, "failed")},
+		"compose/types":          {"GET", s.getComposeTypes},
+		"compose/image":          {"GET", nil},
+		"compose/log":            {"GET", nil},
+		"compose/metadata":       {"GET", s.getComposeMetadata},
+		"compose/delete":         {"DELETE", s.deleteCompose},
+		"compose/cancel":         {"DELETE", s.cancelCompose},
+	}
+
+	// the artifacts are streamed without the lock, their handlers return
+	// the function streaming them
+	artifacts := map[string]func(w http.ResponseWriter, r *http.Request, arg string) func(){
+		"compose/image": s.artifactHandler(s.Image, true),
+		"compose/log":   s.artifactHandler(s.Log, false),
+	}
+
+	h, ok := handlers[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "HTTPError", "Not Found")
+		return nil
+	}
+	if h.method != r.Method {
+		writeError(w, http.StatusMethodNotAllowed, "HTTPError", "Method Not Allowed")
+		return nil
+	}
+
+	if artifact, ok := artifacts[name]; ok {
+		return artifact(w, r, arg)
+	}
+	h.handle(w, r, arg)
+	return nil
+}
+
+func (s *Server) fail(w http.ResponseWriter, failure Failure) {
+	if failure.Drop {
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			panic("weldrtest: cannot hijack the connection")
+		}
+		conn, _, err := hijacker.Hijack()
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+
+	writeError(w, failure.Status, "InjectedFailure", "failure injected by weldrtest")
+}
+
+// truncatingWriter drops the connection after the given number of bytes of
+// the body were written
+type truncatingWriter struct {
+	http.ResponseWriter
+	remaining int64
+}
+
+func (w *truncatingWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) <= w.remaining {
+		w.remaining -= int64(len(p))
+		return w.ResponseWriter.Write(p)
+	}
+
+	n, _ := w.ResponseWriter.Write(p[:w.remaining])
+	w.remaining = 0
+
+	// the headers and the first bytes reach the client before the
+	// connection is dropped, like a download interrupted half-way
+	w.ResponseWriter.(http.Flusher).Flush()
+
+	conn, buf, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		_ = buf.Flush()
+		conn.Close()
+	}
+
+	return n, errors.New("weldrtest: connection dropped")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, id, msg string) {
+	writeJSON(w, status, client.APIResponse{
+		Status: false,
+		Errors: []client.APIErrorMsg{{ID: id, Msg: msg}},
+	})
+}
+
+func (s *Server) postBlueprint(w http.ResponseWriter, r *http.Request, _ string) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "BlueprintsError", err.Error())
+		return
+	}
+
+	var blueprint struct {
+		Name string `json:"name" toml:"name"`
+	}
+
+	if r.Header.Get("Content-Type") == "text/x-toml" {
+		_, err = toml.Decode(string(body), &blueprint)
+	} else {
+		err = json.Unmarshal(body, &blueprint)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "BlueprintsError", err.Error())
+		return
+	}
+	if blueprint.Name == "" {
+		writeError(w, http.StatusBadRequest, "InvalidChars", "Invalid characters in API path")
+		return
+	}
+
+	s.blueprints[blueprint.Name] = string(body)
+	writeJSON(w, http.StatusOK, client.APIResponse{Status: true})
+}
+
+func (s *Server) deleteBlueprint(w http.ResponseWriter, _ *http.Request, name string) {
+	if _, ok := s.blueprints[name]; !ok {
+		writeError(w, http.StatusBadRequest, "UnknownBlueprint", fmt.Sprintf("Unknown blueprint name: %s", name))
+		return
+	}
+
+	delete(s.blueprints, name)
+	writeJSON(w, http.StatusOK, client.APIResponse{Status: true})
+}
+
+func (s *Server) listBlueprints(w http.ResponseWriter, r *http.Request, _ string) {
+	names := []string{}
+	for name := range s.blueprints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	total := uint(len(names))
+	n := limit(r, total)
+	writeJSON(w, http.StatusOK, weldr.BlueprintsListResponseV0{
+		Total:      total,
+		Limit:      n,
+		Blueprints: names[:n],
+	})
+}
+
+// getBlueprintsInfo returns the stored blueprints converted to json, the
+// workspace is not implemented so no blueprint is ever changed
+func (s *Server) getBlueprintsInfo(w http.ResponseWriter, _ *http.Request, arg string) {
+	response := weldr.BlueprintsInfoResponseV0{
+		Blueprints: []json.RawMessage{},
+		Changes:    []weldr.BlueprintChangedV0{},
+		Errors:     []weldr.ResponseError{},
+	}
+
+	for _, name := range strings.Split(arg, ",") {
+		raw, ok := s.blueprints[name]
+		if !ok {
+			response.Errors = append(response.Errors, weldr.ResponseError{ID: "UnknownBlueprint", Msg: fmt.Sprintf("%s: ", name)})
+			continue
+		}
+
+		var blueprint map[string]interface{}
+		if err := decodeBlueprint(raw, &blueprint); err != nil {
+			writeError(w, http.StatusBadRequest, "BlueprintsError", err.Error())
+			return
+		}
+
+		encoded, err := json.Marshal(blueprint)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "BlueprintsError", err.Error())
+			return
+		}
+
+		response.Blueprints = append(response.Blueprints, encoded)
+		response.Changes = append(response.Changes, weldr.BlueprintChangedV0{Name: name})
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// limit returns the limit query parameter of a list request, bounded by the
+// total number of results
+func limit(r *http.Request, total uint) uint {
+	if l, err := strconv.ParseUint(r.URL.Query().Get("limit"), 10, 0); err == nil && uint(l) < total {
+		return uint(l)
+	}
+	return total
+}
+
+func (s *Server) listProjects(w http.ResponseWriter, r *http.Request, _ string) {
+	projects := []weldr.ProjectV0{}
+	for _, p := range s.packages {
+		projects = append(projects, weldr.ProjectV0{Name: p.Name, Summary: p.Summary, Homepage: p.Homepage})
+	}
+
+	total := uint(len(projects))
+	n := limit(r, total)
+	writeJSON(w, http.StatusOK, weldr.ProjectsListResponseV0{
+		Total:    total,
+		Limit:    n,
+		Projects: projects[:n],
+	})
+}
+
+// listModules returns the packages as modules the same way osbuild-composer
+// does, the argument is a list of comma-separated globs
+func (s *Server) listModules(w http.ResponseWriter, r *http.Request, arg string) {
+	modules := []weldr.ModuleNameV0{}
+	for _, p := range s.packages {
+		if arg == "" || matchesAny(p.Name, strings.Split(arg, ",")) {
+			modules = append(modules, weldr.ModuleNameV0{Name: p.Name, GroupType: "rpm"})
+		}
+	}
+
+	total := uint(len(modules))
+	n := limit(r, total)
+	writeJSON(w, http.StatusOK, weldr.ModulesListResponseV0{
+		Total:   total,
+		Limit:   n,
+		Modules: modules[:n],
+	})
+}
+
+func matchesAny(name string, globs []string) bool {
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) findPackage(name string) (weldr.ProjectV0, bool) {
+	for _, p := range s.packages {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return weldr.ProjectV0{}, false
+}
+
+// packageSpec returns the package as resolved by a depsolve
+func packageSpec(p weldr.ProjectV0) weldr.PackageSpecV0 {
+	build := p.Builds[0]
+	return weldr.PackageSpecV0{
+		Name:    p.Name,
+		Epoch:   build.Epoch,
+		Version: build.Source.Version,
+		Release: build.Release,
+		Arch:    build.Arch,
+	}
+}
+
+// getProjectsInfo returns the handler of projects/info or modules/info, key
+// is the key of the list in the response
+func (s *Server) getProjectsInfo(key string) func(w http.ResponseWriter, r *http.Request, arg string) {
+	return func(w http.ResponseWriter, _ *http.Request, arg string) {
+		projects := []weldr.ProjectV0{}
+		for _, name := range strings.Split(arg, ",") {
+			p, ok := s.findPackage(name)
+			if !ok {
+				writeError(w, http.StatusBadRequest, "UnknownModule", "No packages have been found.")
+				return
+			}
+			if key == "modules" {
+				p.Dependencies = []weldr.PackageSpecV0{packageSpec(p)}
+			}
+			projects = append(projects, p)
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{key: projects})
+	}
+}
+
+func (s *Server) depsolveProjects(w http.ResponseWriter, _ *http.Request, arg string) {
+	specs := []weldr.PackageSpecV0{}
+	for _, name := range strings.Split(arg, ",") {
+		p, ok := s.findPackage(name)
+		if !ok {
+			writeError(w, http.StatusBadRequest, "ProjectsError", fmt.Sprintf("DNF error occurred: MarkingErrors: Error occurred when marking packages for installation: Problems in request:\nmissing packages: %s", name))
+			return
+		}
+		specs = append(specs, packageSpec(p))
+	}
+
+	writeJSON(w, http.StatusOK, weldr.ProjectsDepsolveResponseV0{Projects: specs})
+}
+
+// decodeBlueprint decodes a stored json or toml blueprint
+func decodeBlueprint(raw string, v interface{}) error {
+	if err := json.Unmarshal([]byte(raw), v); err != nil {
+		_, err = toml.Decode(raw, v)
+		return err
+	}
+	return nil
+}
+
+// depsolveBlueprints resolves the packages and modules of the blueprints to
+// the fake packages, the groups are ignored
+func (s *Server) depsolveBlueprints(w http.ResponseWriter, _ *http.Request, arg string) {
+	response := weldr.BlueprintsDepsolveResponseV0{
+		Blueprints: []weldr.BlueprintDepsolvedV0{},
+		Errors:     []weldr.ResponseError{},
+	}
+
+	for _, name := range strings.Split(arg, ",") {
+		raw, ok := s.blueprints[name]
+		if !ok {
+			response.Errors = append(response.Errors, weldr.ResponseError{ID: "UnknownBlueprint", Msg: fmt.Sprintf("%s: blueprint not found", name)})
+			continue
+		}
+
+		var blueprint struct {
+			Packages []struct {
+				Name string `json:"name" toml:"name"`
+			} `json:"packages" toml:"packages"`
+			Modules []struct {
+				Name string `json:"name" toml:"name"`
+			} `json:"modules" toml:"modules"`
+		}
+		if err := decodeBlueprint(raw, &blueprint); err != nil {
+			writeError(w, http.StatusBadRequest, "BlueprintsError", err.Error())
+			return
+		}
+
+		var names, missing []string
+		for _, p := range blueprint.Packages {
+			names = append(names, p.Name)
+		}
+		for _, m := range blueprint.Modules {
+			names = append(names, m.Name)
+		}
+
+		deps := []weldr.PackageSpecV0{}
+		for _, n := range names {
+			p, ok := s.findPackage(n)
+			if !ok {
+				missing = append(missing, n)
+				continue
+			}
+			deps = append(deps, packageSpec(p))
+		}
+
+		if len(missing) > 0 {
+			response.Errors = append(response.Errors, weldr.ResponseError{
+				ID:  "BlueprintsError",
+				Msg: fmt.Sprintf("%s: DNF error occurred: MarkingErrors: Error occurred when marking packages for installation: Problems in request:\nmissing packages: %s", name, strings.Join(missing, ", ")),
+			})
+			continue
+		}
+
+		var encoded map[string]interface{}
+		_ = decodeBlueprint(raw, &encoded)
+		bp, _ := json.Marshal(encoded)
+		response.Blueprints = append(response.Blueprints, weldr.BlueprintDepsolvedV0{Blueprint: bp, Dependencies: deps})
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) listSources(w http.ResponseWriter, _ *http.Request, _ string) {
+	names := []string{}
+	for name := range s.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writeJSON(w, http.StatusOK, weldr.SourceListV0{Sources: names})
+}
+
+func (s *Server) getSourcesInfo(w http.ResponseWriter, _ *http.Request, arg string) {
+	response := weldr.SourceInfoResponseV0{
+		Sources: make(map[string]weldr.SourceConfigV0),
+		Errors:  []weldr.ResponseError{},
+	}
+
+	for _, name := range strings.Split(arg, ",") {
+		source, ok := s.sources[name]
+		if !ok {
+			response.Errors = append(response.Errors, weldr.ResponseError{ID: "UnknownSource", Msg: fmt.Sprintf("%s is not a valid source", name)})
+			continue
+		}
+		response.Sources[name] = source
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) postSource(w http.ResponseWriter, r *http.Request, _ string) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "ProjectsError", err.Error())
+		return
+	}
+
+	var source weldr.SourceConfigV0
+	if r.Header.Get("Content-Type") == "text/x-toml" {
+		_, err = toml.Decode(string(body), &source)
+	} else {
+		err = json.Unmarshal(body, &source)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "ProjectsError", "Problem parsing POST body: "+err.Error())
+		return
+	}
+	if source.Name == "" {
+		writeError(w, http.StatusBadRequest, "ProjectsError", "Problem parsing POST body: missing name")
+		return
+	}
+	if existing, ok := s.sources[source.Name]; ok && existing.System {
+		writeError(w, http.StatusBadRequest, "SystemSource", fmt.Sprintf("%s is a system source, it cannot be changed.", source.Name))
+		return
+	}
+
+	source.System = false
+	s.sources[source.Name] = source
+	writeJSON(w, http.StatusOK, client.APIResponse{Status: true})
+}
+
+func (s *Server) deleteSource(w http.ResponseWriter, _ *http.Request, name string) {
+	source, ok := s.sources[name]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "UnknownSource", fmt.Sprintf("%s is not a valid source", name))
+		return
+	}
+	if source.System {
+		writeError(w, http.StatusBadRequest, "SystemSource", fmt.Sprintf("%s is a system source, it cannot be deleted.", name))
+		return
+	}
+
+	delete(s.sources, name)
+	writeJSON(w, http.StatusOK, client.APIResponse{Status: true})
+}
+
+func (s *Server) isImageTypeEnabled(name, distro, arch string) bool {
+	types, _ := s.imageTypesFor(distro, arch)
+	for _, t := range types {
+		if t.Name == name {
+			return t.Enabled
+		}
+	}
+	return false
+}
+
+func (s *Server) postCompose(w http.ResponseWriter, r *http.Request, _ string) {
+	var request weldr.ComposeRequestV0
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+
+	if _, ok := s.blueprints[request.BlueprintName]; !ok {
+		writeError(w, http.StatusBadRequest, "UnknownBlueprint", fmt.Sprintf("Unknown blueprint name: %s", request.BlueprintName))
+		return
+	}
+	if _, ok := s.imageTypesFor(request.Distro, request.Arch); !ok {
+		writeError(w, http.StatusBadRequest, "DistroError", fmt.Sprintf("Invalid distro or arch: %s/%s", request.Distro, request.Arch))
+		return
+	}
+	if !s.isImageTypeEnabled(request.ComposeType, request.Distro, request.Arch) {
+		writeError(w, http.StatusBadRequest, "UnknownComposeType", fmt.Sprintf("Unknown compose type for architecture: %s", request.ComposeType))
+		return
+	}
+
+	states, ok := s.states[request.ComposeType]
+	if !ok {
+		states = DefaultStates
+	}
+
+	imageSize := request.Size
+	if imageSize == 0 {
+		imageSize = DefaultImageSize
+	}
+
+	compose := &Compose{
+		ComposeEntryV0: weldr.ComposeEntryV0{
+			ID:          uuid.New(),
+			Blueprint:   request.BlueprintName,
+			Version:     "0.0.0",
+			ComposeType: request.ComposeType,
+			ImageSize:   imageSize,
+			JobCreated:  now(),
+		},
+		states: append([]common.ImageBuildState(nil), states...),
+	}
+	compose.setState(compose.states[0])
+
+	if request.Upload != nil {
+		uploadStates, ok := s.uploads[request.Upload.Provider]
+		if !ok {
+			uploadStates = DefaultStates
+		}
+
+		compose.Uploads = []weldr.UploadResponse{{
+			UUID:         uuid.New(),
+			Status:       uploadStates[0],
+			ProviderName: request.Upload.Provider,
+			ImageName:    request.Upload.ImageName,
+			CreationTime: now(),
+			Settings:     request.Upload.Settings,
+		}}
+		compose.uploadStates = [][]common.ImageBuildState{append([]common.ImageBuildState(nil), uploadStates...)}
+	}
+	s.composes[compose.ID] = compose
+
+	writeJSON(w, http.StatusOK, weldr.ComposeResponseV0{BuildID: compose.ID, Status: true})
+}
+
+func now() float64 {
+	return float64(time.Now().UnixNano()) / float64(time.Second)
+}
+
+// setState sets the compose's state and its job timestamps
+func (c *Compose) setState(state common.ImageBuildState) {
+	c.QueueStatus = state
+
+	switch state {
+	case common.IBRunning:
+		if c.JobStarted == 0 {
+			c.JobStarted = now()
+		}
+	case common.IBFinished, common.IBFailed:
+		if c.JobStarted == 0 {
+			c.JobStarted = now()
+		}
+		if c.JobFinished == 0 {
+			c.JobFinished = now()
+		}
+	}
+}
+
+// advance moves the compose to its next scripted state
+func (c *Compose) advance() {
+	if len(c.states) > 1 {
+		c.states = c.states[1:]
+		c.setState(c.states[0])
+	}
+
+	// the returned entries share the uploads, so they're copied before
+	// changing them
+	c.Uploads = append([]weldr.UploadResponse(nil), c.Uploads...)
+	for i, states := range c.uploadStates {
+		if len(states) > 1 {
+			c.uploadStates[i] = states[1:]
+			c.Uploads[i].Status = states[1]
+		}
+	}
+}
+
+func (s *Server) getComposeStatus(w http.ResponseWriter, r *http.Request, arg string) {
+	query := r.URL.Query()
+
+	var entries []weldr.ComposeEntryV0
+	for _, id := range strings.Split(arg, ",") {
+		for _, compose := range s.composes {
+			if id != "*" && compose.ID.String() != id {
+				continue
+			}
+			if blueprint := query.Get("blueprint"); blueprint != "" && compose.Blueprint != blueprint {
+				continue
+			}
+			if composeType := query.Get("type"); composeType != "" && compose.ComposeType != composeType {
+				continue
+			}
+			if status := query.Get("status"); status != "" && compose.QueueStatus.ToString() != status {
+				continue
+			}
+
+			entries = append(entries, compose.ComposeEntryV0)
+			compose.advance()
+		}
+	}
+
+	if entries == nil {
+		entries = []weldr.ComposeEntryV0{}
+	}
+
+	writeJSON(w, http.StatusOK, weldr.ComposeStatusResponseV0{UUIDs: entries})
+}
+
+// composesInState returns the entries of the composes in the given state
+// sorted by their creation time, unlike a status request, it doesn't advance
+// the composes
+func (s *Server) composesInState(state common.ImageBuildState) []weldr.ComposeEntryV0 {
+	entries := []weldr.ComposeEntryV0{}
+	for _, compose := range s.composes {
+		if compose.QueueStatus == state {
+			entries = append(entries, compose.ComposeEntryV0)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].JobCreated < entries[j].JobCreated
+	})
+	return entries
+}
+
+func (s *Server) getComposeQueue(w http.ResponseWriter, _ *http.Request, _ string) {
+	writeJSON(w, http.StatusOK, weldr.ComposeQueueResponseV0{
+		New: s.composesInState(common.IBWaiting),
+		Run: s.composesInState(common.IBRunning),
+	})
+}
+
+// getComposesInState returns the handler of compose/finished or
+// compose/failed, key is the key of the list in the response
+func (s *Server) getComposesInState(state common.ImageBuildState, key string) func(w http.ResponseWriter, r *http.Request, arg string) {
+	return func(w http.ResponseWriter, _ *http.Request, _ string) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{key: s.composesInState(state)})
+	}
+}
+
+func (s *Server) getComposeTypes(w http.ResponseWriter, r *http.Request, _ string) {
+	distro := r.URL.Query().Get("distro")
+	arch := r.URL.Query().Get("arch")
+
+	types, ok := s.imageTypesFor(distro, arch)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "DistroError", fmt.Sprintf("Invalid distro or arch: %s/%s", distro, arch))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, weldr.ComposeTypesResponseV0{Types: types})
+}
+
+// finishedCompose returns the compose with the given id if it's finished,
+// otherwise it writes an error response and returns nil
+func (s *Server) finishedCompose(w http.ResponseWriter, id string) *Compose {
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "UnknownUUID", fmt.Sprintf("%s is not a valid build uuid", id))
+		return nil
+	}
+
+	compose, ok := s.composes[parsed]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "UnknownUUID", fmt.Sprintf("Compose %s doesn't exist", id))
+		return nil
+	}
+
+	if compose.QueueStatus != common.IBFinished && compose.QueueStatus != common.IBFailed {
+		writeError(w, http.StatusBadRequest, "BuildInWrongState", fmt.Sprintf("Build %s is in wrong state: %s", id, compose.QueueStatus.ToString()))
+		return nil
+	}
+
+	return compose
+}
+
+// artifactHandler serves the artifact with range requests support, if digest
+// is true, the Digest header with the artifact's sha-256 is sent as well. The
+// compose is looked up with the server locked, the returned function streams
+// the artifact without the lock.
+func (s *Server) artifactHandler(artifact []byte, digest bool) func(w http.ResponseWriter, r *http.Request, arg string) func() {
+	return func(w http.ResponseWriter, r *http.Request, id string) func() {
+		compose := s.finishedCompose(w, id)
+		if compose == nil {
+			return nil
+		}
+		name := compose.ID.String()
+
+		if digest {
+			sum := sha256.Sum256(artifact)
+			w.Header().Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum[:]))
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		return func() {
+			http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(artifact))
+		}
+	}
+}
+
+func (s *Server) getComposeMetadata(w http.ResponseWriter, _ *http.Request, id string) {
+	compose := s.finishedCompose(w, id)
+	if compose == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-tar")
+	tw := tar.NewWriter(w)
+	_ = tw.WriteHeader(&tar.Header{
+		Name:    compose.ID.String() + ".json",
+		Mode:    0644,
+		Size:    int64(len(s.Manifest)),
+		ModTime: time.Now().Truncate(time.Second),
+	})
+	_, _ = tw.Write(s.Manifest)
+	_ = tw.Close()
+}
+
+func (s *Server) deleteCompose(w http.ResponseWriter, _ *http.Request, arg string) {
+	var response weldr.DeleteComposeResponseV0
+	response.UUIDs = []weldr.DeleteComposeStatusV0{}
+	response.Errors = []weldr.ResponseError{}
+
+	for _, id := range strings.Split(arg, ",") {
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			response.Errors = append(response.Errors, weldr.ResponseError{ID: "UnknownUUID", Msg: fmt.Sprintf("%s is not a valid uuid", id)})
+			continue
+		}
+
+		compose, ok := s.composes[parsed]
+		if !ok {
+			response.Errors = append(response.Errors, weldr.ResponseError{ID: "UnknownUUID", Msg: fmt.Sprintf("compose %s doesn't exist", id)})
+			continue
+		}
+		if compose.QueueStatus != common.IBFinished && compose.QueueStatus != common.IBFailed {
+			response.Errors = append(response.Errors, weldr.ResponseError{ID: "BuildInWrongState", Msg: fmt.Sprintf("Compose %s is not in FINISHED or FAILED.", id)})
+			continue
+		}
+
+		delete(s.composes, parsed)
+		response.UUIDs = append(response.UUIDs, weldr.DeleteComposeStatusV0{UUID: parsed, Status: true})
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) cancelCompose(w http.ResponseWriter, _ *http.Request, id string) {
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "UnknownUUID", fmt.Sprintf("%s is not a valid build uuid", id))
+		return
+	}
+
+	compose, ok := s.composes[parsed]
+	if !ok {
+		writeError(w, http.StatusBadRequest, "UnknownUUID", fmt.Sprintf("Compose %s doesn't exist", id))
+		return
+	}
+	if compose.QueueStatus != common.IBWaiting && compose.QueueStatus != common.IBRunning {
+		writeError(w, http.StatusBadRequest, "BuildInWrongState", fmt.Sprintf("Build %s is not in WAITING or RUNNING.", id))
+		return
+	}
+
+	compose.states = []common.ImageBuildState{common.IBFailed}
+	compose.setState(common.IBFailed)
+
+	writeJSON(w, http.StatusOK, weldr.CancelComposeStatusV0{UUID: parsed, Status: true})
+}